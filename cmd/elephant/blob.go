@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+func runBlob(env *environment, args []string) error {
+	if len(args) == 0 {
+		newFlagSet(env, "blob").Usage()
+		return errors.New("blob expects a subcommand")
+	}
+	expected := map[string]int{"ls": 0, "get": 1, "put": 1, "rm": 1}
+	count, exists := expected[args[0]]
+	if !exists {
+		newFlagSet(env, "blob").Usage()
+		return fmt.Errorf("unknown blob subcommand: %s", args[0])
+	}
+	if len(args)-1 != count {
+		newFlagSet(env, "blob").Usage()
+		return fmt.Errorf("blob %s expects %d arguments, got %d", args[0], count, len(args)-1)
+	}
+	if count == 1 {
+		if err := checkID(args[1]); err != nil {
+			return err
+		}
+	}
+	driver, err := env.openDriver()
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	switch args[0] {
+	case "ls":
+		l, err := lister(driver)
+		if err != nil {
+			return err
+		}
+		ids, err := l.BlobIDs()
+		for _, id := range ids {
+			fmt.Fprintln(env.stdout, id)
+		}
+		return err
+	case "get":
+		blob, err := driver.BlobRetrieve(args[1])
+		if err != nil {
+			return err
+		}
+		_, err = env.stdout.Write(*blob)
+		return err
+	case "put":
+		input, err := readLimited(env.stdin, db.MaxBlobLength)
+		if err != nil {
+			return err
+		}
+		exists, err := driver.BlobExists(args[1])
+		if err != nil {
+			return err
+		}
+		if exists {
+			return driver.BlobUpdate(args[1], &input)
+		}
+		return driver.BlobCreate(args[1], &input)
+	default:
+		exists, err := driver.BlobExists(args[1])
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("blob %s not found", args[1])
+		}
+		return driver.BlobRemove(args[1])
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gonimals/elephant/pkg/elephant"
+)
+
+func runCopy(env *environment, args []string) error {
+	flags := newFlagSet(env, "copy")
+	prune := flags.Bool("prune", false, "remove from the destination the elements which are not in the source")
+	tables := flags.String("tables", "", "comma separated tables to copy, \""+elephant.BlobsTable+"\" included. All by default")
+	args, err := parseArgs(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	options := elephant.CopyOptions{Prune: *prune}
+	if *tables != "" {
+		options.Tables = strings.Split(*tables, ",")
+	}
+	stats, err := elephant.Copy(args[0], args[1], options)
+	w := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tCOPIED\tSKIPPED\tREMOVED\tCOUNT\tCHECKSUM")
+	for _, table := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", table.Table, table.Copied, table.Skipped, table.Removed, table.Count, table.Checksum)
+	}
+	w.Flush()
+	return err
+}
@@ -0,0 +1,141 @@
+// Command elephant inspects and edits the databases used by the elephant library,
+// using the same drivers and limits. Run it without arguments to list the commands
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/elephant"
+)
+
+// command is a subcommand of the tool. run receives the arguments after the command name
+type command struct {
+	usage       string
+	description string
+	run         func(env *environment, args []string) error
+}
+
+// environment is what commands work with. Tests replace the standard streams
+type environment struct {
+	uri    string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// commands is filled in init because the commands use it to print their usage
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"tables": {"tables", "lists the tables", runTables},
+		"ls":     {"ls TABLE", "lists the ids of a table", runLs},
+		"get":    {"get TABLE ID", "prints a stored value", runGet},
+		"put": {"put [-idfield Id] TABLE ID < value.json",
+			"stores a JSON object read from stdin, replacing the previous one. Its id field must be ID", runPut},
+		"rm": {"rm TABLE ID", "removes a stored value", runRm},
+		"blob": {"blob ls | get ID > file | put ID < file | rm ID",
+			"lists, prints, stores or removes blobs", runBlob},
+		"export": {"export > backup.jsonl", "writes a backup of every table and blob (see elephant.Backup)", runExport},
+		"import": {"import [-merge] < backup.jsonl",
+			"restores a backup, replacing everything unless -merge is used (see elephant.Restore)", runImport},
+		"stats": {"stats", "prints the number and size of the elements of every table and of the blobs", runStats},
+		"copy": {"copy [-prune] [-tables a,b] SRC_URI DST_URI",
+			"copies every table and blob, resuming previous copies and verifying the result", runCopy},
+		"serve": {"serve [-listen addr | -listen unix:/path] [-token TOKEN]",
+			"serves the store, with its cache, to the programs using the printed remote: uri, until interrupted", runServe},
+	}
+}
+
+func main() {
+	env := &environment{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	os.Exit(run(env, os.Args[1:]))
+}
+
+// run executes the command line and returns the exit code
+func run(env *environment, args []string) int {
+	flags := flag.NewFlagSet("elephant", flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	flags.StringVar(&env.uri, "uri", os.Getenv("ELEPHANT_URI"), "uri of the database, like sqlite3:file.db. Defaults to $ELEPHANT_URI")
+	flags.Usage = func() { printUsage(env.stderr, flags) }
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	cmd, exists := commands[flags.Arg(0)]
+	if !exists {
+		fmt.Fprintln(env.stderr, "unknown command:", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+	err := cmd.run(env, flags.Args()[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		fmt.Fprintln(env.stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: elephant [-uri URI] COMMAND [ARGUMENTS]")
+	flags.PrintDefaults()
+	fmt.Fprintln(w, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n    \t%s\n", commands[name].usage, commands[name].description)
+	}
+}
+
+// newFlagSet creates the flags of a command, which print its usage on errors
+func newFlagSet(env *environment, name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: elephant", commands[name].usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses the flags and checks the number of remaining arguments
+func parseArgs(flags *flag.FlagSet, args []string, expected int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != expected {
+		flags.Usage()
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", flags.Name(), expected, flags.NArg())
+	}
+	return flags.Args(), nil
+}
+
+// checkURI returns an error if no database was given
+func (env *environment) checkURI() error {
+	if env.uri == "" {
+		return errors.New("no database: use -uri or set ELEPHANT_URI")
+	}
+	return nil
+}
+
+// openDriver connects to the database of the -uri flag
+func (env *environment) openDriver() (db.Driver, error) {
+	if err := env.checkURI(); err != nil {
+		return nil, err
+	}
+	return elephant.OpenDriver(env.uri)
+}
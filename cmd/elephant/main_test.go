@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonimals/elephant/internal/db/memory"
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/elephant"
+)
+
+const memoryTestDB = "elephantcli"
+
+// execute runs the command line with the memory test database and returns its exit code and outputs
+func execute(stdin string, args ...string) (code int, stdout string, stderr string) {
+	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+	env := &environment{stdin: strings.NewReader(stdin), stdout: out, stderr: errOut}
+	code = run(env, append([]string{"-uri", "memory:" + memoryTestDB}, args...))
+	return code, out.String(), errOut.String()
+}
+
+func TestCommands(t *testing.T) {
+	memory.Drop(memoryTestDB)
+	defer memory.Drop(memoryTestDB)
+
+	if code, _, stderr := execute("{\n  \"Id\": \"1\",\n  \"Name\": \"first \\\" one\"\n}\n", "put", "Order", "1"); code != 0 {
+		t.Error("put failed:", stderr)
+	}
+	execute(`{"Id":"2"}`, "put", "Order", "2")
+	execute(`{"Id":"1"}`, "put", "Customer", "1")
+	if code, _, _ := execute(`not json`, "put", "Order", "3"); code != 1 {
+		t.Error("put should reject invalid JSON")
+	}
+	if code, _, _ := execute(`{"Id":"1"}`, "put", "Invalid-Table", "1"); code != 1 {
+		t.Error("put should reject invalid table names")
+	}
+	if code, _, _ := execute(`{"Id":"4"}`, "put", "Order", "3"); code != 1 {
+		t.Error("put should reject values whose id is not the key")
+	}
+	if code, _, _ := execute(`["3"]`, "put", "Order", "3"); code != 1 {
+		t.Error("put should reject values which are not objects")
+	}
+	if code, _, stderr := execute(`{"Code":"3"}`, "put", "-idfield", "Code", "Order", "3"); code != 0 {
+		t.Error("put should check the field given with -idfield:", stderr)
+	}
+	execute("", "rm", "Order", "3")
+	if _, stdout, _ := execute("", "get", "Order", "1"); stdout != `{"Id":"1","Name":"first \" one"}`+"\n" {
+		t.Error("get does not print the compacted value:", stdout)
+	}
+	if code, _, _ := execute("", "get", "Order", "3"); code != 1 {
+		t.Error("get should fail with unexistent ids")
+	}
+	if _, stdout, _ := execute("", "tables"); stdout != "Customer\nOrder\n" {
+		t.Error("tables gives wrong output:", stdout)
+	}
+	if _, stdout, _ := execute("", "ls", "Order"); stdout != "1\n2\n" {
+		t.Error("ls gives wrong output:", stdout)
+	}
+	if code, _, stderr := execute("", "rm", "Order", "2"); code != 0 {
+		t.Error("rm failed:", stderr)
+	}
+	if code, _, _ := execute("", "rm", "Order", "2"); code != 1 {
+		t.Error("rm should fail with unexistent ids")
+	}
+	indented := "{" + strings.Repeat(" ", db.MaxValueLength) + `"Id":"2"}`
+	if code, _, stderr := execute(indented, "put", "Customer", "2"); code != 0 {
+		t.Error("put should limit the length of the compacted value:", stderr)
+	}
+	execute("", "rm", "Customer", "2")
+	if code, _, _ := execute(`{"Id":"`+strings.Repeat("A", db.MaxValueLength)+`"}`, "put", "Customer", "2"); code != 1 {
+		t.Error("put should reject values too long")
+	}
+
+	if code, _, stderr := execute("\x00\x01", "blob", "put", "b1"); code != 0 {
+		t.Error("blob put failed:", stderr)
+	}
+	if _, stdout, _ := execute("", "blob", "get", "b1"); stdout != "\x00\x01" {
+		t.Errorf("blob get gives wrong output: %q", stdout)
+	}
+	if _, stdout, _ := execute("", "blob", "ls"); stdout != "b1\n" {
+		t.Error("blob ls gives wrong output:", stdout)
+	}
+	_, stats, _ := execute("", "stats")
+	for _, expected := range []string{"Customer 1 10", "Order 1 32", "blobs 1 2"} {
+		if !slices.ContainsFunc(strings.Split(stats, "\n"), func(line string) bool {
+			return strings.Join(strings.Fields(line), " ") == expected
+		}) {
+			t.Errorf("stats does not contain %q:\n%s", expected, stats)
+		}
+	}
+
+	_, backup, stderr := execute("", "export")
+	if !strings.HasPrefix(backup, `{"format":"elephant-backup"`) {
+		t.Fatal("export failed:", stderr)
+	}
+	execute("", "blob", "rm", "b1")
+	execute(`{"Id":"9"}`, "put", "Order", "9")
+	if code, _, stderr := execute(backup, "import"); code != 0 {
+		t.Error("import failed:", stderr)
+	}
+	if _, stdout, _ := execute("", "ls", "Order"); stdout != "1\n" {
+		t.Error("import does not replace the contents:", stdout)
+	}
+	if _, stdout, _ := execute("", "blob", "ls"); stdout != "b1\n" {
+		t.Error("import does not restore blobs:", stdout)
+	}
+
+	if code, _, _ := execute("", "unknown"); code != 2 {
+		t.Error("unknown commands should fail with code 2")
+	}
+	if code, _, _ := execute("", "get", "Order"); code != 1 {
+		t.Error("commands should check the number of arguments")
+	}
+	env := &environment{stdout: new(bytes.Buffer), stderr: new(bytes.Buffer)}
+	if code := run(env, []string{"-uri", "", "tables"}); code != 1 {
+		t.Error("commands should fail without uri")
+	}
+}
+
+// serveCheck is stored through the store served by TestServe
+type serveCheck struct {
+	Id   string `db:"id"`
+	Name string
+}
+
+func TestServe(t *testing.T) {
+	memory.Drop(memoryTestDB)
+	defer memory.Drop(memoryTestDB)
+	socket := filepath.Join(t.TempDir(), "elephant.sock")
+	done := make(chan int)
+	go func() {
+		code, _, _ := execute("", "serve", "-listen", "unix:"+socket, "-token", "secret")
+		done <- code
+	}()
+	for range 100 {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	uri := "remote:unix:" + socket + "?token=secret"
+
+	if err := elephant.Initialize(strings.Replace(uri, "secret", "wrong", 1)); err == nil {
+		elephant.Close()
+		t.Error("clients with a wrong token should be rejected")
+	}
+	if err := elephant.Initialize(uri); err != nil {
+		t.Fatal("cannot connect to the server:", err)
+	}
+	if _, err := elephant.Create(&serveCheck{Id: "1", Name: "first"}); err != nil {
+		t.Error("create through the server failed:", err)
+	}
+	if element, _ := elephant.Retrieve[serveCheck]("1"); element == nil || element.Name != "first" {
+		t.Error("retrieve through the server gives wrong output:", element)
+	}
+	elephant.Close()
+	out := new(bytes.Buffer)
+	env := &environment{stdin: strings.NewReader(""), stdout: out, stderr: new(bytes.Buffer)}
+	if code := run(env, []string{"-uri", uri, "export"}); code != 0 || !strings.Contains(out.String(), `"table":"serveCheck"`) {
+		t.Error("export through the server failed:", out.String())
+	}
+
+	process, _ := os.FindProcess(os.Getpid())
+	if err := process.Signal(os.Interrupt); err != nil {
+		t.Skip("cannot interrupt the server:", err)
+	}
+	select {
+	case code := <-done:
+		if code != 0 {
+			t.Error("serve should exit cleanly when interrupted, got", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("serve did not stop when interrupted")
+	}
+	if _, stdout, _ := execute("", "get", "serveCheck", "1"); stdout != `{"Id":"1","Name":"first"}`+"\n" {
+		t.Error("the server should write in its database:", stdout)
+	}
+}
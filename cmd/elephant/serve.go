@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/gonimals/elephant/pkg/elephant"
+)
+
+func runServe(env *environment, args []string) error {
+	flags := newFlagSet(env, "serve")
+	listen := flags.String("listen", "localhost:7707", "TCP address or unix:/path/to/socket to listen on")
+	token := flags.String("token", os.Getenv("ELEPHANT_TOKEN"), "token required to the clients. Defaults to $ELEPHANT_TOKEN, or a random one")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	if err := env.checkURI(); err != nil {
+		return err
+	}
+	if strings.HasPrefix(env.uri, "remote:") {
+		return errors.New("cannot serve a remote store")
+	}
+	shownToken := "TOKEN"
+	if *token == "" {
+		*token = rand.Text()
+		shownToken = *token
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := elephant.Initialize(env.uri); err != nil {
+		return err
+	}
+	defer elephant.Close()
+	var listener net.Listener
+	var err error
+	if path, isUnix := strings.CutPrefix(*listen, "unix:"); isUnix {
+		listener, err = net.Listen("unix", path)
+	} else {
+		listener, err = net.Listen("tcp", *listen)
+	}
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: elephant.NewRemoteHandler(*token)}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	fmt.Fprintf(env.stderr, "serving %s on %s, use -uri remote:%s?token=%s\n", env.uri, *listen, clientAddress(listener), shownToken)
+	if err = server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// clientAddress returns what clients must append to "remote:" to connect to listener
+func clientAddress(listener net.Listener) string {
+	if listener.Addr().Network() == "unix" {
+		return "unix:" + listener.Addr().String()
+	}
+	return "http://" + listener.Addr().String()
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/elephant"
+)
+
+// lister returns the driver as a db.Lister, if it implements it
+func lister(driver db.Driver) (db.Lister, error) {
+	output, isLister := driver.(db.Lister)
+	if !isLister {
+		return nil, errors.New("the driver cannot list its contents")
+	}
+	return output, nil
+}
+
+// checkTable returns an error if the table name cannot be used with the driver
+func checkTable(driver db.Driver, table string) error {
+	if !db.IsValidTableName(table, driver.GetContextSymbol()) {
+		return fmt.Errorf("invalid table name: %s", table)
+	}
+	return nil
+}
+
+// checkElement returns an error if the table or the id cannot be used with the driver
+func checkElement(driver db.Driver, table string, id string) error {
+	if err := checkTable(driver, table); err != nil {
+		return err
+	}
+	return checkID(id)
+}
+
+// checkID returns an error if the id is empty or too long
+func checkID(id string) error {
+	if id == "" || len(id) > db.MaxIdLength {
+		return fmt.Errorf("ids must have between 1 and %d bytes", db.MaxIdLength)
+	}
+	return nil
+}
+
+// readLimited reads r, failing if it has more than limit bytes
+func readLimited(r io.Reader, limit int) ([]byte, error) {
+	input, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(input) > limit {
+		return nil, fmt.Errorf("input too big (more than %d bytes)", limit)
+	}
+	return input, nil
+}
+
+func runTables(env *environment, args []string) error {
+	if _, err := parseArgs(newFlagSet(env, "tables"), args, 0); err != nil {
+		return err
+	}
+	driver, err := env.openDriver()
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	l, err := lister(driver)
+	if err != nil {
+		return err
+	}
+	tables, err := l.Tables()
+	for _, table := range tables {
+		fmt.Fprintln(env.stdout, table)
+	}
+	return err
+}
+
+func runLs(env *environment, args []string) error {
+	args, err := parseArgs(newFlagSet(env, "ls"), args, 1)
+	if err != nil {
+		return err
+	}
+	driver, err := env.openDriver()
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	if err = checkTable(driver, args[0]); err != nil {
+		return err
+	}
+	var ids []string
+	err = db.Scan(driver, args[0], func(id string, value string) error {
+		ids = append(ids, id)
+		return nil
+	})
+	slices.Sort(ids)
+	for _, id := range ids {
+		fmt.Fprintln(env.stdout, id)
+	}
+	return err
+}
+
+func runGet(env *environment, args []string) error {
+	args, err := parseArgs(newFlagSet(env, "get"), args, 2)
+	if err != nil {
+		return err
+	}
+	driver, err := env.openDriver()
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	if err = checkElement(driver, args[0], args[1]); err != nil {
+		return err
+	}
+	value, err := driver.Retrieve(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		return fmt.Errorf("%s %s not found", args[0], args[1])
+	}
+	fmt.Fprintln(env.stdout, value)
+	return nil
+}
+
+// compactReader drops the whitespace of the JSON read from r which is outside strings,
+// so the input can be limited by its compacted length while it is read
+type compactReader struct {
+	r        io.Reader
+	inString bool
+	escaped  bool
+}
+
+func (c *compactReader) Read(p []byte) (n int, err error) {
+	for n == 0 && err == nil {
+		var read int
+		read, err = c.r.Read(p)
+		for _, b := range p[:read] {
+			switch {
+			case c.escaped:
+				c.escaped = false
+			case c.inString && b == '\\':
+				c.escaped = true
+			case b == '"':
+				c.inString = !c.inString
+			case !c.inString && (b == ' ' || b == '\t' || b == '\n' || b == '\r'):
+				continue
+			}
+			p[n] = b
+			n++
+		}
+	}
+	return
+}
+
+func runPut(env *environment, args []string) error {
+	flags := newFlagSet(env, "put")
+	idField := flags.String("idfield", "Id", "JSON name of the field tagged with `db:\"id\"`, which must be the ID argument")
+	args, err := parseArgs(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	input, err := readLimited(&compactReader{r: env.stdin}, db.MaxValueLength)
+	if err != nil {
+		return fmt.Errorf("the value is too long (more than %d bytes once compacted)", db.MaxValueLength)
+	}
+	value := new(bytes.Buffer)
+	if err = json.Compact(value, input); err != nil {
+		return fmt.Errorf("the value is not valid JSON: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	var id string
+	if err = json.Unmarshal(value.Bytes(), &fields); err != nil {
+		return fmt.Errorf("the value is not a JSON object: %v", err)
+	}
+	if err = json.Unmarshal(fields[*idField], &id); err != nil || id != args[1] {
+		return fmt.Errorf("the value must have the id %s in its %s field (use -idfield to choose it)", args[1], *idField)
+	}
+	driver, err := env.openDriver()
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	if err = checkElement(driver, args[0], args[1]); err != nil {
+		return err
+	}
+	stored, err := driver.Retrieve(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if stored == "" {
+		return driver.Create(args[0], args[1], value.String())
+	}
+	return driver.Update(args[0], args[1], value.String())
+}
+
+func runRm(env *environment, args []string) error {
+	args, err := parseArgs(newFlagSet(env, "rm"), args, 2)
+	if err != nil {
+		return err
+	}
+	driver, err := env.openDriver()
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	if err = checkElement(driver, args[0], args[1]); err != nil {
+		return err
+	}
+	stored, err := driver.Retrieve(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if stored == "" {
+		return fmt.Errorf("%s %s not found", args[0], args[1])
+	}
+	return driver.Remove(args[0], args[1])
+}
+
+func runStats(env *environment, args []string) error {
+	if _, err := parseArgs(newFlagSet(env, "stats"), args, 0); err != nil {
+		return err
+	}
+	driver, err := env.openDriver()
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	l, err := lister(driver)
+	if err != nil {
+		return err
+	}
+	tables, err := l.Tables()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "TABLE\tELEMENTS\tBYTES")
+	for _, table := range tables {
+		elements, size := 0, 0
+		err = db.Scan(driver, table, func(id string, value string) error {
+			elements++
+			size += len(value)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\n", table, elements, size)
+	}
+	ids, err := l.BlobIDs()
+	if err != nil {
+		return err
+	}
+	size := 0
+	for _, id := range ids {
+		blob, err := driver.BlobRetrieve(id)
+		if err != nil {
+			return err
+		}
+		size += len(*blob)
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\n", elephant.BlobsTable, len(ids), size)
+	return nil
+}
+
+func runExport(env *environment, args []string) error {
+	if _, err := parseArgs(newFlagSet(env, "export"), args, 0); err != nil {
+		return err
+	}
+	if err := env.checkURI(); err != nil {
+		return err
+	}
+	if err := elephant.Initialize(env.uri); err != nil {
+		return err
+	}
+	defer elephant.Close()
+	return elephant.Backup(env.stdout)
+}
+
+func runImport(env *environment, args []string) error {
+	flags := newFlagSet(env, "import")
+	merge := flags.Bool("merge", false, "keep the stored elements which are not in the backup")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	mode := elephant.RestoreReplace
+	if *merge {
+		mode = elephant.RestoreMerge
+	}
+	if err := env.checkURI(); err != nil {
+		return err
+	}
+	if err := elephant.Initialize(env.uri); err != nil {
+		return err
+	}
+	defer elephant.Close()
+	return elephant.Restore(env.stdin, mode)
+}
@@ -0,0 +1,518 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// MaxIdLength sets the maximum string length for table ids
+const MaxIdLength = db.MaxIdLength
+
+// blobsKey groups blob sizes. It cannot collide with valid table names
+const blobsKey = "blobs\x00"
+
+// fileHeader is written at the beginning of every log file
+const fileHeader = "elephant-kv-1\n"
+
+// Log files are compacted when they are bigger than compactionMinSize and
+// less than half of their contents is alive
+const compactionMinSize = 1024 * 1024 //1MB
+
+// recordHeaderLength is the size of the length and checksum of each record
+const recordHeaderLength = 8
+
+// maxPayloadLength is bigger than any valid record, so corrupted lengths are detected
+const maxPayloadLength = 1024 * 1024 //1MB
+
+// These are the record operations
+const (
+	opPut = iota + 1
+	opDelete
+	opBlobPut
+	opBlobDelete
+)
+
+type driver struct {
+	mutex     sync.Mutex
+	path      string
+	file      *os.File
+	tables    map[string]map[string]string
+	blobs     map[string][]byte
+	fileSize  int64 //bytes written to the log, header included
+	liveSize  int64 //bytes of the records which are still needed
+	liveSizes map[string]map[string]int64
+	logger    *slog.Logger
+}
+
+// Connect opens or creates the log file and replays it to restore the contents.
+// The file is locked, so only one process can use it at a time.
+// An incomplete last record is discarded, but corrupted records make Connect fail
+func Connect(path string) (output *driver, err error) {
+	output = &driver{
+		path:      path,
+		tables:    make(map[string]map[string]string),
+		blobs:     make(map[string][]byte),
+		liveSizes: make(map[string]map[string]int64),
+		logger:    slog.New(slog.DiscardHandler),
+	}
+	output.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, util.Errorf("kv: cannot open %s: %v", path, err)
+	}
+	if err = lockFile(output.file); err != nil {
+		output.file.Close()
+		return nil, util.Errorf("kv: cannot lock %s, it may be in use by another process: %v", path, err)
+	}
+	err = output.recover()
+	if err != nil {
+		output.file.Close()
+		return nil, err
+	}
+	if output.needsCompaction() {
+		err = output.compact()
+		if err != nil {
+			output.file.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+// SetLogger makes the driver log the compactions which fail
+func (d *driver) SetLogger(logger *slog.Logger) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.logger = logger
+}
+
+func (d *driver) Close() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.file.Close()
+}
+
+// recover replays the log file, truncating it after the last valid record
+// when the invalid ones are a torn write at the end of the file
+func (d *driver) recover() error {
+	info, err := d.file.Stat()
+	if err != nil {
+		return util.Errorf("kv: cannot stat %s: %v", d.path, err)
+	}
+	if info.Size() == 0 {
+		return d.writeHeader(d.file)
+	}
+	reader := bufio.NewReader(d.file)
+	header := make([]byte, len(fileHeader))
+	if _, err := io.ReadFull(reader, header); err != nil || string(header) != fileHeader {
+		return util.Errorf("kv: %s is not an elephant kv file", d.path)
+	}
+	d.fileSize = int64(len(fileHeader))
+	d.liveSize = d.fileSize
+	for {
+		payload, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = d.apply(payload)
+		}
+		if err == nil {
+			continue
+		}
+		if !d.isTornTail(info.Size()) {
+			return util.Errorf("kv: %s is corrupted at offset %d: %v", d.path, d.fileSize, err)
+		}
+		break
+	}
+	if d.fileSize < info.Size() {
+		// Discard the incomplete or corrupted tail
+		if err := d.file.Truncate(d.fileSize); err != nil {
+			return util.Errorf("kv: cannot truncate %s: %v", d.path, err)
+		}
+		if err := d.file.Sync(); err != nil {
+			return util.Errorf("kv: cannot sync %s: %v", d.path, err)
+		}
+	}
+	_, err = d.file.Seek(d.fileSize, io.SeekStart)
+	return err
+}
+
+// isTornTail checks if the invalid record at fileSize is incomplete, or is only
+// followed by zeros, as left by an interrupted write. Complete records failing
+// their checksum are corruption, even at the end of the file
+func (d *driver) isTornTail(size int64) bool {
+	var header [recordHeaderLength]byte
+	if _, err := d.file.ReadAt(header[:], d.fileSize); err != nil {
+		return true // not even the header is complete
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length <= maxPayloadLength && d.fileSize+recordHeaderLength+int64(length) > size {
+		return true // the payload is incomplete
+	}
+	rest := io.NewSectionReader(d.file, d.fileSize, size-d.fileSize)
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := rest.Read(buffer)
+		for _, b := range buffer[:n] {
+			if b != 0 {
+				return false
+			}
+		}
+		if err != nil {
+			return err == io.EOF
+		}
+	}
+}
+
+func (d *driver) writeHeader(file *os.File) error {
+	if _, err := file.WriteString(fileHeader); err != nil {
+		return util.Errorf("kv: cannot write header: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return util.Errorf("kv: cannot sync: %v", err)
+	}
+	d.fileSize = int64(len(fileHeader))
+	d.liveSize = d.fileSize
+	return nil
+}
+
+// readRecord returns the payload of the next record, checking its integrity
+func readRecord(reader io.Reader) ([]byte, error) {
+	var header [recordHeaderLength]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxPayloadLength {
+		return nil, errors.New("record too long")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("checksum mismatch")
+	}
+	return payload, nil
+}
+
+// encodeRecord builds a record with the operation, table, id and value
+func encodeRecord(op byte, table string, id string, value []byte) []byte {
+	payload := []byte{op}
+	payload = binary.AppendUvarint(payload, uint64(len(table)))
+	payload = append(payload, table...)
+	payload = binary.AppendUvarint(payload, uint64(len(id)))
+	payload = append(payload, id...)
+	payload = append(payload, value...)
+	record := make([]byte, recordHeaderLength, recordHeaderLength+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	return append(record, payload...)
+}
+
+// decodeRecord splits a record payload
+func decodeRecord(payload []byte) (op byte, table string, id string, value []byte, err error) {
+	if len(payload) == 0 {
+		return 0, "", "", nil, errors.New("empty record")
+	}
+	op = payload[0]
+	if op < opPut || op > opBlobDelete {
+		return 0, "", "", nil, errors.New("unknown operation")
+	}
+	rest := payload[1:]
+	var fields [2]string
+	for i := range fields {
+		length, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < length {
+			return 0, "", "", nil, errors.New("malformed record")
+		}
+		fields[i] = string(rest[n : n+int(length)])
+		rest = rest[n+int(length):]
+	}
+	return op, fields[0], fields[1], rest, nil
+}
+
+// apply updates the in-memory state with a record payload read from the log
+func (d *driver) apply(payload []byte) error {
+	op, table, id, value, err := decodeRecord(payload)
+	if err != nil {
+		return err
+	}
+	d.track(op, table, id, int64(recordHeaderLength+len(payload)))
+	switch op {
+	case opPut:
+		if d.tables[table] == nil {
+			d.tables[table] = make(map[string]string)
+		}
+		d.tables[table][id] = string(value)
+	case opDelete:
+		delete(d.tables[table], id)
+	case opBlobPut:
+		d.blobs[id] = value
+	case opBlobDelete:
+		delete(d.blobs, id)
+	}
+	return nil
+}
+
+// track keeps the sizes used to decide when to compact the log
+func (d *driver) track(op byte, table string, id string, recordSize int64) {
+	if op == opBlobPut || op == opBlobDelete {
+		table = blobsKey
+	}
+	if d.liveSizes[table] == nil {
+		d.liveSizes[table] = make(map[string]int64)
+	}
+	d.fileSize += recordSize
+	d.liveSize -= d.liveSizes[table][id]
+	if op == opPut || op == opBlobPut {
+		d.liveSizes[table][id] = recordSize
+		d.liveSize += recordSize
+	} else {
+		delete(d.liveSizes[table], id)
+	}
+}
+
+// write appends a record to the log, syncs it to disk and applies it
+func (d *driver) write(op byte, table string, id string, value []byte) error {
+	record := encodeRecord(op, table, id, value)
+	if _, err := d.file.Write(record); err != nil {
+		// Leave the file as it was before the failed write
+		d.file.Truncate(d.fileSize)
+		d.file.Seek(d.fileSize, io.SeekStart)
+		return util.Errorf("kv: cannot write: %v", err)
+	}
+	if err := d.file.Sync(); err != nil {
+		// The record may not be on disk, so it is neither applied nor kept
+		d.file.Truncate(d.fileSize)
+		d.file.Seek(d.fileSize, io.SeekStart)
+		return util.Errorf("kv: cannot sync: %v", err)
+	}
+	if err := d.apply(record[recordHeaderLength:]); err != nil {
+		return util.Errorf("kv: cannot apply record: %v", err)
+	}
+	if d.needsCompaction() {
+		// The record is already stored, so the write succeeded anyway.
+		// The compaction is attempted again with the next write
+		if err := d.compact(); err != nil {
+			d.logger.Error("kv compaction failed", "path", d.path, "error", err)
+		}
+	}
+	return nil
+}
+
+func (d *driver) needsCompaction() bool {
+	return d.fileSize > compactionMinSize && d.liveSize*2 < d.fileSize
+}
+
+// compact rewrites the log with only the alive records and replaces the current one
+func (d *driver) compact() error {
+	temporaryPath := d.path + ".compact"
+	file, err := os.OpenFile(temporaryPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return util.Errorf("kv: cannot create compacted file: %v", err)
+	}
+	writer := bufio.NewWriter(file)
+	size := int64(len(fileHeader))
+	writer.WriteString(fileHeader)
+	liveSizes := make(map[string]map[string]int64)
+	for table, rows := range d.tables {
+		liveSizes[table] = make(map[string]int64)
+		for id, value := range rows {
+			record := encodeRecord(opPut, table, id, []byte(value))
+			writer.Write(record)
+			liveSizes[table][id] = int64(len(record))
+			size += int64(len(record))
+		}
+	}
+	liveSizes[blobsKey] = make(map[string]int64)
+	for id, value := range d.blobs {
+		record := encodeRecord(opBlobPut, "", id, value)
+		writer.Write(record)
+		liveSizes[blobsKey][id] = int64(len(record))
+		size += int64(len(record))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		// The lock must be held before the compacted file is visible at path
+		err = lockFile(file)
+	}
+	if err == nil {
+		err = os.Rename(temporaryPath, d.path)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(temporaryPath)
+		return util.Errorf("kv: cannot write compacted file: %v", err)
+	}
+	if directory, err := os.Open(filepath.Dir(d.path)); err == nil {
+		directory.Sync()
+		directory.Close()
+	}
+	d.file.Close()
+	d.file = file
+	d.fileSize = size
+	d.liveSize = size
+	d.liveSizes = liveSizes
+	return nil
+}
+
+// checkTable returns an error if the table name is not valid
+func (d *driver) checkTable(inputType string) error {
+	if !db.IsValidTableName(inputType, d.GetContextSymbol()) {
+		return util.Errorf("kv: invalid table name: %s", inputType)
+	}
+	return nil
+}
+
+func (d *driver) Retrieve(inputType string, id string) (output string, err error) {
+	if err = d.checkTable(inputType); err != nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.tables[inputType][id], nil
+}
+
+func (d *driver) RetrieveAll(inputType string) (output map[string]string, err error) {
+	if err = d.checkTable(inputType); err != nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	output = make(map[string]string, len(d.tables[inputType]))
+	for id, value := range d.tables[inputType] {
+		output[id] = value
+	}
+	return
+}
+
+func (d *driver) Create(inputType string, id string, input string) (err error) {
+	if err = d.checkTable(inputType); err != nil {
+		return
+	}
+	if len(id) > MaxIdLength {
+		return util.Errorf("kv: id too long (%d > %d)", len(id), MaxIdLength)
+	}
+	if len(input) > util.MaxStructLength {
+		return util.Errorf("kv: value too long (%d > %d)", len(input), util.MaxStructLength)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.tables[inputType][id]; exists {
+		return util.Errorf("kv: id already in use: %s", id)
+	}
+	return d.write(opPut, inputType, id, []byte(input))
+}
+
+func (d *driver) Update(inputType string, id string, input string) (err error) {
+	if err = d.checkTable(inputType); err != nil {
+		return
+	}
+	if len(input) > util.MaxStructLength {
+		return util.Errorf("kv: value too long (%d > %d)", len(input), util.MaxStructLength)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.tables[inputType][id]; !exists {
+		return nil // same as an update statement without matching rows
+	}
+	return d.write(opPut, inputType, id, []byte(input))
+}
+
+func (d *driver) Remove(inputType string, id string) (err error) {
+	if err = d.checkTable(inputType); err != nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.tables[inputType][id]; !exists {
+		return nil // same as a delete statement without matching rows
+	}
+	return d.write(opDelete, inputType, id, nil)
+}
+
+func (d *driver) BlobRetrieve(id string) (output *[]byte, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	blob, exists := d.blobs[id]
+	if !exists {
+		return nil, util.Errorf("kv: blob %s: %w", id, db.ErrNotFound)
+	}
+	blobCopy := append([]byte{}, blob...)
+	return &blobCopy, nil
+}
+
+func (d *driver) BlobCreate(id string, input *[]byte) (err error) {
+	if len(id) > MaxIdLength {
+		return util.Errorf("kv: id too long (%d > %d)", len(id), MaxIdLength)
+	}
+	if len(*input) > util.MaxBlobsLength {
+		return util.Errorf("kv: blob too big (%d > %d)", len(*input), util.MaxBlobsLength)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.blobs[id]; exists {
+		return util.Errorf("kv: blob id already in use: %s", id)
+	}
+	return d.write(opBlobPut, "", id, *input)
+}
+
+func (d *driver) BlobUpdate(id string, input *[]byte) (err error) {
+	if len(*input) > util.MaxBlobsLength {
+		return util.Errorf("kv: blob too big (%d > %d)", len(*input), util.MaxBlobsLength)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.blobs[id]; !exists {
+		return util.Errorf("kv: blob update of unexistent id: %s", id)
+	}
+	return d.write(opBlobPut, "", id, *input)
+}
+
+func (d *driver) BlobRemove(id string) (err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.blobs[id]; !exists {
+		return util.Errorf("kv: blob delete of unexistent id: %s", id)
+	}
+	return d.write(opBlobDelete, "", id, nil)
+}
+
+func (d *driver) BlobExists(id string) (output bool, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	_, output = d.blobs[id]
+	return
+}
+
+func (d *driver) Tables() (output []string, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return slices.Sorted(maps.Keys(d.tables)), nil
+}
+
+func (d *driver) BlobIDs() (output []string, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return slices.Sorted(maps.Keys(d.blobs)), nil
+}
+
+func (d *driver) GetContextSymbol() string {
+	return "."
+}
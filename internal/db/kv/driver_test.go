@@ -0,0 +1,160 @@
+package kv
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/db/dbtest"
+)
+
+const kvTestDB = "/tmp/foo.kv"
+
+func TestKV(t *testing.T) {
+	os.Remove(kvTestDB)
+	dbtest.TestDriver(t, func() (db.Driver, error) {
+		return Connect(kvTestDB)
+	})
+}
+
+func TestKVRecovery(t *testing.T) {
+	os.Remove(kvTestDB)
+	db, err := Connect(kvTestDB)
+	if err != nil {
+		t.Error("failed to create a valid db:", err)
+		return
+	}
+	db.Create("recovery", "1", "1")
+	db.Create("recovery", "2", "2")
+	db.BlobCreate("1", &[]byte{0x01})
+	db.Close()
+
+	// Simulate a crash in the middle of a write
+	file, err := os.OpenFile(kvTestDB, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Error("cannot open db file:", err)
+		return
+	}
+	record := encodeRecord(opPut, "recovery", "3", []byte("3"))
+	file.Write(record[:len(record)-1])
+	file.Close()
+	info, _ := os.Stat(kvTestDB)
+	corruptedSize := info.Size()
+
+	db, err = Connect(kvTestDB)
+	if err != nil {
+		t.Error("recovery failed:", err)
+		return
+	}
+	defer db.Close()
+	if all, _ := db.RetrieveAll("recovery"); len(all) != 2 || all["2"] != "2" {
+		t.Error("recovered data is not the original:", all)
+	}
+	if blob, err := db.BlobRetrieve("1"); err != nil || !util.BlobsEqual(blob, &[]byte{0x01}) {
+		t.Error("recovered blob is not the original:", err)
+	}
+	if info, _ := os.Stat(kvTestDB); info.Size() >= corruptedSize {
+		t.Error("incomplete record was not discarded")
+	}
+	if err = db.Create("recovery", "3", "3"); err != nil {
+		t.Error("write after recovery fails:", err)
+	}
+}
+
+func TestKVCompaction(t *testing.T) {
+	os.Remove(kvTestDB)
+	db, err := Connect(kvTestDB)
+	if err != nil {
+		t.Error("failed to create a valid db:", err)
+		return
+	}
+	value := strings.Repeat("A", util.MaxStructLength)
+	db.Create("compaction", "1", value)
+	for i := 0; i < 2*compactionMinSize/util.MaxStructLength; i++ {
+		if err = db.Update("compaction", "1", value); err != nil {
+			t.Error("update fails:", err)
+		}
+	}
+	db.Close()
+	if info, _ := os.Stat(kvTestDB); info.Size() > compactionMinSize {
+		t.Error("log was not compacted:", info.Size())
+	}
+	db, err = Connect(kvTestDB)
+	if err != nil {
+		t.Error("reopening compacted db failed:", err)
+		return
+	}
+	defer db.Close()
+	if output, _ := db.Retrieve("compaction", "1"); output != value {
+		t.Error("compacted data is not the original")
+	}
+}
+
+func TestKVCorruption(t *testing.T) {
+	os.Remove(kvTestDB)
+	db, err := Connect(kvTestDB)
+	if err != nil {
+		t.Error("failed to create a valid db:", err)
+		return
+	}
+	db.Create("corruption", "1", "1")
+	db.Create("corruption", "2", "2")
+	db.Close()
+
+	// Flip a byte of the first record, which is followed by a valid one
+	file, err := os.OpenFile(kvTestDB, os.O_RDWR, 0644)
+	if err != nil {
+		t.Error("cannot open db file:", err)
+		return
+	}
+	file.WriteAt([]byte{0xff}, int64(len(fileHeader)+recordHeaderLength+1))
+	file.Close()
+	info, _ := os.Stat(kvTestDB)
+
+	if db, err = Connect(kvTestDB); err == nil {
+		db.Close()
+		t.Error("corruption before the end of the log is not detected")
+	}
+	if after, _ := os.Stat(kvTestDB); after.Size() != info.Size() {
+		t.Error("corrupted log was truncated")
+	}
+
+	// Flip a byte of the payload of the last record, which is complete
+	os.Remove(kvTestDB)
+	db, _ = Connect(kvTestDB)
+	db.Create("corruption", "1", "1")
+	db.Create("corruption", "2", "2")
+	db.Close()
+	info, _ = os.Stat(kvTestDB)
+	file, _ = os.OpenFile(kvTestDB, os.O_RDWR, 0644)
+	file.WriteAt([]byte{0xff}, info.Size()-1)
+	file.Close()
+	if db, err = Connect(kvTestDB); err == nil {
+		db.Close()
+		t.Error("complete records failing their checksum are not detected")
+	}
+	if after, _ := os.Stat(kvTestDB); after.Size() != info.Size() {
+		t.Error("corrupted last record was truncated")
+	}
+}
+
+func TestKVLock(t *testing.T) {
+	os.Remove(kvTestDB)
+	db, err := Connect(kvTestDB)
+	if err != nil {
+		t.Error("failed to create a valid db:", err)
+		return
+	}
+	if second, err := Connect(kvTestDB); err == nil {
+		second.Close()
+		t.Error("the same file can be opened twice")
+	}
+	db.Close()
+	if db, err = Connect(kvTestDB); err != nil {
+		t.Error("the file is still locked after closing it:", err)
+		return
+	}
+	db.Close()
+}
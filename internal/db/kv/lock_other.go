@@ -0,0 +1,10 @@
+//go:build !unix
+
+package kv
+
+import "os"
+
+// lockFile does nothing in systems without flock
+func lockFile(file *os.File) error {
+	return nil
+}
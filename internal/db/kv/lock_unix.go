@@ -0,0 +1,14 @@
+//go:build unix
+
+package kv
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on the file, failing if another process holds it.
+// The lock is released when the file is closed
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,213 @@
+package memory
+
+import (
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// MaxIdLength sets the maximum string length for table ids
+const MaxIdLength = db.MaxIdLength
+
+// store keeps the contents of one in-memory database
+type store struct {
+	mutex  sync.Mutex
+	tables map[string]map[string]string
+	blobs  map[string][]byte
+}
+
+type driver struct {
+	*store
+}
+
+var (
+	namedStores      = map[string]*store{}
+	namedStoresMutex sync.Mutex
+)
+
+// Connect returns a driver which keeps everything in memory. Drivers connected
+// with the same non-empty name share their contents during the whole execution,
+// even after being closed. An empty name always gives an empty database
+func Connect(name string) (output *driver, err error) {
+	if name == "" {
+		return &driver{newStore()}, nil
+	}
+	namedStoresMutex.Lock()
+	defer namedStoresMutex.Unlock()
+	s := namedStores[name]
+	if s == nil {
+		s = newStore()
+		namedStores[name] = s
+	}
+	return &driver{s}, nil
+}
+
+// Drop deletes the contents of a named database
+func Drop(name string) {
+	namedStoresMutex.Lock()
+	defer namedStoresMutex.Unlock()
+	delete(namedStores, name)
+}
+
+func newStore() *store {
+	return &store{
+		tables: make(map[string]map[string]string),
+		blobs:  make(map[string][]byte),
+	}
+}
+
+func (d *driver) Close() {
+}
+
+// checkTable returns an error if the table name is not valid
+func (d *driver) checkTable(inputType string) error {
+	if !db.IsValidTableName(inputType, d.GetContextSymbol()) {
+		return util.Errorf("memory: invalid table name: %s", inputType)
+	}
+	return nil
+}
+
+func (d *driver) Retrieve(inputType string, id string) (output string, err error) {
+	if err = d.checkTable(inputType); err != nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.tables[inputType][id], nil
+}
+
+func (d *driver) RetrieveAll(inputType string) (output map[string]string, err error) {
+	if err = d.checkTable(inputType); err != nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	output = make(map[string]string, len(d.tables[inputType]))
+	for id, value := range d.tables[inputType] {
+		output[id] = value
+	}
+	return
+}
+
+func (d *driver) Create(inputType string, id string, input string) (err error) {
+	if err = d.checkTable(inputType); err != nil {
+		return
+	}
+	if len(id) > MaxIdLength {
+		return util.Errorf("memory: id too long (%d > %d)", len(id), MaxIdLength)
+	}
+	if len(input) > util.MaxStructLength {
+		return util.Errorf("memory: value too long (%d > %d)", len(input), util.MaxStructLength)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.tables[inputType][id]; exists {
+		return util.Errorf("memory: id already in use: %s", id)
+	}
+	if d.tables[inputType] == nil {
+		d.tables[inputType] = make(map[string]string)
+	}
+	d.tables[inputType][id] = input
+	return nil
+}
+
+func (d *driver) Update(inputType string, id string, input string) (err error) {
+	if err = d.checkTable(inputType); err != nil {
+		return
+	}
+	if len(input) > util.MaxStructLength {
+		return util.Errorf("memory: value too long (%d > %d)", len(input), util.MaxStructLength)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.tables[inputType][id]; exists {
+		d.tables[inputType][id] = input
+	}
+	return nil // same as an update statement without matching rows
+}
+
+func (d *driver) Remove(inputType string, id string) (err error) {
+	if err = d.checkTable(inputType); err != nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.tables[inputType], id)
+	return nil
+}
+
+func (d *driver) BlobRetrieve(id string) (output *[]byte, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	blob, exists := d.blobs[id]
+	if !exists {
+		return nil, util.Errorf("memory: blob %s: %w", id, db.ErrNotFound)
+	}
+	blobCopy := append([]byte{}, blob...)
+	return &blobCopy, nil
+}
+
+func (d *driver) BlobCreate(id string, input *[]byte) (err error) {
+	if len(id) > MaxIdLength {
+		return util.Errorf("memory: id too long (%d > %d)", len(id), MaxIdLength)
+	}
+	if len(*input) > util.MaxBlobsLength {
+		return util.Errorf("memory: blob too big (%d > %d)", len(*input), util.MaxBlobsLength)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.blobs[id]; exists {
+		return util.Errorf("memory: blob id already in use: %s", id)
+	}
+	d.blobs[id] = append([]byte{}, *input...)
+	return nil
+}
+
+func (d *driver) BlobUpdate(id string, input *[]byte) (err error) {
+	if len(*input) > util.MaxBlobsLength {
+		return util.Errorf("memory: blob too big (%d > %d)", len(*input), util.MaxBlobsLength)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.blobs[id]; !exists {
+		return util.Errorf("memory: blob update of unexistent id: %s", id)
+	}
+	d.blobs[id] = append([]byte{}, *input...)
+	return nil
+}
+
+func (d *driver) BlobRemove(id string) (err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.blobs[id]; !exists {
+		return util.Errorf("memory: blob delete of unexistent id: %s", id)
+	}
+	delete(d.blobs, id)
+	return nil
+}
+
+func (d *driver) BlobExists(id string) (output bool, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	_, output = d.blobs[id]
+	return
+}
+
+func (d *driver) Tables() (output []string, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return slices.Sorted(maps.Keys(d.tables)), nil
+}
+
+func (d *driver) BlobIDs() (output []string, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return slices.Sorted(maps.Keys(d.blobs)), nil
+}
+
+func (d *driver) GetContextSymbol() string {
+	return "."
+}
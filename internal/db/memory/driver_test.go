@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/db/dbtest"
+)
+
+func TestMemory(t *testing.T) {
+	Drop("TestMemory")
+	defer Drop("TestMemory")
+	dbtest.TestDriver(t, func() (db.Driver, error) {
+		return Connect("TestMemory")
+	})
+}
+
+func TestMemoryNamed(t *testing.T) {
+	db, err := Connect("TestMemoryNamed")
+	if err != nil {
+		t.Error("failed to create a valid db:", err)
+		return
+	}
+	db.Create("named", "1", "1")
+	db.Close()
+
+	db, err = Connect("TestMemoryNamed")
+	if err != nil {
+		t.Error("failed to reconnect:", err)
+		return
+	}
+	if output, _ := db.Retrieve("named", "1"); output != "1" {
+		t.Error("named databases should keep their contents")
+	}
+	Drop("TestMemoryNamed")
+	db, _ = Connect("TestMemoryNamed")
+	if output, _ := db.Retrieve("named", "1"); output != "" {
+		t.Error("dropped databases should be empty")
+	}
+	if db, _ := Connect(""); db.store == nil || len(db.tables) != 0 {
+		t.Error("unnamed databases should be empty")
+	}
+}
@@ -1,27 +1,27 @@
 package sql
 
 import (
+	"context"
 	"database/sql"
+	sqldriver "database/sql/driver"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql" //Add support for mysql db
 	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/metrics"
 	_ "github.com/ncruces/go-sqlite3/driver" //Add support for sqlite3 db
 	_ "github.com/ncruces/go-sqlite3/embed"  //Do not rely on external sqlite3 libraries
 )
 
 // MaxIdLength sets the maximum string length for table ids
-const MaxIdLength = 512
-
-// maxRegexLength decides the maximum length for any string checked with alphanumericRegexp
-const maxRegexLength = "40"
-
-// Regular expression used to check that no SQL injection is produced
-var /* const */ alphanumericRegexp *regexp.Regexp = regexp.MustCompile("^[A-Za-z0-9_]{1," + maxRegexLength + "}$")
+const MaxIdLength = db.MaxIdLength
 
 // Name for the table to store byte blobs
 const BlobsTableName = "blobs"
@@ -34,16 +34,30 @@ const (
 	stmtExists
 	stmtRetrieve
 	stmtRetrieveAll
+	stmtRetrieveAllAfter // continues an interrupted stmtRetrieveAll
 	stmtInsert
 	stmtDelete
 	stmtUpdate
 	stmtCreateBlobs
+	stmtCheckIndex
+	stmtCreateIndex
+	stmtRetrieveIDs
+	stmtRetrieveIDsWhere
+	stmtRetrieveIDsAfter
+	stmtAttribute         // expression to compare attributes with bools
+	stmtSignedAttribute   // expression to compare attributes with signed integers, exactly
+	stmtUnsignedAttribute // expression to compare attributes with unsigned integers, exactly
+	stmtStringAttribute   // expression to compare attributes with strings, byte by byte
+	stmtListTables
+	stmtListIDs
 )
 
 const (
 	msgErrorNoSuchTable = iota
 	msgErrorNoRowsInResultSet
 	msgErrorConnectionRefused
+	msgErrorRetryable   // the statement was not executed and can be repeated
+	msgErrorInterrupted // the statement may have been executed
 )
 
 // This struct stores data needed to work with a struct in this DB
@@ -55,30 +69,57 @@ type typeHandler struct {
 type driver struct {
 	db            *sql.DB
 	checkedTypes  map[string]*typeHandler //checkedTypes stores types that have been already handled during the execution
+	checkedMutex  sync.Mutex
 	blobStmts     map[int]*sql.Stmt
 	baseStmts     map[int]string
 	driverMsgs    map[int]*regexp.Regexp
 	contextSymbol string
+	options       Options
+	indexValue    func(any) any // indexValue adapts the values compared with indexed attributes
+	filterValue   func(any) any // filterValue adapts the values compared with stmtAttribute
+	metrics       metrics.Metrics
+	logger        *slog.Logger
 }
 
 // Connect should be the first method called to initialize the db connection
-func connect(driverID string, dataSourceName string, baseStmts map[int]string, driverMsgs map[int]*regexp.Regexp, contextSymbol string) (output *driver, err error) {
+func connect(driverID string, dataSourceName string, baseStmts map[int]string, driverMsgs map[int]*regexp.Regexp, contextSymbol string, indexValue func(any) any, filterValue func(any) any) (output *driver, err error) {
 	output = new(driver)
+	output.logger = slog.New(slog.DiscardHandler)
+	output.indexValue = indexValue
+	output.filterValue = filterValue
+	dataSourceName, output.options, err = parseOptions(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
 	output.db, err = sql.Open(driverID, dataSourceName)
 	if err != nil {
 		return nil, err
 	}
+	output.options.apply(output.db)
 	output.baseStmts = baseStmts
 	output.driverMsgs = driverMsgs
 	output.checkedTypes = make(map[string]*typeHandler)
 	output.contextSymbol = contextSymbol
-	err = output.ensureBlobsTableIsHandled()
+	err = output.do("Connect", false, func(context.Context) error {
+		return output.ensureBlobsTableIsHandled()
+	})
 	if err != nil {
+		output.db.Close()
 		return nil, err
 	}
 	return
 }
 
+// SetMetrics makes the driver report the duration and retries of its statements
+func (d *driver) SetMetrics(m metrics.Metrics) {
+	d.metrics = m
+}
+
+// SetLogger makes the driver log the creation of tables and indexes
+func (d *driver) SetLogger(logger *slog.Logger) {
+	d.logger = logger
+}
+
 func (d *driver) Close() {
 	d.db.Close()
 }
@@ -91,7 +132,7 @@ func (d *driver) ensureBlobsTableIsHandled() error {
 	if err == nil || d.driverMsgs[msgErrorNoRowsInResultSet].MatchString(err.Error()) {
 		// Table exists and can be empty
 	} else if d.driverMsgs[msgErrorConnectionRefused].MatchString(err.Error()) {
-		return util.Errorf("cannot connect to database: %v", err)
+		return util.Errorf("cannot connect to database: %w", err)
 	} else if d.driverMsgs[msgErrorNoSuchTable].MatchString(err.Error()) {
 		// Table does not exist. Let's create it
 		_, err := d.db.Exec(fmt.Sprintf(d.baseStmts[stmtCreateBlobs], BlobsTableName, MaxIdLength))
@@ -99,13 +140,13 @@ func (d *driver) ensureBlobsTableIsHandled() error {
 			return util.Errorf("cannot create blobs table: %v", err)
 		}
 	} else {
-		return util.Errorf("unhandled error: %v", err)
+		return util.Errorf("unhandled error: %w", err)
 	}
 	d.blobStmts = make(map[int]*sql.Stmt)
 	for i := stmtExists; i <= stmtUpdate; i++ {
 		d.blobStmts[i], err = d.db.Prepare(fmt.Sprintf(d.baseStmts[i], BlobsTableName))
 		if err != nil {
-			return util.Errorf("cannot initialize blobs statements: %v", err)
+			return util.Errorf("cannot initialize blobs statements: %w", err)
 		}
 	}
 	return nil
@@ -123,7 +164,6 @@ func (d *driver) createTypeHandler(input string) (th *typeHandler, err error) {
 				stmt.Close()
 			}
 			th.stmts = nil
-			log.Println(err)
 			return nil, err
 		}
 	}
@@ -132,6 +172,8 @@ func (d *driver) createTypeHandler(input string) (th *typeHandler, err error) {
 
 // ensureTableIsHandled checks if the table is already handled by the driver and handles it if not, checking for SQLi at source code
 func (d *driver) ensureTableIsHandled(input string) (th *typeHandler, err error) {
+	d.checkedMutex.Lock()
+	defer d.checkedMutex.Unlock()
 	th = d.checkedTypes[input]
 	if th != nil {
 		return //input is already handled
@@ -139,7 +181,7 @@ func (d *driver) ensureTableIsHandled(input string) (th *typeHandler, err error)
 
 	//Start the handling tasks
 	var testID string
-	if !alphanumericRegexp.MatchString(strings.ReplaceAll(input, d.contextSymbol, "")) {
+	if !db.IsValidTableName(input, d.contextSymbol) {
 		return nil, util.Errorf("possible SQL injection: %s", input)
 	}
 	err = d.db.QueryRow(fmt.Sprintf(d.baseStmts[stmtCheckTable], input)).Scan(&testID)
@@ -152,130 +194,389 @@ func (d *driver) ensureTableIsHandled(input string) (th *typeHandler, err error)
 		if err != nil {
 			return nil, util.Errorf("cannot create table for %s: %v", input, err)
 		}
+		d.logger.Info("table created", "table", input)
 	} else {
-		return nil, util.Errorf("unhandled error with query \"%s\": %v", fmt.Sprintf(d.baseStmts[stmtCheckTable], input), err)
+		return nil, util.Errorf("unhandled error with query \"%s\": %w", fmt.Sprintf(d.baseStmts[stmtCheckTable], input), err)
 	}
 	th, err = d.createTypeHandler(input)
 	if err != nil {
-		return nil, util.Errorf("cannot create type handler for %s: %v", input, err)
+		return nil, util.Errorf("cannot create type handler for %s: %w", input, err)
 	}
 	d.checkedTypes[input] = th
 	return
 }
 
-func (d *driver) Retrieve(inputType string, id string) (output string, err error) {
-	handledType, err := d.ensureTableIsHandled(inputType)
-	if err != nil {
-		return
-	}
-	err = handledType.stmts[stmtRetrieve].QueryRow(id).Scan(&output)
-	if errors.Is(err, sql.ErrNoRows) {
-		err = nil
+// context returns the context for one statement, with the configured timeout
+func (d *driver) context() (context.Context, context.CancelFunc) {
+	if d.options.QueryTimeout > 0 {
+		return context.WithTimeout(context.Background(), d.options.QueryTimeout)
 	}
+	return context.WithCancel(context.Background())
+}
+
+// do performs the operation following the retry policy. Operations which modify
+// data are only repeated when the previous attempt was certainly not performed
+func (d *driver) do(name string, modifies bool, operation func(ctx context.Context) error) (err error) {
+	attempts := 0
+	d.options.Retry.Do(func() error {
+		ctx, cancel := d.context()
+		defer cancel()
+		if attempts++; attempts > 1 && d.metrics != nil {
+			d.metrics.Add(metrics.StatementRetries, 1, metrics.Label{Name: "operation", Value: name})
+		}
+		started := time.Now()
+		err = d.classify(operation(ctx))
+		if d.metrics != nil {
+			d.metrics.Observe(metrics.StatementSeconds, time.Since(started).Seconds(), metrics.Label{Name: "operation", Value: name})
+		}
+		if modifies && err != nil && !d.notPerformed(err) {
+			return nil // stop retrying
+		}
+		return err
+	})
+	return
+}
+
+func (d *driver) Retrieve(inputType string, id string) (output string, err error) {
+	err = d.do("Retrieve", false, func(ctx context.Context) error {
+		handledType, err := d.ensureTableIsHandled(inputType)
+		if err != nil {
+			return err
+		}
+		err = handledType.stmts[stmtRetrieve].QueryRowContext(ctx, id).Scan(&output)
+		if errors.Is(err, sql.ErrNoRows) {
+			output = ""
+			return nil
+		}
+		return err
+	})
 	return
 }
 
 func (d *driver) RetrieveAll(inputType string) (output map[string]string, err error) {
-	handledType, err := d.ensureTableIsHandled(inputType)
+	err = d.do("RetrieveAll", false, func(ctx context.Context) error {
+		handledType, err := d.ensureTableIsHandled(inputType)
+		if err != nil {
+			return err
+		}
+		rows, err := handledType.stmts[stmtRetrieveAll].QueryContext(ctx)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		output = make(map[string]string)
+		for rows.Next() {
+			var id string
+			var value string
+			err = rows.Scan(&id, &value)
+			if err != nil {
+				return err
+			}
+			output[id] = value
+		}
+		return rows.Err()
+	})
 	if err != nil {
-		return
+		output = nil
 	}
-	rows, err := handledType.stmts[stmtRetrieveAll].Query()
-	if err != nil {
-		return
-	}
-	output = make(map[string]string)
-	for rows.Next() {
-		var id string
-		var value string
-		err = rows.Scan(&id, &value)
+	return
+}
+
+// Scan reads the table in id order. A retried scan continues after the last
+// element given to fn, so no element is received twice. Errors returned by fn
+// are never retried
+func (d *driver) Scan(inputType string, fn func(id string, value string) error) (err error) {
+	delivered := false
+	last := ""
+	var fnErr error
+	err = d.do("Scan", false, func(ctx context.Context) error {
+		handledType, err := d.ensureTableIsHandled(inputType)
+		if err != nil {
+			return err
+		}
+		var rows *sql.Rows
+		if delivered {
+			rows, err = handledType.stmts[stmtRetrieveAllAfter].QueryContext(ctx, last)
+		} else {
+			rows, err = handledType.stmts[stmtRetrieveAll].QueryContext(ctx)
+		}
 		if err != nil {
-			return
+			return err
 		}
-		output[id] = value
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			var value string
+			err = rows.Scan(&id, &value)
+			if err != nil {
+				return err
+			}
+			delivered, last = true, id
+			if fnErr = fn(id, value); fnErr != nil {
+				return nil
+			}
+		}
+		return rows.Err()
+	})
+	if fnErr != nil {
+		return fnErr
 	}
 	return
 }
 
 func (d *driver) Remove(inputType string, id string) (err error) {
-	handledType, err := d.ensureTableIsHandled(inputType)
-	if err != nil {
-		return
-	}
-	_, err = handledType.stmts[stmtDelete].Exec(id)
-	return
+	return d.do("Remove", true, func(ctx context.Context) error {
+		handledType, err := d.ensureTableIsHandled(inputType)
+		if err != nil {
+			return err
+		}
+		_, err = handledType.stmts[stmtDelete].ExecContext(ctx, id)
+		return err
+	})
 }
 
 func (d *driver) Create(inputType string, id string, input string) (err error) {
-	handledType, err := d.ensureTableIsHandled(inputType)
-	if err != nil {
-		return
-	}
 	if len(id) > MaxIdLength {
 		return util.Errorf("sql: id too long (%d > %d)", len(id), MaxIdLength)
 	}
-	_, err = handledType.stmts[stmtInsert].Exec(id, input)
-	return
+	if len(input) > db.MaxValueLength {
+		return util.Errorf("sql: value too long (%d > %d)", len(input), db.MaxValueLength)
+	}
+	return d.do("Create", true, func(ctx context.Context) error {
+		handledType, err := d.ensureTableIsHandled(inputType)
+		if err != nil {
+			return err
+		}
+		_, err = handledType.stmts[stmtInsert].ExecContext(ctx, id, input)
+		return err
+	})
 }
 
 func (d *driver) Update(inputType string, id string, input string) (err error) {
-	handledType, err := d.ensureTableIsHandled(inputType)
-	if err != nil {
-		return
+	if len(input) > db.MaxValueLength {
+		return util.Errorf("sql: value too long (%d > %d)", len(input), db.MaxValueLength)
 	}
-	_, err = handledType.stmts[stmtUpdate].Exec(input, id)
-	return
+	return d.do("Update", true, func(ctx context.Context) error {
+		handledType, err := d.ensureTableIsHandled(inputType)
+		if err != nil {
+			return err
+		}
+		_, err = handledType.stmts[stmtUpdate].ExecContext(ctx, input, id)
+		return err
+	})
 }
 
 func (d *driver) BlobRetrieve(id string) (output *[]byte, err error) {
-	err = d.blobStmts[stmtRetrieve].QueryRow(id).Scan(&output)
+	err = d.do("BlobRetrieve", false, func(ctx context.Context) error {
+		return d.blobStmts[stmtRetrieve].QueryRowContext(ctx, id).Scan(&output)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, util.Errorf("sql: blob %s: %w", id, db.ErrNotFound)
+	}
 	return
 }
 func (d *driver) BlobCreate(id string, input *[]byte) (err error) {
 	if len(id) > MaxIdLength {
 		return util.Errorf("sql: id too long (%d > %d)", len(id), MaxIdLength)
 	}
-	_, err = d.blobStmts[stmtInsert].Exec(id, input)
-	return
+	if len(*input) > db.MaxBlobLength {
+		return util.Errorf("sql: blob too big (%d > %d)", len(*input), db.MaxBlobLength)
+	}
+	return d.do("BlobCreate", true, func(ctx context.Context) error {
+		_, err := d.blobStmts[stmtInsert].ExecContext(ctx, id, input)
+		return err
+	})
 }
 func (d *driver) BlobUpdate(id string, input *[]byte) (err error) {
-	result, err := d.blobStmts[stmtUpdate].Exec(input, id)
-	if err != nil {
-		return
+	if len(*input) > db.MaxBlobLength {
+		return util.Errorf("sql: blob too big (%d > %d)", len(*input), db.MaxBlobLength)
 	}
-	affectedRows, err := result.RowsAffected()
-	if err != nil {
-		return
+	return d.do("BlobUpdate", true, func(ctx context.Context) error {
+		result, err := d.blobStmts[stmtUpdate].ExecContext(ctx, input, id)
+		if err != nil {
+			return err
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affectedRows != 1 {
+			return util.Errorf("sql: blob update modified %d rows", affectedRows)
+		}
+		return nil
+	})
+}
+func (d *driver) BlobRemove(id string) (err error) {
+	return d.do("BlobRemove", true, func(ctx context.Context) error {
+		result, err := d.blobStmts[stmtDelete].ExecContext(ctx, id)
+		if err != nil {
+			return err
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affectedRows != 1 {
+			return util.Errorf("sql: blob delete modified %d rows", affectedRows)
+		}
+		return nil
+	})
+}
+func (d *driver) BlobExists(id string) (output bool, err error) {
+	err = d.do("BlobExists", false, func(ctx context.Context) error {
+		var outputID string
+		err := d.blobStmts[stmtExists].QueryRowContext(ctx, id).Scan(&outputID)
+		output = err == nil
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	})
+	return
+}
+
+// notPerformed checks if err certainly means that the statement was not executed
+func (d *driver) notPerformed(err error) bool {
+	return errors.Is(err, sqldriver.ErrBadConn) || d.driverMsgs[msgErrorConnectionRefused].MatchString(err.Error()) ||
+		d.driverMsgs[msgErrorRetryable].MatchString(err.Error())
+}
+
+// classify wraps the errors which may disappear if the operation is repeated with db.ErrTransient
+func (d *driver) classify(err error) error {
+	if err == nil {
+		return nil
 	}
-	if affectedRows != 1 {
-		return util.Errorf("sql: blob update modified %d rows", affectedRows)
+	if d.notPerformed(err) || errors.Is(err, context.DeadlineExceeded) ||
+		d.driverMsgs[msgErrorInterrupted].MatchString(err.Error()) {
+		return util.Errorf("sql: %w: %w", db.ErrTransient, err)
 	}
-	return
+	return err
 }
-func (d *driver) BlobRemove(id string) (err error) {
-	result, err := d.blobStmts[stmtDelete].Exec(id)
+
+// queryIDs runs a query whose only column is the id
+func (d *driver) queryIDs(ctx context.Context, query string, params ...any) (output []string, err error) {
+	rows, err := d.db.QueryContext(ctx, query, params...)
 	if err != nil {
-		return
+		return nil, err
 	}
-	affectedRows, err := result.RowsAffected()
-	if err != nil {
-		return
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		output = append(output, id)
 	}
-	if affectedRows != 1 {
-		return util.Errorf("sql: blob delete modified %d rows", affectedRows)
+	return output, rows.Err()
+}
+
+func (d *driver) CreateIndex(inputType string, attribute string) (err error) {
+	if !db.IsValidAttributeName(attribute) {
+		return util.Errorf("possible SQL injection: %s", attribute)
+	}
+	return d.do("CreateIndex", false, func(ctx context.Context) error {
+		_, err := d.ensureTableIsHandled(inputType)
+		if err != nil {
+			return err
+		}
+		var count int
+		err = d.db.QueryRowContext(ctx, fmt.Sprintf(d.baseStmts[stmtCheckIndex], inputType, attribute)).Scan(&count)
+		if err != nil || count > 0 {
+			return err
+		}
+		_, err = d.db.ExecContext(ctx, fmt.Sprintf(d.baseStmts[stmtCreateIndex], inputType, attribute, db.MaxIndexedLength))
+		if err != nil {
+			return util.Errorf("cannot create index for %s.%s: %w", inputType, attribute, err)
+		}
+		d.logger.Info("index created", "table", inputType, "attribute", attribute)
+		return nil
+	})
+}
+
+func (d *driver) RetrieveIDsBy(inputType string, attribute string, value any) (output []string, err error) {
+	if !db.IsValidAttributeName(attribute) {
+		return nil, util.Errorf("possible SQL injection: %s", attribute)
 	}
+	err = d.do("RetrieveIDsBy", false, func(ctx context.Context) error {
+		_, err := d.ensureTableIsHandled(inputType)
+		if err != nil {
+			return err
+		}
+		output, err = d.queryIDs(ctx, fmt.Sprintf(d.baseStmts[stmtRetrieveIDs], inputType, attribute), d.indexValue(value))
+		return err
+	})
 	return
 }
-func (d *driver) BlobExists(id string) (output bool, err error) {
-	var outputID string
-	err = d.blobStmts[stmtExists].QueryRow(id).Scan(&outputID)
-	if err == nil {
-		return true, nil
-	} else if errors.Is(err, sql.ErrNoRows) {
-		return false, nil
+
+func (d *driver) RetrieveIDsWhere(inputType string, conditions []db.Condition) (output []string, err error) {
+	var where []string
+	var params []any
+	for _, condition := range conditions {
+		if !db.IsValidAttributeName(condition.Attribute) || !db.IsValidOperator(condition.Operator) {
+			return nil, util.Errorf("possible SQL injection: %s %s", condition.Attribute, condition.Operator)
+		}
+		attribute := d.baseStmts[stmtAttribute]
+		switch condition.Value.(type) {
+		case string:
+			attribute = d.baseStmts[stmtStringAttribute]
+		case int64:
+			attribute = d.baseStmts[stmtSignedAttribute]
+		case uint64:
+			attribute = d.baseStmts[stmtUnsignedAttribute]
+		}
+		where = append(where, fmt.Sprintf(attribute, condition.Attribute)+" "+condition.Operator+" ?")
+		params = append(params, d.filterValue(condition.Value))
 	}
-	return false, err
+	if len(where) == 0 {
+		where = append(where, "1 = 1")
+	}
+	err = d.do("RetrieveIDsWhere", false, func(ctx context.Context) error {
+		_, err := d.ensureTableIsHandled(inputType)
+		if err != nil {
+			return err
+		}
+		output, err = d.queryIDs(ctx, fmt.Sprintf(d.baseStmts[stmtRetrieveIDsWhere], inputType, strings.Join(where, " and ")), params...)
+		return err
+	})
+	return
 }
+
+func (d *driver) RetrieveIDsAfter(inputType string, after string, limit int) (output []string, err error) {
+	err = d.do("RetrieveIDsAfter", false, func(ctx context.Context) error {
+		_, err := d.ensureTableIsHandled(inputType)
+		if err != nil {
+			return err
+		}
+		output, err = d.queryIDs(ctx, fmt.Sprintf(d.baseStmts[stmtRetrieveIDsAfter], inputType), after, limit)
+		return err
+	})
+	return
+}
+
+// Tables returns the names of the tables which can be handled by the driver, except the blobs one
+func (d *driver) Tables() (output []string, err error) {
+	err = d.do("Tables", false, func(ctx context.Context) error {
+		output = nil
+		names, err := d.queryIDs(ctx, d.baseStmts[stmtListTables])
+		for _, name := range names {
+			if name != BlobsTableName && db.IsValidTableName(name, d.contextSymbol) {
+				output = append(output, name)
+			}
+		}
+		return err
+	})
+	return
+}
+
+// BlobIDs returns the ids of every blob, in ascending order
+func (d *driver) BlobIDs() (output []string, err error) {
+	err = d.do("BlobIDs", false, func(ctx context.Context) (err error) {
+		output, err = d.queryIDs(ctx, fmt.Sprintf(d.baseStmts[stmtListIDs], BlobsTableName))
+		return
+	})
+	return
+}
+
 func (d *driver) GetContextSymbol() string {
 	return d.contextSymbol
 }
@@ -1,156 +1,40 @@
 package sql
 
 import (
-	"fmt"
-	"log"
-	"strings"
 	"testing"
+	"time"
 
-	"github.com/gonimals/elephant/internal/util"
+	dbpkg "github.com/gonimals/elephant/pkg/db"
 )
 
-func testDriver(t *testing.T, db *driver) {
-	testBasicUsage(t, db)
-	testLimits(t, db)
-}
-
-func testBasicUsage(t *testing.T, db *driver) {
-	_, err := db.db.Exec(fmt.Sprintf(db.baseStmts[stmtDropTable], "test_table"))
-	if err != nil {
-		t.Error("simple drop table fails:", err)
-		return
-	}
-	_, err = db.db.Exec(fmt.Sprintf(db.baseStmts[stmtDropTable], BlobsTableName))
-	if err != nil {
-		t.Error("simple drop of blobs table fails:", err)
-		return
-	}
-	err = db.ensureBlobsTableIsHandled()
-	if err != nil {
-		t.Error("blobs table creation fails:", err)
-		return
-	}
-	err = db.Create("test_table", "1", "{ \"fdsa\": [] }")
-	if err != nil {
-		t.Error("simple create operation fails:", err)
-	}
-	output, err := db.Retrieve("test_table", "1")
-	if err != nil {
-		t.Error("simple retrieve operation fails:", err)
-	}
-	if output != "{ \"fdsa\": [] }" {
-		t.Error("retrieved string is not the original")
-	}
-	err = db.Update("test_table", "1", "{ \"asdf\": 2 }")
-	if err != nil {
-		t.Error("simple update operation fails:", err)
-	}
-	err = db.Update("test_table", "1", "[]")
-	if err != nil {
-		t.Error("simple update operation fails:", err)
-	}
-	output, err = db.Retrieve("test_table", "1")
-	if err != nil {
-		t.Error("simple retrieve operation fails:", err)
-	}
-	if output != "[]" {
-		t.Errorf("retrieved string is not the updated one")
-	}
-	err = db.Remove("test_table", "1")
-	if err != nil {
-		t.Error("simple delete operation fails:", err)
-	}
-	output, err = db.Retrieve("test_table", "1")
-	if err != nil {
-		t.Error("retrieve operation of deleted item gives an error")
-	} else if output != "" {
-		t.Error("retrieve operation of deleted item gives output:", output)
+func TestParseOptions(t *testing.T) {
+	remaining, options, err := parseOptions("/tmp/foo.db")
+	if err != nil || remaining != "/tmp/foo.db" || options.Retry != dbpkg.DefaultRetryPolicy {
+		t.Error("data source name without options is altered:", remaining, options, err)
 	}
-	err = db.BlobCreate("1", &[]byte{0x00})
-	if err != nil {
-		t.Error("blob create operation fails:", err)
-	}
-	blob, err := db.BlobRetrieve("1")
-	if err != nil {
-		t.Error("blob retrieve operation fails:", err)
-	}
-	if !util.BlobsEqual(blob, &[]byte{0x00}) {
-		t.Error("retrieved blob is not the original")
-	}
-	err = db.BlobUpdate("1", &[]byte{0x01})
-	if err != nil {
-		t.Error("blob update operation fails:", err)
+	remaining, _, err = parseOptions("user:pass@tcp(localhost:3306)/db?parseTime=true")
+	if err != nil || remaining != "user:pass@tcp(localhost:3306)/db?parseTime=true" {
+		t.Error("unknown parameters are altered:", remaining, err)
 	}
-	exists, err := db.BlobExists("1")
+	remaining, options, err = parseOptions("/tmp/foo.db?max_open_conns=1&query_timeout=5s&_txlock=immediate&retry_attempts=10&retry_backoff=100ms")
 	if err != nil {
-		t.Error("blob exists operation fails with error:", err)
+		t.Fatal("valid options rejected:", err)
 	}
-	if !exists {
-		t.Error("blob exists shows existing value as non-existing")
+	if remaining != "/tmp/foo.db?_txlock=immediate" {
+		t.Error("options not removed from the data source name:", remaining)
 	}
-	exists, err = db.BlobExists("asdf")
-	if err != nil {
-		t.Error("blob exists operation fails:", err)
-	}
-	if exists {
-		t.Error("blob exists shows non-existing value as existing")
-	}
-	blob, err = db.BlobRetrieve("1")
-	if err != nil {
-		t.Error("blob retrieve operation fails:", err)
-	}
-	if !util.BlobsEqual(blob, &[]byte{0x01}) {
-		t.Error("retrieved blob is not the updated one")
+	if options.MaxOpenConns != 1 || options.QueryTimeout != 5*time.Second ||
+		options.Retry.Attempts != 10 || options.Retry.Backoff != 100*time.Millisecond ||
+		options.Retry.MaxBackoff != dbpkg.DefaultRetryPolicy.MaxBackoff {
+		t.Error("options not parsed:", options)
 	}
-	err = db.BlobRemove("1")
-	if err != nil {
-		t.Error("blob delete operation fails:", err)
-	}
-	err = db.BlobRemove("1")
-	if err == nil {
-		t.Error("blob delete operation should fail")
-	}
-	blob, err = db.BlobRetrieve("1")
-	if err == nil {
-		t.Error("retrieve operation of deleted blob doesn't give error")
-	} else if util.BlobsEqual(blob, &[]byte{0x00}) {
-		t.Error("retrieve operation of deleted blob gives output:", blob)
-	}
-}
-
-func testLimits(t *testing.T, db *driver) {
-	longString := strings.Repeat("A", MaxIdLength+1)
-	err := db.Create("limits", longString, "asdf")
-	if err == nil {
-		t.Error("too long id should fail")
-	}
-	err = db.BlobCreate(longString, &[]byte{0x00})
-	if err == nil {
-		t.Error("too long id should fail")
-	}
-	longString = strings.Repeat("A", 1024*1024*1024) //1GB
-	log.Println("A very big query will be executed. A database driver error is acceptable right now")
-	err = db.Create("limits", "longvalue", longString)
-	if err == nil {
-		t.Error("too long value should fail")
-	}
-	log.Println("The big query has finished. No more errors until the next big query are acceptable")
-
-	err = db.BlobRemove("longvalue")
-	if err == nil {
-		t.Error("long value delete operation should fail")
-	}
-	longString = ""
-
-	longBlob := []byte(strings.Repeat("A", 1024*1024*1024)) //1GB
-	log.Println("A very big query will be executed. A database driver error is acceptable right now")
-	err = db.BlobCreate("longvalue", &longBlob)
-	if err == nil {
-		t.Error("too long blob should fail")
+	remaining, _, err = parseOptions("/tmp/foo.db?max_idle_conns=2")
+	if err != nil || remaining != "/tmp/foo.db" {
+		t.Error("data source name with only options is wrong:", remaining, err)
 	}
-	log.Println("The big query has finished. No more errors until the next big query are acceptable")
-	err = db.BlobRemove("longvalue")
-	if err == nil {
-		t.Error("blob delete operation should fail")
+	for _, invalid := range []string{"/tmp/foo.db?max_open_conns=many", "/tmp/foo.db?query_timeout=5", "/tmp/foo.db?retry_attempts=-1"} {
+		if _, _, err = parseOptions(invalid); err == nil {
+			t.Error("invalid options accepted:", invalid)
+		}
 	}
 }
@@ -1,29 +1,60 @@
 package sql
 
 import (
+	"fmt"
 	"regexp"
 )
 
 var /*const*/ stmtsMysql = map[int]string{
-	stmtDropTable:   "drop table if exists `%s`",
-	stmtCheckTable:  "select id from `%s` limit 1",
-	stmtCreateTable: "create table `%s` ( id varchar(%d) primary key, value json )",
-	stmtExists:      "select id from `%s` where id = ?",
-	stmtRetrieve:    "select value from `%s` where id = ?",
-	stmtRetrieveAll: "select id, value from `%s`",
-	stmtInsert:      "insert into `%s` (id, value) values (?, ?)",
-	stmtDelete:      "delete from `%s` where id = ?",
-	stmtUpdate:      "update `%s` set value = ? where id = ?",
-	stmtCreateBlobs: "create table `%s` ( id varchar(%d) primary key, value longblob )",
+	stmtDropTable:        "drop table if exists `%s`",
+	stmtCheckTable:       "select id from `%s` limit 1",
+	stmtCreateTable:      "create table `%s` ( id varchar(%d) collate utf8mb4_bin primary key, value json )",
+	stmtExists:           "select id from `%s` where id = ?",
+	stmtRetrieve:         "select value from `%s` where id = ?",
+	stmtRetrieveAll:      "select id, value from `%s` order by id",
+	stmtRetrieveAllAfter: "select id, value from `%s` where id > ? order by id",
+	stmtInsert:           "insert into `%s` (id, value) values (?, ?)",
+	stmtDelete:           "delete from `%s` where id = ?",
+	stmtUpdate:           "update `%s` set value = ? where id = ?",
+	stmtCreateBlobs:      "create table `%s` ( id varchar(%d) collate utf8mb4_bin primary key, value longblob )",
+	stmtCheckIndex:       "select count(*) from information_schema.statistics where table_schema = database() and table_name = '%[1]s' and index_name = 'idx_%[2]s'",
+	stmtCreateIndex:      "alter table `%[1]s` add column `idx_%[2]s` varchar(%[3]d) as (json_unquote(json_extract(value, '$.%[2]s'))) virtual, add index `idx_%[2]s` (`idx_%[2]s`)",
+	stmtRetrieveIDs:      "select id from `%[1]s` where `idx_%[2]s` = ?",
+
+	stmtRetrieveIDsWhere:  "select id from `%s` where %s",
+	stmtRetrieveIDsAfter:  "select id from `%s` where id > ? order by id limit ?",
+	stmtAttribute:         "json_unquote(json_extract(value, '$.%s'))",
+	stmtSignedAttribute:   "cast(json_unquote(json_extract(value, '$.%s')) as signed)",
+	stmtUnsignedAttribute: "cast(json_unquote(json_extract(value, '$.%s')) as unsigned)",
+	stmtStringAttribute:   "cast(json_unquote(json_extract(value, '$.%s')) as binary)",
+	stmtListTables:        "select table_name from information_schema.tables where table_schema = database() order by table_name",
+	stmtListIDs:           "select id from `%s` order by id",
 }
 
 var /*const*/ msgsMysql = map[int]*regexp.Regexp{
 	msgErrorNoSuchTable:       regexp.MustCompile(`Table '[^']+' doesn't exist`),
 	msgErrorNoRowsInResultSet: regexp.MustCompile(`sql: no rows in result set`),
 	msgErrorConnectionRefused: regexp.MustCompile(`connection refused`),
+	msgErrorRetryable:         regexp.MustCompile(`Lock wait timeout|Deadlock found|Too many connections|server shutdown in progress`),
+	msgErrorInterrupted:       regexp.MustCompile(`i/o timeout|invalid connection|broken pipe|connection reset|unexpected EOF`),
+}
+
+// indexValueMysql adapts values to the generated columns, which store the unquoted JSON text
+func indexValueMysql(input any) any {
+	return fmt.Sprint(input)
+}
+
+// filterValueMysql adapts values to the unquoted JSON text, which contains booleans
+// as words. Integers are compared with the text cast to integers, without going
+// through doubles, which would lose precision above 2^53
+func filterValueMysql(input any) any {
+	if _, isBool := input.(bool); isBool {
+		return fmt.Sprint(input)
+	}
+	return input
 }
 
 // Connect should be the first method called to initialize the db connection
 func ConnectMySQL(dataSourceName string) (output *driver, err error) {
-	return connect("mysql", dataSourceName, stmtsMysql, msgsMysql, "-")
+	return connect("mysql", dataSourceName, stmtsMysql, msgsMysql, "-", indexValueMysql, filterValueMysql)
 }
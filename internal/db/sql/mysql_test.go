@@ -5,19 +5,50 @@ import (
 	"fmt"
 	"log"
 	"testing"
+
+	dbpkg "github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/db/dbtest"
 )
 
 // Refer to the README.md for the commands to perform these tests with this uri
 const mysqlTestDB = "root:@tcp(127.0.0.1:33060)/elephant"
 
 func TestMysql(t *testing.T) {
-	db, err := ConnectMySQL(mysqlTestDB)
+	if err := cleanMysqlTestDB(); err != nil {
+		t.Fatal("cannot empty the testing database:", err)
+	}
+	dbtest.TestDriver(t, func() (dbpkg.Driver, error) {
+		return ConnectMySQL(mysqlTestDB)
+	})
+}
+
+// cleanMysqlTestDB drops every table of the testing database
+func cleanMysqlTestDB() error {
+	db, err := sql.Open("mysql", mysqlTestDB)
 	if err != nil {
-		t.Error("failed to create a valid db")
-		return
+		return err
 	}
 	defer db.Close()
-	testDriver(t, db)
+	rows, err := db.Query("select table_name from information_schema.tables where table_schema = database()")
+	if err != nil {
+		return err
+	}
+	var tableNames []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		tableNames = append(tableNames, name)
+	}
+	rows.Close()
+	for _, table := range tableNames {
+		if _, err = db.Exec(fmt.Sprintf(stmtsMysql[stmtDropTable], table)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // This function is just an mysql usage example
@@ -0,0 +1,93 @@
+package sql
+
+import (
+	"database/sql"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// Options configure the connection pool, timeouts and retries of the driver.
+// They are read from the query parameters of the data source name and
+// removed from it before passing it to the underlying database driver
+type Options struct {
+	MaxOpenConns    int           // max_open_conns
+	MaxIdleConns    int           // max_idle_conns
+	ConnMaxLifetime time.Duration // conn_max_lifetime
+	ConnMaxIdleTime time.Duration // conn_max_idle_time
+	QueryTimeout    time.Duration // query_timeout, applied to every statement
+	Retry           db.RetryPolicy
+}
+
+// parseOptions extracts the options from dataSourceName, returning the remaining data source name.
+// Example: /tmp/foo.db?max_open_conns=1&query_timeout=5s&retry_attempts=10&retry_backoff=100ms
+func parseOptions(dataSourceName string) (remaining string, options Options, err error) {
+	options.Retry = db.DefaultRetryPolicy
+	remaining, query, found := strings.Cut(dataSourceName, "?")
+	if !found {
+		return
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", options, util.Errorf("sql: invalid query parameters: %v", err)
+	}
+	integers := map[string]*int{
+		"max_open_conns": &options.MaxOpenConns,
+		"max_idle_conns": &options.MaxIdleConns,
+		"retry_attempts": &options.Retry.Attempts,
+	}
+	durations := map[string]*time.Duration{
+		"conn_max_lifetime":  &options.ConnMaxLifetime,
+		"conn_max_idle_time": &options.ConnMaxIdleTime,
+		"query_timeout":      &options.QueryTimeout,
+		"retry_backoff":      &options.Retry.Backoff,
+		"retry_max_backoff":  &options.Retry.MaxBackoff,
+	}
+	removed := false
+	for key, target := range integers {
+		if values.Has(key) {
+			removed = true
+			*target, err = strconv.Atoi(values.Get(key))
+			if err != nil || *target < 0 {
+				return "", options, util.Errorf("sql: invalid value for %s: %s", key, values.Get(key))
+			}
+			values.Del(key)
+		}
+	}
+	for key, target := range durations {
+		if values.Has(key) {
+			removed = true
+			*target, err = time.ParseDuration(values.Get(key))
+			if err != nil || *target < 0 {
+				return "", options, util.Errorf("sql: invalid value for %s: %s", key, values.Get(key))
+			}
+			values.Del(key)
+		}
+	}
+	if !removed {
+		remaining = dataSourceName
+	} else if len(values) > 0 {
+		remaining += "?" + values.Encode()
+	}
+	return remaining, options, nil
+}
+
+// apply configures the connection pool
+func (o Options) apply(database *sql.DB) {
+	if o.MaxOpenConns > 0 {
+		database.SetMaxOpenConns(o.MaxOpenConns)
+	}
+	if o.MaxIdleConns > 0 {
+		database.SetMaxIdleConns(o.MaxIdleConns)
+	}
+	if o.ConnMaxLifetime > 0 {
+		database.SetConnMaxLifetime(o.ConnMaxLifetime)
+	}
+	if o.ConnMaxIdleTime > 0 {
+		database.SetConnMaxIdleTime(o.ConnMaxIdleTime)
+	}
+}
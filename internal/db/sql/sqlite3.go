@@ -3,25 +3,51 @@ package sql
 import "regexp"
 
 var /*const*/ stmtsSqlite3 = map[int]string{
-	stmtDropTable:   "drop table if exists '%s'",
-	stmtCheckTable:  "select id from %s limit 1",
-	stmtCreateTable: "create table '%s' ( id varchar(%d) primary key, value text )",
-	stmtExists:      "select id from '%s' where id = ?",
-	stmtRetrieve:    "select value from '%s' where id = ?",
-	stmtRetrieveAll: "select id, value from '%s'",
-	stmtInsert:      "insert into '%s' (id, value) values (?, ?)",
-	stmtDelete:      "delete from '%s' where id = ?",
-	stmtUpdate:      "update '%s' set value = ? where id = ?",
-	stmtCreateBlobs: "create table '%s' ( id varchar(%d) primary key, value longblob )",
+	stmtDropTable:        "drop table if exists '%s'",
+	stmtCheckTable:       "select id from '%s' limit 1",
+	stmtCreateTable:      "create table '%s' ( id varchar(%d) primary key, value text )",
+	stmtExists:           "select id from '%s' where id = ?",
+	stmtRetrieve:         "select value from '%s' where id = ?",
+	stmtRetrieveAll:      "select id, value from '%s' order by id",
+	stmtRetrieveAllAfter: "select id, value from '%s' where id > ? order by id",
+	stmtInsert:           "insert into '%s' (id, value) values (?, ?)",
+	stmtDelete:           "delete from '%s' where id = ?",
+	stmtUpdate:           "update '%s' set value = ? where id = ?",
+	stmtCreateBlobs:      "create table '%s' ( id varchar(%d) primary key, value longblob )",
+	stmtCheckIndex:       "select count(*) from sqlite_master where type = 'index' and name = 'idx_%[1]s_%[2]s'",
+	stmtCreateIndex:      "create index if not exists 'idx_%[1]s_%[2]s' on '%[1]s' (json_extract(value, '$.%[2]s'))",
+	stmtRetrieveIDs:      "select id from '%[1]s' where json_extract(value, '$.%[2]s') = ?",
+
+	stmtRetrieveIDsWhere:  "select id from '%s' where %s",
+	stmtRetrieveIDsAfter:  "select id from '%s' where id > ? order by id limit ?",
+	stmtAttribute:         "json_extract(value, '$.%s')",
+	stmtSignedAttribute:   "json_extract(value, '$.%s')",
+	stmtUnsignedAttribute: "json_extract(value, '$.%s')",
+	stmtStringAttribute:   "json_extract(value, '$.%s')",
+	stmtListTables:        "select name from sqlite_master where type = 'table' and name not like 'sqlite\\_%' escape '\\' order by name",
+	stmtListIDs:           "select id from '%s' order by id",
 }
 
 var /*const*/ msgsSqlite3 = map[int]*regexp.Regexp{
 	msgErrorNoSuchTable:       regexp.MustCompile(`no such table: `),
 	msgErrorNoRowsInResultSet: regexp.MustCompile(`sql: no rows in result set`),
 	msgErrorConnectionRefused: regexp.MustCompile(`connection refused`),
+	msgErrorRetryable:         regexp.MustCompile(`database is locked|database table is locked`),
+	msgErrorInterrupted:       regexp.MustCompile(`interrupted`),
+}
+
+// indexValueSqlite3 adapts values to the result of json_extract, which returns booleans as integers
+func indexValueSqlite3(input any) any {
+	if value, isBool := input.(bool); isBool {
+		if value {
+			return 1
+		}
+		return 0
+	}
+	return input
 }
 
 // Connect should be the first method called to initialize the db connection
 func ConnectSqlite3(dataSourceName string) (output *driver, err error) {
-	return connect("sqlite3", dataSourceName, stmtsSqlite3, msgsSqlite3, ".")
+	return connect("sqlite3", dataSourceName, stmtsSqlite3, msgsSqlite3, ".", indexValueSqlite3, indexValueSqlite3)
 }
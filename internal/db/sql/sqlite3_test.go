@@ -2,21 +2,101 @@ package sql
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	dbpkg "github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/db/dbtest"
 )
 
 const sqlite3TestDB = "/tmp/foo.db"
 
 func TestSqlite3(t *testing.T) {
+	os.Remove(sqlite3TestDB)
+	dbtest.TestDriver(t, func() (dbpkg.Driver, error) {
+		return ConnectSqlite3(sqlite3TestDB)
+	})
+}
+
+func TestSqlite3Options(t *testing.T) {
+	dataSourceName := sqlite3TestDB + "?max_open_conns=1&query_timeout=5s&retry_attempts=2"
+	os.Remove(sqlite3TestDB)
+	db, err := ConnectSqlite3(dataSourceName)
+	if err != nil {
+		t.Fatal("failed to connect with options:", err)
+	}
+	if db.db.Stats().MaxOpenConnections != 1 || db.options.Retry.Attempts != 2 {
+		t.Error("options not applied:", db.db.Stats().MaxOpenConnections, db.options)
+	}
+	db.Close()
+	dbtest.TestDriver(t, func() (dbpkg.Driver, error) {
+		return ConnectSqlite3(dataSourceName)
+	})
+}
+
+func TestSqlite3ScanRetry(t *testing.T) {
+	os.Remove(sqlite3TestDB)
+	db, err := ConnectSqlite3(sqlite3TestDB + "?query_timeout=50ms")
+	if err != nil {
+		t.Fatal("failed to create a valid db")
+	}
+	defer db.Close()
+	for _, id := range []string{"c", "a", "b"} {
+		db.Create("scanned", id, "{}")
+	}
+	visited := []string{}
+	err = db.Scan("scanned", func(id string, value string) error {
+		if len(visited) == 0 {
+			time.Sleep(100 * time.Millisecond) // the statement times out and is retried
+		}
+		visited = append(visited, id)
+		return nil
+	})
+	if err != nil || !slices.Equal(visited, []string{"a", "b", "c"}) {
+		t.Error("retried scans should continue after the last element, got", visited, err)
+	}
+
+	calls := 0
+	transient := fmt.Errorf("destination unavailable: %w", dbpkg.ErrTransient)
+	err = db.Scan("scanned", func(id string, value string) error {
+		calls++
+		return transient
+	})
+	if !errors.Is(err, transient) || calls != 1 {
+		t.Error("errors of fn should be returned without retrying, got", calls, err)
+	}
+}
+
+func TestSqlite3IndexUsage(t *testing.T) {
 	db, err := ConnectSqlite3(sqlite3TestDB)
 	if err != nil {
-		t.Error("failed to create a valid db")
+		t.Fatal("failed to create a valid db")
 	}
 	defer db.Close()
-	testDriver(t, db)
+	if err = db.CreateIndex("planned", "name"); err != nil {
+		t.Fatal("index creation fails:", err)
+	}
+	rows, err := db.db.Query("explain query plan "+fmt.Sprintf(stmtsSqlite3[stmtRetrieveIDs], "planned", "name"), "alice")
+	if err != nil {
+		t.Fatal("cannot get query plan:", err)
+	}
+	defer rows.Close()
+	plan := ""
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		rows.Scan(&id, &parent, &notUsed, &detail)
+		plan += detail
+	}
+	if !strings.Contains(plan, "USING INDEX idx_planned_name") {
+		t.Error("query does not use the index:", plan)
+	}
 }
 
 // This function is just an sqlite3 usage example
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// crockfordAlphabet is the base32 alphabet used by ULIDs
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// lastULID keeps the milliseconds and random bits of the last ULID, so the
+// next ones generated in the same millisecond are greater
+var lastULID struct {
+	sync.Mutex
+	ms     uint64
+	random [10]byte
+}
+
+// NewULID returns a new ULID: 48 bits of milliseconds since epoch followed
+// by 80 random bits, encoded in 26 characters which sort by creation time.
+// ULIDs generated in the same millisecond (or after the clock goes back)
+// reuse the last time and increment the random bits, so they are monotonic
+func NewULID(now time.Time) string {
+	lastULID.Lock()
+	ms := uint64(now.UnixMilli())
+	if ms <= lastULID.ms && !incrementBytes(lastULID.random[:]) {
+		ms = lastULID.ms
+	} else {
+		if ms <= lastULID.ms {
+			ms = lastULID.ms + 1 // random bits overflowed
+		}
+		rand.Read(lastULID.random[:])
+	}
+	lastULID.ms = ms
+	var id [16]byte
+	copy(id[6:], lastULID.random[:])
+	lastULID.Unlock()
+	for i := 5; i >= 0; i-- {
+		id[i] = byte(ms)
+		ms >>= 8
+	}
+
+	// 128 bits are encoded as 26 characters of 5 bits, the first one only using 3 bits
+	output := make([]byte, 26)
+	var buffer uint64
+	bits := 2 // padding at the beginning to complete 130 bits
+	position := 0
+	for _, b := range id {
+		buffer = buffer<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			output[position] = crockfordAlphabet[(buffer>>bits)&0x1f]
+			position++
+		}
+	}
+	return string(output)
+}
+
+// incrementBytes adds one to the big endian number, returning true if it overflows
+func incrementBytes(number []byte) bool {
+	for i := len(number) - 1; i >= 0; i-- {
+		number[i]++
+		if number[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
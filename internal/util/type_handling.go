@@ -2,6 +2,10 @@ package util
 
 import (
 	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
 // MaxStructLength defines how long can be a structure converted to JSON to be stored
@@ -10,15 +14,68 @@ const MaxStructLength = 65535 //64k
 // MaxBlobsLength defines how big can be blobs stored
 const MaxBlobsLength = 65535 //64k
 
+// MaxIndexedLength defines how many characters can have the indexed strings,
+// so databases needing a column for the index can store them
+const MaxIndexedLength = 255
+
+// Regular expression used to check the names which end up in queries
+var /* const */ alphanumericRegexp *regexp.Regexp = regexp.MustCompile("^[A-Za-z0-9_]{1,40}$")
+
+// IsAlphanumeric checks that the input only contains alphanumeric characters and
+// underscores, up to 40, so it is safe to use in queries
+func IsAlphanumeric(input string) bool {
+	return alphanumericRegexp.MatchString(input)
+}
+
+// Policies applied to referencing objects when the referenced one is removed
+const (
+	RefRestrict = iota
+	RefCascade
+	RefSetEmpty
+)
+
+// Reference describes a string parameter tagged with `db:"ref=Type"`
+type Reference struct {
+	Type     string
+	OnRemove int
+}
+
+// TypeNameField is the field carrying the name of the structs built at run time,
+// which have no name, in its `elephant` tag. It is not stored nor learnt as a field
+const TypeNameField = "ElephantTypeName"
+
 // Structs
 type LearntType struct {
 	Name    string
 	Id      string //only needed if struct will be a db table
 	Fields  map[string]reflect.Type
 	Updates map[string]struct{}
+	Created string //time.Time field filled on insert, if any
+	Updated string //time.Time field filled on every write, if any
+	Refs    map[string]*Reference
+	Indexes map[string]string //fields tagged with `db:"index"`, with their JSON names
+	Stored  map[string]string //fields always present in the JSON representation, with their JSON names
+	Search  []string          //string fields tagged with `db:"search"`
 }
 
-var LearntTypes = map[reflect.Type]*LearntType{}
+// learntTypes keeps the types already examined. ExamineType is also called from the
+// goroutines of the callers, so the map is guarded by learntTypesMutex
+var (
+	learntTypes      = map[reflect.Type]*LearntType{}
+	learntTypesMutex sync.RWMutex
+)
+
+// FindLearntType returns a pointer to the examined struct with the provided name, or nil if not found
+func FindLearntType(name string) reflect.Type {
+	learntTypesMutex.RLock()
+	defer learntTypesMutex.RUnlock()
+	for structType, learntType := range learntTypes {
+		if learntType.Name == name {
+			return reflect.PointerTo(structType)
+		}
+	}
+	return nil
+}
 
 // examineType will check that the type can be transformed into JSON and has an Id parameter
 func ExamineType(input reflect.Type) (output *LearntType, err error) {
@@ -27,28 +84,95 @@ func ExamineType(input reflect.Type) (output *LearntType, err error) {
 			input.String(), input.Kind().String())
 	}
 	input = input.Elem()
-	output = LearntTypes[input]
+	learntTypesMutex.RLock()
+	output = learntTypes[input]
+	learntTypesMutex.RUnlock()
 	if output != nil {
 		return // type was already processed
 	}
 	output = new(LearntType)
 	output.Fields = make(map[string]reflect.Type)
 	output.Updates = make(map[string]struct{})
+	output.Refs = make(map[string]*Reference)
+	output.Indexes = make(map[string]string)
+	output.Stored = make(map[string]string)
 	output.Name = input.Name()
 
 	for i := 0; i < input.NumField(); i++ {
 		field := input.Field(i)
+		if field.Name == TypeNameField && output.Name == "" {
+			output.Name = field.Tag.Get("elephant")
+			continue
+		}
 		output.Fields[field.Name] = field.Type
-		if field.Tag != "" {
-			tag := field.Tag.Get("db")
-			if tag == "id" {
+		if _, options, _ := strings.Cut(field.Tag.Get("json"), ","); field.IsExported() && JSONName(field) != "-" &&
+			!strings.Contains(options, "omitempty") && !strings.Contains(options, "omitzero") {
+			output.Stored[field.Name] = JSONName(field)
+		}
+		var reference *Reference
+		for _, tag := range strings.Split(field.Tag.Get("db"), ",") {
+			key, value, _ := strings.Cut(tag, "=")
+			switch key {
+			case "ref":
+				if field.Type.Kind() != reflect.String || value == "" {
+					return nil, Errorf("%s has a parameter with the annotation `db:\"ref\"` which is not a string or has no type",
+						input.String())
+				}
+				reference = &Reference{Type: value, OnRemove: RefRestrict}
+				output.Refs[field.Name] = reference
+			case "onremove":
+				if reference == nil {
+					return nil, Errorf("%s has a parameter with the annotation `db:\"onremove\"` without a previous ref",
+						input.String())
+				}
+				switch value {
+				case "restrict":
+					reference.OnRemove = RefRestrict
+				case "cascade":
+					reference.OnRemove = RefCascade
+				case "setempty":
+					reference.OnRemove = RefSetEmpty
+				default:
+					return nil, Errorf("%s has an unknown onremove policy: %s", input.String(), value)
+				}
+			case "id":
 				output.Id = field.Name
 				if field.Type.Kind() != reflect.String {
 					return nil, Errorf("%s has a parameter with the annotation `db:\"id\"` which is not a string",
 						input.String())
 				}
-			} else if tag == "update" {
+			case "update":
 				output.Updates[field.Name] = struct{}{}
+			case "search":
+				if field.Type.Kind() != reflect.String {
+					return nil, Errorf("%s has a parameter with the annotation `db:\"search\"` which is not a string",
+						input.String())
+				}
+				output.Search = append(output.Search, field.Name)
+			case "index":
+				if IndexValue(reflect.Zero(field.Type).Interface()) == nil {
+					return nil, Errorf("%s has a parameter with the annotation `db:\"index\"` which is not a string, integer or bool",
+						input.String())
+				}
+				output.Indexes[field.Name] = JSONName(field)
+				if output.Indexes[field.Name] == "-" {
+					return nil, Errorf("%s has a parameter with the annotation `db:\"index\"` which is not stored",
+						input.String())
+				}
+				if !IsAlphanumeric(output.Indexes[field.Name]) {
+					return nil, Errorf("%s has a parameter with the annotation `db:\"index\"` whose name is not alphanumeric: %s",
+						input.String(), output.Indexes[field.Name])
+				}
+			case "created", "updated":
+				if field.Type != reflect.TypeFor[time.Time]() {
+					return nil, Errorf("%s has a parameter with the annotation `db:\"%s\"` which is not a time.Time",
+						input.String(), tag)
+				}
+				if tag == "created" {
+					output.Created = field.Name
+				} else {
+					output.Updated = field.Name
+				}
 			}
 		}
 	}
@@ -56,7 +180,12 @@ func ExamineType(input reflect.Type) (output *LearntType, err error) {
 		return nil, Errorf("%s hasn't got an string parameter with the annotation `db:\"id\"`",
 			input.String())
 	}
-	LearntTypes[input] = output
+	learntTypesMutex.Lock()
+	defer learntTypesMutex.Unlock()
+	if examined := learntTypes[input]; examined != nil {
+		return examined, nil // examined meanwhile by another goroutine
+	}
+	learntTypes[input] = output
 	return
 }
 
@@ -81,6 +210,53 @@ func SetId(inputType reflect.Type, input any, id string) {
 	reflect.ValueOf(input).Elem().FieldByName(typeDescriptor.Id).SetString(id)
 }
 
+// SetTimestamps fills the created and updated fields of the input ptr object.
+// The created field keeps the value from old, if provided
+func SetTimestamps(inputType reflect.Type, input any, old any, now time.Time) {
+	typeDescriptor, err := ExamineType(inputType)
+	if err != nil {
+		panic(err)
+	}
+	if typeDescriptor.Created != "" {
+		created := reflect.ValueOf(input).Elem().FieldByName(typeDescriptor.Created)
+		if old != nil {
+			created.Set(reflect.ValueOf(old).Elem().FieldByName(typeDescriptor.Created))
+		} else {
+			created.Set(reflect.ValueOf(now))
+		}
+	}
+	if typeDescriptor.Updated != "" {
+		reflect.ValueOf(input).Elem().FieldByName(typeDescriptor.Updated).Set(reflect.ValueOf(now))
+	}
+}
+
+// JSONName returns the name of the field in the JSON representation
+func JSONName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// IndexValue converts the value of an indexed field to string, int64, uint64 or bool.
+// It returns nil if the value cannot be indexed
+func IndexValue(input any) any {
+	value := reflect.ValueOf(input)
+	switch value.Kind() {
+	case reflect.String:
+		return value.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Uint()
+	case reflect.Bool:
+		return value.Bool()
+	default:
+		return nil
+	}
+}
+
 func IsNilable[T any]() bool {
 	t := reflect.TypeFor[T]()
 	k := t.Kind()
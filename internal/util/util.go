@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"log/slog"
 	"reflect"
 )
 
@@ -26,7 +25,6 @@ func LoadObjectFromJson(objectType reflect.Type, objectString []byte) (any, erro
 func LoadFromJson[objectType any](objectString []byte) (objectType, error) {
 	var zero objectType
 	t := reflect.TypeFor[objectType]()
-	slog.Info(t.Name())
 	if t.Kind() != reflect.Pointer {
 		return zero, Errorf("should not copy the entire object without pointer")
 	}
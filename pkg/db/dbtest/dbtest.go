@@ -0,0 +1,413 @@
+// Package dbtest contains the checks that every db.Driver implementation must pass
+package dbtest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// concurrentWorkers and concurrentOperations decide the load of the concurrency checks
+const (
+	concurrentWorkers    = 8
+	concurrentOperations = 25
+)
+
+// TestDriver checks a driver implementation. open must return a driver
+// connected to the same database every time it is called, and that database
+// must be empty on the first call. Every driver returned is closed by the suite.
+// The optional interfaces are checked when the driver implements them
+func TestDriver(t *testing.T, open func() (db.Driver, error)) {
+	withDriver := func(check func(t *testing.T, driver db.Driver)) func(t *testing.T) {
+		return func(t *testing.T) {
+			driver, err := open()
+			if err != nil {
+				t.Fatal("cannot open driver:", err)
+			}
+			defer driver.Close()
+			check(t, driver)
+		}
+	}
+	t.Run("BasicUsage", withDriver(testBasicUsage))
+	t.Run("Limits", withDriver(testLimits))
+	t.Run("Errors", withDriver(testErrors))
+	t.Run("Concurrency", withDriver(testConcurrency))
+	t.Run("Lister", withDriver(testLister))
+	t.Run("Scanner", withDriver(testScanner))
+	t.Run("Pager", withDriver(testPager))
+	t.Run("Indexer", withDriver(testIndexer))
+	t.Run("Querier", withDriver(testQuerier))
+	t.Run("Persistence", func(t *testing.T) {
+		withDriver(func(t *testing.T, driver db.Driver) {
+			if err := driver.Create("persistence", "1", "{}"); err != nil {
+				t.Error("create operation fails:", err)
+			}
+			if err := driver.BlobCreate("persistence", &[]byte{0x01}); err != nil {
+				t.Error("blob create operation fails:", err)
+			}
+		})(t)
+		withDriver(func(t *testing.T, driver db.Driver) {
+			if output, err := driver.Retrieve("persistence", "1"); err != nil || output != "{}" {
+				t.Error("value not persisted after reopening:", output, err)
+			}
+			if blob, err := driver.BlobRetrieve("persistence"); err != nil || !util.BlobsEqual(blob, &[]byte{0x01}) {
+				t.Error("blob not persisted after reopening:", err)
+			}
+		})(t)
+	})
+}
+
+// testBasicUsage checks the common and blob methods
+func testBasicUsage(t *testing.T, driver db.Driver) {
+	err := driver.Create("test_table", "1", "{ \"fdsa\": [] }")
+	if err != nil {
+		t.Error("simple create operation fails:", err)
+	}
+	err = driver.Create("test_table", "1", "{}")
+	if err == nil {
+		t.Error("repeated create operation should fail")
+	}
+	output, err := driver.Retrieve("test_table", "1")
+	if err != nil {
+		t.Error("simple retrieve operation fails:", err)
+	}
+	if output != "{ \"fdsa\": [] }" {
+		t.Error("retrieved string is not the original")
+	}
+	err = driver.Update("test_table", "1", "[]")
+	if err != nil {
+		t.Error("simple update operation fails:", err)
+	}
+	all, err := driver.RetrieveAll("test_table")
+	if err != nil {
+		t.Error("retrieve all operation fails:", err)
+	}
+	if len(all) != 1 || all["1"] != "[]" {
+		t.Error("retrieved map is not the updated one:", all)
+	}
+	err = driver.Remove("test_table", "1")
+	if err != nil {
+		t.Error("simple delete operation fails:", err)
+	}
+	output, err = driver.Retrieve("test_table", "1")
+	if err != nil {
+		t.Error("retrieve operation of deleted item gives an error")
+	} else if output != "" {
+		t.Error("retrieve operation of deleted item gives output:", output)
+	}
+	if _, err = driver.Retrieve("test'table", "1"); err == nil {
+		t.Error("invalid table names should fail")
+	}
+	err = driver.BlobCreate("1", &[]byte{0x00})
+	if err != nil {
+		t.Error("blob create operation fails:", err)
+	}
+	blob, err := driver.BlobRetrieve("1")
+	if err != nil {
+		t.Error("blob retrieve operation fails:", err)
+	}
+	if !util.BlobsEqual(blob, &[]byte{0x00}) {
+		t.Error("retrieved blob is not the original")
+	}
+	err = driver.BlobUpdate("1", &[]byte{0x01})
+	if err != nil {
+		t.Error("blob update operation fails:", err)
+	}
+	if blob, err = driver.BlobRetrieve("1"); err != nil || !util.BlobsEqual(blob, &[]byte{0x01}) {
+		t.Error("retrieved blob is not the updated one:", err)
+	}
+	if exists, err := driver.BlobExists("1"); err != nil || !exists {
+		t.Error("blob exists shows existing value as non-existing:", err)
+	}
+	if exists, err := driver.BlobExists("asdf"); err != nil || exists {
+		t.Error("blob exists shows non-existing value as existing:", err)
+	}
+	err = driver.BlobUpdate("asdf", &[]byte{0x01})
+	if err == nil {
+		t.Error("blob update of non-existing value should fail")
+	}
+	err = driver.BlobRemove("1")
+	if err != nil {
+		t.Error("blob delete operation fails:", err)
+	}
+	err = driver.BlobRemove("1")
+	if err == nil {
+		t.Error("blob delete operation should fail")
+	}
+	if _, err = driver.BlobRetrieve("1"); !errors.Is(err, db.ErrNotFound) {
+		t.Error("retrieve operation of deleted blob doesn't give a not found error:", err)
+	}
+}
+
+// testLimits checks that ids and values longer than allowed are rejected
+func testLimits(t *testing.T, driver db.Driver) {
+	longString := strings.Repeat("A", db.MaxIdLength+1)
+	if err := driver.Create("limits", longString, "asdf"); err == nil {
+		t.Error("too long id should fail")
+	}
+	if err := driver.BlobCreate(longString, &[]byte{0x00}); err == nil {
+		t.Error("too long id should fail")
+	}
+	longString = strings.Repeat("A", util.MaxStructLength+1)
+	if err := driver.Create("limits", "longvalue", longString); err == nil {
+		t.Error("too long value should fail")
+	}
+	longBlob := []byte(strings.Repeat("A", util.MaxBlobsLength+1))
+	if err := driver.BlobCreate("longvalue", &longBlob); err == nil {
+		t.Error("too long blob should fail")
+	}
+	if err := driver.BlobRemove("longvalue"); err == nil {
+		t.Error("too long blob should not be stored")
+	}
+}
+
+// testErrors checks which operations must fail and which must not
+func testErrors(t *testing.T, driver db.Driver) {
+	invalidTable := "errors'; drop table errors; --"
+	if _, err := driver.Retrieve(invalidTable, "1"); err == nil {
+		t.Error("retrieve with invalid table name should fail")
+	}
+	if _, err := driver.RetrieveAll(invalidTable); err == nil {
+		t.Error("retrieve all with invalid table name should fail")
+	}
+	if err := driver.Create(invalidTable, "1", "{}"); err == nil {
+		t.Error("create with invalid table name should fail")
+	}
+	if err := driver.Update(invalidTable, "1", "{}"); err == nil {
+		t.Error("update with invalid table name should fail")
+	}
+	if err := driver.Remove(invalidTable, "1"); err == nil {
+		t.Error("remove with invalid table name should fail")
+	}
+	if output, err := driver.Retrieve("errors", "unexistent"); err != nil || output != "" {
+		t.Error("retrieve of unexistent element should give empty output and no error:", output, err)
+	}
+	if output, err := driver.RetrieveAll("errors"); err != nil || len(output) != 0 {
+		t.Error("retrieve all of empty table should give empty output and no error:", output, err)
+	}
+	if err := driver.Remove("errors", "unexistent"); err != nil {
+		t.Error("remove of unexistent element should not fail:", err)
+	}
+	if err := driver.Create("errors", "1", "{}"); err != nil {
+		t.Error("create operation fails:", err)
+	}
+	if err := driver.Create("errors", "1", "{}"); err == nil {
+		t.Error("repeated create operation should fail")
+	}
+	if err := driver.BlobCreate("errors", &[]byte{0x00}); err != nil {
+		t.Error("blob create operation fails:", err)
+	}
+	if err := driver.BlobCreate("errors", &[]byte{0x00}); err == nil {
+		t.Error("repeated blob create operation should fail")
+	}
+	if _, err := driver.BlobRetrieve("errors-unexistent"); !errors.Is(err, db.ErrNotFound) {
+		t.Error("blob retrieve of unexistent blob should fail with a not found error:", err)
+	}
+	if err := driver.BlobUpdate("errors-unexistent", &[]byte{0x00}); err == nil {
+		t.Error("blob update of unexistent blob should fail")
+	}
+	if err := driver.BlobRemove("errors-unexistent"); err == nil {
+		t.Error("blob remove of unexistent blob should fail")
+	}
+}
+
+// testConcurrency checks that the driver can be used from several goroutines
+func testConcurrency(t *testing.T, driver db.Driver) {
+	var waitgroup sync.WaitGroup
+	errs := make(chan error, concurrentWorkers*concurrentOperations)
+	for worker := 0; worker < concurrentWorkers; worker++ {
+		waitgroup.Add(1)
+		go func() {
+			defer waitgroup.Done()
+			for i := 0; i < concurrentOperations; i++ {
+				id := fmt.Sprintf("%d-%d", worker, i)
+				if err := driver.Create("concurrency", id, fmt.Sprintf("%d", i)); err != nil {
+					errs <- err
+					continue
+				}
+				if err := driver.BlobCreate("concurrency-"+id, &[]byte{byte(i)}); err != nil {
+					errs <- err
+					continue
+				}
+				if output, err := driver.Retrieve("concurrency", id); err != nil || output != fmt.Sprintf("%d", i) {
+					errs <- fmt.Errorf("unexpected output %s: %v", output, err)
+				}
+			}
+		}()
+	}
+	waitgroup.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error("concurrent operation fails:", err)
+	}
+	if output, err := driver.RetrieveAll("concurrency"); err != nil || len(output) != concurrentWorkers*concurrentOperations {
+		t.Error("concurrent creations are not all stored:", len(output), err)
+	}
+}
+
+// testLister checks the listings of drivers implementing db.Lister
+func testLister(t *testing.T, driver db.Driver) {
+	lister, isLister := driver.(db.Lister)
+	if !isLister {
+		t.Skip("driver does not implement db.Lister")
+	}
+	driver.Create("listedB", "1", "{}")
+	driver.Create("listedA", "1", "{}")
+	driver.BlobCreate("listed2", &[]byte{0x01})
+	driver.BlobCreate("listed1", &[]byte{0x01})
+	tables, err := lister.Tables()
+	if err != nil || !slices.IsSorted(tables) || !slices.Contains(tables, "listedA") || !slices.Contains(tables, "listedB") {
+		t.Error("tables are not listed in order:", tables, err)
+	}
+	ids, err := lister.BlobIDs()
+	if err != nil || !slices.IsSorted(ids) || !slices.Contains(ids, "listed1") || !slices.Contains(ids, "listed2") {
+		t.Error("blobs are not listed in order:", ids, err)
+	}
+}
+
+// testScanner checks that drivers implementing db.Scanner visit every element once
+func testScanner(t *testing.T, driver db.Driver) {
+	scanner, isScanner := driver.(db.Scanner)
+	if !isScanner {
+		t.Skip("driver does not implement db.Scanner")
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		driver.Create("scanned", id, "{}")
+	}
+	scanned := map[string]int{}
+	err := scanner.Scan("scanned", func(id string, value string) error {
+		scanned[id]++
+		if value != "{}" {
+			t.Error("scanned value is not the stored one:", value)
+		}
+		return nil
+	})
+	if err != nil || len(scanned) != 3 || scanned["1"] != 1 || scanned["2"] != 1 || scanned["3"] != 1 {
+		t.Error("scan does not visit every element once:", scanned, err)
+	}
+	visited := 0
+	err = scanner.Scan("scanned", func(id string, value string) error {
+		visited++
+		return errors.New("stop")
+	})
+	if err == nil || visited != 1 {
+		t.Error("scan does not stop on errors:", visited, err)
+	}
+}
+
+// testPager checks the ids listed by drivers implementing db.Pager
+func testPager(t *testing.T, driver db.Driver) {
+	pager, isPager := driver.(db.Pager)
+	if !isPager {
+		t.Skip("driver does not implement db.Pager")
+	}
+	for _, id := range []string{"c", "a", "d", "b", "B"} {
+		if err := driver.Create("paged", id, "{}"); err != nil {
+			t.Error("ids differing only in case should be different:", err)
+		}
+	}
+	if ids, err := pager.RetrieveIDsAfter("paged", "", 3); err != nil || strings.Join(ids, "") != "Bab" {
+		t.Error("first page is wrong, ids should be compared byte by byte:", ids, err)
+	}
+	if ids, err := pager.RetrieveIDsAfter("paged", "b", 3); err != nil || strings.Join(ids, "") != "cd" {
+		t.Error("middle page is wrong:", ids, err)
+	}
+	if ids, err := pager.RetrieveIDsAfter("paged", "c", 3); err != nil || strings.Join(ids, "") != "d" {
+		t.Error("last page is wrong:", ids, err)
+	}
+}
+
+// testIndexer checks the indexed retrievals of drivers implementing db.Indexer
+func testIndexer(t *testing.T, driver db.Driver) {
+	indexer, isIndexer := driver.(db.Indexer)
+	if !isIndexer {
+		t.Skip("driver does not implement db.Indexer")
+	}
+	for _, attribute := range []string{"name", "age", "active"} {
+		if err := indexer.CreateIndex("indexed", attribute); err != nil {
+			t.Error("index creation fails:", err)
+		}
+		if err := indexer.CreateIndex("indexed", attribute); err != nil {
+			t.Error("repeated index creation fails:", err)
+		}
+	}
+	if err := indexer.CreateIndex("indexed", "name') or 1=1 --"); err == nil {
+		t.Error("invalid attribute names should fail")
+	}
+	driver.Create("indexed", "1", `{"name":"alice","age":30,"active":true}`)
+	driver.Create("indexed", "2", `{"name":"bob","age":30,"active":false}`)
+	for _, check := range []struct {
+		attribute string
+		value     any
+		count     int
+	}{
+		{"name", "alice", 1}, {"name", "carol", 0}, {"age", int64(30), 2},
+		{"age", uint64(31), 0}, {"active", true, 1}, {"active", false, 1},
+	} {
+		ids, err := indexer.RetrieveIDsBy("indexed", check.attribute, check.value)
+		if err != nil {
+			t.Error("retrieve by index fails:", err)
+		} else if len(ids) != check.count {
+			t.Errorf("retrieve by %s = %v gives %v", check.attribute, check.value, ids)
+		}
+	}
+	driver.Update("indexed", "1", `{"name":"carol","age":31,"active":true}`)
+	if ids, _ := indexer.RetrieveIDsBy("indexed", "name", "carol"); len(ids) != 1 || ids[0] != "1" {
+		t.Error("updated values are not found:", ids)
+	}
+}
+
+// testQuerier checks that drivers implementing db.Querier return every matching element
+func testQuerier(t *testing.T, driver db.Driver) {
+	querier, isQuerier := driver.(db.Querier)
+	if !isQuerier {
+		t.Skip("driver does not implement db.Querier")
+	}
+	driver.Create("filtered", "1", `{"name":"alice","age":30,"active":true}`)
+	driver.Create("filtered", "2", `{"name":"Bob","age":40,"active":false}`)
+	driver.Create("filtered", "3", `{"name":"carol","age":50,"active":true}`)
+	driver.Create("filtered", "4", `{"name":"dave","age":9007199254740993,"active":false}`) // 2^53 + 1
+	for _, check := range []struct {
+		conditions []db.Condition
+		ids        []string
+	}{
+		{nil, []string{"1", "2", "3"}},
+		{[]db.Condition{where("name", db.Equal, "alice")}, []string{"1"}},
+		{[]db.Condition{where("name", db.Greater, "B")}, []string{"1", "2", "3"}},
+		{[]db.Condition{where("name", db.Greater, "a")}, []string{"1", "3"}},
+		{[]db.Condition{where("age", db.GreaterOrEqual, int64(40))}, []string{"2", "3"}},
+		{[]db.Condition{where("age", db.Less, uint64(40)), where("active", db.Equal, true)}, []string{"1"}},
+		{[]db.Condition{where("active", db.NotEqual, true)}, []string{"2"}},
+		{[]db.Condition{where("age", db.LessOrEqual, int64(50)), where("age", db.Greater, int64(30))}, []string{"2", "3"}},
+		{[]db.Condition{where("age", db.Greater, int64(1<<53))}, []string{"4"}},
+		{[]db.Condition{where("age", db.Equal, int64(1<<53+1))}, []string{"4"}},
+		{[]db.Condition{where("age", db.Greater, uint64(1<<53))}, []string{"4"}},
+	} {
+		ids, err := querier.RetrieveIDsWhere("filtered", check.conditions)
+		if err != nil {
+			t.Error("filter fails:", err)
+			continue
+		}
+		for _, id := range check.ids {
+			if !slices.Contains(ids, id) {
+				t.Errorf("filter %v gives %v, without %s", check.conditions, ids, id)
+			}
+		}
+	}
+	if _, err := querier.RetrieveIDsWhere("filtered", []db.Condition{where("name", "= 1 or ", "x")}); err == nil {
+		t.Error("invalid operators should fail")
+	}
+	if _, err := querier.RetrieveIDsWhere("filtered", []db.Condition{where("name')", db.Equal, "x")}); err == nil {
+		t.Error("invalid attribute names should fail")
+	}
+}
+
+func where(attribute string, operator string, value any) db.Condition {
+	return db.Condition{Attribute: attribute, Operator: operator, Value: value}
+}
@@ -0,0 +1,206 @@
+// Package db defines the interface that elephant backends implement.
+// Backends are made available to elephant.Initialize with elephant.RegisterDriver
+package db
+
+import (
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gonimals/elephant/internal/util"
+)
+
+// MaxIdLength sets the maximum string length for table ids
+const MaxIdLength = 512
+
+// MaxValueLength is the maximum length of the values stored by elephant
+const MaxValueLength = util.MaxStructLength
+
+// MaxIndexedLength is the maximum number of characters of the indexed strings
+const MaxIndexedLength = util.MaxIndexedLength
+
+// MaxBlobLength is the maximum length of the blobs stored by elephant
+const MaxBlobLength = util.MaxBlobsLength
+
+// Driver exposes the methods to interact with a database.
+// Common methods are meant to perform operations in tables with
+// only two columns: id and data, and both are strings.
+// Blob methods are meant for tables in which the data column is
+// a byte array.
+// Errors are returned when the underlying driver has a problem or
+// when the parameters provided are not suitable for the described
+// tables. Errors which may disappear if the operation is repeated
+// should wrap ErrTransient.
+type Driver interface {
+	// Close should be called as a deferred method after driver creation
+	Close()
+	// Retrieve returns an empty string if the element does not exist
+	Retrieve(inputType string, id string) (output string, err error)
+	// RetrieveAll returns an empty string if there are no elements
+	RetrieveAll(inputType string) (output map[string]string, err error)
+	Create(inputType string, id string, input string) (err error)
+	Update(inputType string, id string, input string) (err error)
+	Remove(inputType string, id string) (err error)
+	// GetContextSymbol returns the character used to separate the table name
+	// from the context string
+	GetContextSymbol() string
+
+	// BlobRetrieve returns an error wrapping ErrNotFound if the blob does not exist
+	BlobRetrieve(id string) (output *[]byte, err error)
+	BlobCreate(id string, input *[]byte) (err error)
+	BlobUpdate(id string, input *[]byte) (err error)
+	BlobRemove(id string) (err error)
+	BlobExists(id string) (output bool, err error)
+}
+
+// Scanner is implemented by drivers able to read a whole table without holding it in memory
+type Scanner interface {
+	// Scan calls fn for every element of the table, stopping at the first error returned by fn.
+	// fn receives every element once, even if the driver retries the scan after a transient error
+	Scan(inputType string, fn func(id string, value string) error) (err error)
+}
+
+// Scan calls fn for every element of the table, reading it row by row if the driver
+// implements Scanner and in id order from RetrieveAll otherwise
+func Scan(driver Driver, inputType string, fn func(id string, value string) error) error {
+	if scanner, isScanner := driver.(Scanner); isScanner {
+		return scanner.Scan(inputType, fn)
+	}
+	retrieved, err := driver.RetrieveAll(inputType)
+	if err != nil {
+		return err
+	}
+	for _, id := range slices.Sorted(maps.Keys(retrieved)) {
+		if err = fn(id, retrieved[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pager is implemented by drivers able to list the ids of a table in order
+type Pager interface {
+	// RetrieveIDsAfter returns up to limit ids greater than after, in ascending order.
+	// Ids are compared byte by byte, like Go strings
+	RetrieveIDsAfter(inputType string, after string, limit int) (output []string, err error)
+}
+
+// Lister is implemented by drivers able to enumerate everything they store,
+// which is needed to back up the whole database
+type Lister interface {
+	// Tables returns the names of the tables, in ascending order
+	Tables() (output []string, err error)
+	// BlobIDs returns the ids of the blobs, in ascending order
+	BlobIDs() (output []string, err error)
+}
+
+// Indexer is implemented by drivers able to index attributes of the stored
+// JSON values, so elements can be found without reading every value
+type Indexer interface {
+	// CreateIndex indexes the attribute of the table. Existing indexes are kept
+	CreateIndex(inputType string, attribute string) (err error)
+	// RetrieveIDsBy returns the ids of the elements whose attribute equals value,
+	// which is a string, int64, uint64 or bool
+	RetrieveIDsBy(inputType string, attribute string, value any) (output []string, err error)
+}
+
+// Operators allowed in conditions
+const (
+	Equal          = "="
+	NotEqual       = "!="
+	Greater        = ">"
+	GreaterOrEqual = ">="
+	Less           = "<"
+	LessOrEqual    = "<="
+)
+
+// Condition compares an attribute of the stored JSON values with a value,
+// which is a string, int64, uint64 or bool. Bools only allow Equal and NotEqual
+type Condition struct {
+	Attribute string
+	Operator  string
+	Value     any
+}
+
+// Querier is implemented by drivers able to filter the stored JSON values
+type Querier interface {
+	// RetrieveIDsWhere returns the ids of the elements matching every condition.
+	// It may return more ids than matching elements, but never less
+	RetrieveIDsWhere(inputType string, conditions []Condition) (output []string, err error)
+}
+
+// IsValidOperator checks that the operator is one of the allowed in conditions
+func IsValidOperator(input string) bool {
+	switch input {
+	case Equal, NotEqual, Greater, GreaterOrEqual, Less, LessOrEqual:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsValidAttributeName checks that the attribute name only contains
+// alphanumeric characters, so it is safe to use in queries
+func IsValidAttributeName(input string) bool {
+	return util.IsAlphanumeric(input)
+}
+
+// Logging is implemented by drivers which log their own events
+type Logging interface {
+	SetLogger(logger *slog.Logger)
+}
+
+// Factory creates a driver from the part of the uri after the scheme
+type Factory func(dsn string) (Driver, error)
+
+// IsValidTableName checks that the table name, ignoring the context symbol,
+// only contains alphanumeric characters, so it is safe to use in queries
+func IsValidTableName(input string, contextSymbol string) bool {
+	return util.IsAlphanumeric(strings.ReplaceAll(input, contextSymbol, ""))
+}
+
+// ErrNotFound is wrapped by the errors returned when the requested blob does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrTransient is wrapped by the errors which may disappear if the operation is repeated,
+// like timeouts or connections refused
+var ErrTransient = errors.New("transient error")
+
+// IsTransient checks if the operation that returned err can be retried
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+// RetryPolicy repeats operations failing with transient errors, waiting an
+// exponentially increasing time between attempts
+type RetryPolicy struct {
+	// Attempts is the maximum number of times the operation is performed
+	Attempts int
+	// Backoff is the time waited after the first failure. It doubles after every failure
+	Backoff time.Duration
+	// MaxBackoff limits the time waited between attempts
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used by the drivers when no other policy is configured
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Backoff: 50 * time.Millisecond, MaxBackoff: time.Second}
+
+// Do performs the operation until it succeeds, fails with a non transient
+// error or the attempts are exhausted. The last error is returned
+func (p RetryPolicy) Do(operation func() error) (err error) {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err = operation()
+		if err == nil || !IsTransient(err) || attempt >= p.Attempts {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{Attempts: 4, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	calls := 0
+	err := policy.Do(func() error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("timeout: %w", ErrTransient)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Error("transient errors should be retried until success:", calls, err)
+	}
+
+	calls = 0
+	err = policy.Do(func() error {
+		calls++
+		return fmt.Errorf("timeout: %w", ErrTransient)
+	})
+	if !IsTransient(err) || calls != policy.Attempts {
+		t.Error("attempts should be limited:", calls, err)
+	}
+
+	calls = 0
+	expected := errors.New("permanent")
+	err = policy.Do(func() error {
+		calls++
+		return expected
+	})
+	if err != expected || calls != 1 {
+		t.Error("non transient errors should not be retried:", calls, err)
+	}
+}
+
+func TestIsValidTableName(t *testing.T) {
+	if !IsValidTableName("valid_name.context", ".") {
+		t.Error("valid table name rejected")
+	}
+	if IsValidTableName("invalid'name", ".") || IsValidTableName("", ".") {
+		t.Error("invalid table name accepted")
+	}
+}
@@ -1,11 +1,18 @@
 package elephant
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"reflect"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gonimals/elephant/internal/util"
-	"github.com/google/uuid"
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/tracing"
 )
 
 type internalAction struct {
@@ -13,8 +20,20 @@ type internalAction struct {
 	inputType reflect.Type
 	object    []any
 	output    chan actionOutput
+	queued    time.Time
+	ctx       context.Context
+	span      tracing.Span // span of the whole call
+	queueSpan tracing.Span
+	state     atomic.Int32 // actionQueued, actionStarted or actionCanceled
 }
 
+// internalAction states, which decide whether a canceled action can be abandoned
+const (
+	actionQueued = iota
+	actionStarted
+	actionCanceled
+)
+
 type actionOutput struct {
 	data any
 	err  error
@@ -27,6 +46,7 @@ const (
 	actionRetrieveAll
 	actionUpdate
 	actionUpsert
+	actionModify
 	actionRemove
 	actionRemoveById
 	actionCreate
@@ -39,6 +59,18 @@ const (
 	actionBlobUpdate
 	actionBlobUpsert
 	actionBlobExists
+	actionSetIDGenerator
+	actionRegister
+	actionFilter
+	actionPage
+	actionPageBy
+	actionCount
+	actionSum
+	actionMinMax
+	actionGroupBy
+	actionSearch
+	actionBackup
+	actionRestore
 )
 
 var /*const*/ blobReflectType = reflect.TypeOf(&[]byte{})
@@ -50,56 +82,95 @@ func mainRoutine() {
 			//Received nil action. Shutting down mainRoutine
 			break
 		}
-		err := execManageType(action.inputType)
-		if err != nil {
-			action.output <- actionOutput{nil, err}
-			continue
-		}
-		output := actionOutput{}
-		switch action.code {
-		case actionRetrieve:
-			output.data, output.err = execRetrieve(action.inputType, action.object[0].(string))
-		case actionRetrieveAll:
-			output.data = execRetrieveAll(action.inputType)
-		case actionRetrieveBy:
-			output.data, output.err = execRetrieveBy(action.inputType, action.object[0].(string), action.object[1])
-		case actionRemove:
-			output.err = execRemove(action.inputType, action.object[0])
-		case actionRemoveById:
-			output.err = execRemoveById(action.inputType, action.object[0].(string))
-		case actionCreate:
-			output.data, output.err = execUpsert(action.inputType, action.object[0], false, true)
-		case actionUpdate:
-			output.data, output.err = execUpsert(action.inputType, action.object[0], true, false)
-		case actionUpsert:
-			output.data, output.err = execUpsert(action.inputType, action.object[0], true, true)
-		case actionExists:
-			output.data = execExists(action.inputType, action.object[0].(string))
-		case actionExistsBy:
-			output.data, output.err = execExistsBy(action.inputType, action.object[0].(string), action.object[1])
-		case actionNextID:
-			output.data, output.err = execNewID(action.inputType)
-		case actionBlobRetrieve:
-			output.data, output.err = execBlobRetrieve(action.object[0].(string))
-		case actionBlobCreate:
-			output.err = execBlobUpsert(action.object[0].(string), action.object[1].(*[]byte), false, true)
-		case actionBlobRemove:
-			output.err = execBlobRemove(action.object[0].(string))
-		case actionBlobUpdate:
-			output.err = execBlobUpsert(action.object[0].(string), action.object[1].(*[]byte), true, false)
-		case actionBlobUpsert:
-			output.err = execBlobUpsert(action.object[0].(string), action.object[1].(*[]byte), true, true)
-		case actionBlobExists:
-			output.data, output.err = dbDriver.BlobExists(action.object[0].(string))
-		default:
-			output.err = util.Errorf("unknown action")
+		started := observeQueue(action)
+		if !startQueued(action) {
+			continue // abandoned by send
 		}
+		span := startExecution(action)
+		output := execAction(action)
+		observeAction(action, started, output.err)
+		endExecution(action, span, output)
 		action.output <- output
 	}
 	waitgroup.Done()
 }
 
-func execManageType(inputType reflect.Type) error {
+// execAction loads the type of the action, if needed, and performs it
+func execAction(action *internalAction) (output actionOutput) {
+	if output.err = execManageType(action.inputType); output.err != nil {
+		return
+	}
+	switch action.code {
+	case actionRetrieve:
+		output.data, output.err = execRetrieve(action.inputType, action.object[0].(string))
+	case actionRetrieveAll:
+		output.data = execRetrieveAll(action.inputType)
+	case actionRetrieveBy:
+		output.data, output.err = execRetrieveBy(action.inputType, action.object[0].(string), action.object[1])
+	case actionRemove:
+		output.err = execRemove(action.inputType, action.object[0])
+	case actionRemoveById:
+		output.err = execRemoveById(action.inputType, action.object[0].(string))
+	case actionCreate:
+		output.data, output.err = execUpsert(action.inputType, action.object[0], false, true)
+	case actionUpdate:
+		output.data, output.err = execUpsert(action.inputType, action.object[0], true, false)
+	case actionUpsert:
+		output.data, output.err = execUpsertCreated(action.inputType, action.object[0])
+	case actionModify:
+		output.data, output.err = execModify(action.inputType, action.object[0].(string), action.object[1].(func(any) error))
+	case actionExists:
+		output.data = execExists(action.inputType, action.object[0].(string))
+	case actionExistsBy:
+		output.data, output.err = execExistsBy(action.inputType, action.object[0].(string), action.object[1])
+	case actionNextID:
+		output.data, output.err = execNewID(action.inputType, nil)
+	case actionBlobRetrieve:
+		output.data, output.err = execBlobRetrieve(action.object[0].(string))
+	case actionBlobCreate:
+		_, output.err = execBlobUpsert(action.object[0].(string), action.object[1].(*[]byte), false, true)
+	case actionBlobRemove:
+		output.err = execBlobRemove(action.object[0].(string))
+	case actionBlobUpdate:
+		_, output.err = execBlobUpsert(action.object[0].(string), action.object[1].(*[]byte), true, false)
+	case actionBlobUpsert:
+		output.data, output.err = execBlobUpsert(action.object[0].(string), action.object[1].(*[]byte), true, true)
+	case actionBlobExists:
+		output.err = measure("BlobExists", "", func() (err error) {
+			output.data, err = dbDriver.BlobExists(action.object[0].(string))
+			return
+		})
+	case actionSetIDGenerator:
+		execSetIDGenerator(action.inputType, action.object[0].(*idGenerator))
+	case actionFilter:
+		output.data, output.err = execFilter(action.inputType, action.object[0].([]Condition))
+	case actionPage:
+		output.data, output.err = execPage(action.inputType, action.object[0].(string), action.object[1].(int))
+	case actionPageBy:
+		output.data, output.err = execPageBy(action.inputType, action.object[0].(string), action.object[1].(string), action.object[2].(int))
+	case actionCount:
+		output.data, output.err = execCount(action.inputType, action.object[0].([]Condition))
+	case actionSum:
+		output.data, output.err = execSum(action.inputType, action.object[0].(string), action.object[1].(reflect.Type))
+	case actionMinMax:
+		output.data, output.err = execMinMax(action.inputType, action.object[0].(string), action.object[1].(int))
+	case actionGroupBy:
+		output.data, output.err = execGroupBy(action.inputType, action.object[0].(string), action.object[1].(reflect.Type))
+	case actionSearch:
+		output.data, output.err = execSearch(action.inputType, action.object[0].(string), action.object[1].(int))
+	case actionBackup:
+		output.err = execBackup(action.object[0].(io.Writer))
+	case actionRestore:
+		output.err = execRestore(action.object[0].([]backupRecord), action.object[1].(RestoreMode))
+	case actionRegister:
+		// execManageType already loaded the type
+	default:
+		output.err = util.Errorf("unknown action")
+	}
+	return
+}
+
+func execManageType(inputType reflect.Type) (err error) {
 	if managedTypes[inputType] {
 		return nil
 	}
@@ -110,27 +181,65 @@ func execManageType(inputType reflect.Type) error {
 	}
 	managedTypes[inputType] = true
 	learntTypes[inputType] = learntType
+	started := time.Now()
+	defer func() {
+		if err != nil {
+			// Forget the partial data, so the type is loaded again by the next call
+			delete(managedTypes, inputType)
+			delete(searchIndexes, inputType)
+			delete(data, inputType)
+		}
+	}()
 
-	retrieved, err := dbDriver.RetrieveAll(getTableName(inputType))
-	if err != nil {
-		return util.Errorf("error reading data from database: %v", err)
-	}
 	data[inputType] = make(map[string]any)
 	var loadErrors []error
-	for id, value := range retrieved {
+	load := func(id string, value string) error {
 		valueObject, err := util.LoadObjectFromJson(inputType, []byte(value))
+		if err == nil {
+			err = runAfterLoadHook(valueObject)
+		}
 		if err != nil {
+			logger.Error("cannot load object", "type", learntType.Name, "id", id, "error", err)
 			loadErrors = append(loadErrors, err)
-			continue
+			return nil
 		}
 		data[inputType][id] = valueObject
+		execIndexObject(inputType, id)
+		return nil
+	}
+	err = execScanTable(getTableName(inputType), load)
+	if err != nil {
+		logger.Error("cannot read type", "type", learntType.Name, "error", err)
+		return util.Errorf("error reading data from database: %v", err)
 	}
 	if len(loadErrors) > 0 {
 		return util.Errorf("error loading data from database: %v", loadErrors)
 	}
+	err = execCreateIndexes(inputType)
+	if err != nil {
+		logger.Error("cannot create indexes", "type", learntType.Name, "error", err)
+		return err
+	}
+	err = execStoreReferences(inputType)
+	if err != nil {
+		logger.Error("cannot record references", "type", learntType.Name, "error", err)
+		return err
+	}
+	logger.Info("type registered", "type", learntType.Name, "objects", len(data[inputType]), "duration", time.Since(started))
 	return nil
 }
 
+// execScanTable calls fn for every element stored in the table
+func execScanTable(table string, fn func(id string, value string) error) error {
+	operation := "RetrieveAll"
+	if _, isScanner := dbDriver.(db.Scanner); isScanner {
+		operation = "Scan"
+	}
+	return measure(operation, table, func() error {
+		return db.Scan(dbDriver, table, fn)
+	})
+}
+
 func execRetrieve(inputType reflect.Type, id string) (output any, err error) {
 	if object, exists := data[inputType][id]; exists {
 		output, err := util.CopyEntireObject(object)
@@ -144,11 +253,14 @@ func execRetrieve(inputType reflect.Type, id string) (output any, err error) {
 
 func execRetrieveBy(inputType reflect.Type, attribute string, object any) (output any, err error) {
 	//TODO: Yes, this is not the best way to search
-	lt := learntTypes[inputType]
-	filterType := lt.Fields[attribute]
-	if filterType == nil || reflect.TypeOf(object) != filterType {
-		//log.Println("RetrieveBy executed with invalid arguments:", filterType, reflect.TypeOf(object))
-		return nil, util.Errorf("cannot retrieve by attribute named %s with type %v: filter type is %v", attribute, reflect.TypeOf(object), filterType)
+	if err = checkRetrieveBy(learntTypes[inputType], attribute, object); err != nil {
+		return nil, err
+	}
+	if elem, found := execRetrieveIndexed(inputType, attribute, object); found {
+		if elem == nil {
+			return nil, nil
+		}
+		return util.CopyEntireObject(elem)
 	}
 	for _, elem := range data[inputType] {
 		if object == reflect.ValueOf(elem).Elem().FieldByName(attribute).Interface() {
@@ -167,8 +279,16 @@ func execRetrieveAll(inputType reflect.Type) (output any) {
 }
 
 func execBlobRetrieve(id string) (any, error) {
-	blob, _ := dbDriver.BlobRetrieve(id)
-	// TODO: The error is ignored, as it will probably be norows
+	var blob *[]byte
+	err := measure("BlobRetrieve", "", func() (err error) {
+		blob, err = dbDriver.BlobRetrieve(id)
+		return
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, util.Errorf("cannot retrieve blob %s: %w", id, err)
+	}
 	return blob, nil
 }
 
@@ -182,17 +302,37 @@ func execRemove(inputType reflect.Type, input any) error {
 
 func execRemoveById(inputType reflect.Type, id string) (err error) {
 	if !execExists(inputType, id) {
-		return util.Errorf("there is not element with such id")
+		return util.Errorf("%w: there is not element with such id", ErrNotFound)
+	}
+	plan, err := newRemovalPlan()
+	if err != nil {
+		return
 	}
-	err = dbDriver.Remove(getTableName(inputType), id)
+	err = plan.planRemoval(inputType, id)
 	if err == nil {
-		delete(data[inputType], id)
+		err = plan.checkRestrictions()
 	}
-	return
+	if err != nil {
+		return
+	}
+	return execApplyRemovalPlan(plan)
 }
 
+// execBlobRemove removes the blob, returning ErrNotFound if it does not exist
 func execBlobRemove(id string) (err error) {
-	return dbDriver.BlobRemove(id)
+	var exists bool
+	err = measure("BlobExists", "", func() (err error) {
+		exists, err = dbDriver.BlobExists(id)
+		return
+	})
+	if err != nil {
+		return err
+	} else if !exists {
+		return util.Errorf("%w: trying to remove unexistent blob", ErrNotFound)
+	}
+	return measure("BlobRemove", "", func() error {
+		return dbDriver.BlobRemove(id)
+	})
 }
 
 func execUpsert(inputType reflect.Type, object any, allowUpdate bool, allowCreate bool) (output any, err error) {
@@ -208,65 +348,142 @@ func execUpsert(inputType reflect.Type, object any, allowUpdate bool, allowCreat
 	if id != "" {
 		oldObject, existingObject = data[inputType][id]
 		if existingObject && !allowUpdate {
-			return nil, util.Errorf("trying to create an object with id in use")
+			return nil, util.Errorf("%w: trying to create an object with id in use", ErrConflict)
 		} else if !existingObject && !allowCreate {
-			return nil, util.Errorf("trying to update unexistent object")
+			return nil, util.Errorf("%w: trying to update unexistent object", ErrNotFound)
 		}
 	} else {
 		if !allowCreate {
-			return nil, util.Errorf("trying to update object without id")
+			return nil, util.Errorf("%w: trying to update object without id", ErrInvalid)
 		}
-		id, err = execNewID(inputType)
+		id, err = execNewID(inputType, object)
 		if err != nil {
 			return nil, err
 		}
 		util.SetId(inputType, object, id)
 	}
+	err = runBeforeWriteHooks(inputType, object, oldObject, existingObject)
+	if err != nil {
+		return nil, err
+	}
+	util.SetTimestamps(inputType, object, oldObject, time.Now().UTC().Round(0))
+	err = runValidateHook(object)
+	if err != nil {
+		return nil, err
+	}
+	err = execCheckReferences(inputType, object)
+	if err != nil {
+		return nil, err
+	}
 	objectString, err := json.Marshal(object)
 	if err != nil {
-		return nil, util.Errorf("cannot convert object to json: %s error: %v", object, err)
+		return nil, util.Errorf("%w: cannot convert object to json: %s error: %v", ErrInvalid, object, err)
 	}
 	if len(objectString) > util.MaxStructLength {
-		return nil, util.Errorf("serialized object too long to be stored")
+		return nil, util.Errorf("%w: serialized object too long to be stored", ErrInvalid)
+	}
+	for field := range learntTypes[inputType].Indexes {
+		value := reflect.ValueOf(object).Elem().FieldByName(field)
+		if value.Kind() == reflect.String && utf8.RuneCountInString(value.String()) > util.MaxIndexedLength {
+			return nil, util.Errorf("%w: %s is too long to be indexed (more than %d characters)", ErrInvalid, field, util.MaxIndexedLength)
+		}
 	}
 	data[inputType][id], err = util.CopyEntireObject(object)
 	if err != nil {
 		return nil, err
 	}
 	if existingObject {
-		err = dbDriver.Update(getTableName(inputType), id, string(objectString))
+		err = measure("Update", getTableName(inputType), func() error {
+			return dbDriver.Update(getTableName(inputType), id, string(objectString))
+		})
 		if err != nil {
 			data[inputType][id] = oldObject
+			logger.Warn("update rolled back", "type", learntTypes[inputType].Name, "id", id, "error", err)
 			return nil, err
 		}
 	} else {
-		err = dbDriver.Create(getTableName(inputType), id, string(objectString))
+		err = measure("Create", getTableName(inputType), func() error {
+			return dbDriver.Create(getTableName(inputType), id, string(objectString))
+		})
 		if err != nil {
 			delete(data[inputType], id)
+			logger.Warn("creation rolled back", "type", learntTypes[inputType].Name, "id", id, "error", err)
 			return nil, err
 		}
 	}
+	execIndexObject(inputType, id)
 	return id, nil
 }
 
-func execBlobUpsert(id string, contents *[]byte, allowUpdate bool, allowCreate bool) (err error) {
+// upsertOutput is the data returned by upsert actions
+type upsertOutput struct {
+	id      string
+	created bool
+}
+
+// execUpsertCreated creates or updates the object, reporting whether it was created
+func execUpsertCreated(inputType reflect.Type, object any) (output any, err error) {
+	id, err := util.GetId(object)
+	if err != nil {
+		return nil, err
+	}
+	created := id == "" || !execExists(inputType, id)
+	if output, err = execUpsert(inputType, object, true, true); err != nil {
+		return nil, err
+	}
+	return upsertOutput{id: output.(string), created: created}, nil
+}
+
+// execModify updates the stored object with the changes modify makes to a copy of it
+func execModify(inputType reflect.Type, id string, modify func(any) error) (output any, err error) {
+	object, exists := data[inputType][id]
+	if !exists {
+		return nil, util.Errorf("%w: trying to modify unexistent object", ErrNotFound)
+	}
+	if object, err = util.CopyEntireObject(object); err != nil {
+		return nil, err
+	}
+	if err = modify(object); err != nil {
+		return nil, err
+	}
+	if modifiedID, err := util.GetId(object); err != nil {
+		return nil, err
+	} else if modifiedID != id {
+		return nil, util.Errorf("%w: the id of the object cannot be modified", ErrInvalid)
+	}
+	if _, err = execUpsert(inputType, object, true, false); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// execBlobUpsert stores the blob, reporting whether it was created
+func execBlobUpsert(id string, contents *[]byte, allowUpdate bool, allowCreate bool) (created bool, err error) {
 	if len(*contents) > util.MaxBlobsLength {
-		return util.Errorf("blob too big to be stored")
+		return false, util.Errorf("%w: blob too big to be stored", ErrInvalid)
 	}
-	blobExists, err := dbDriver.BlobExists(id)
+	var blobExists bool
+	err = measure("BlobExists", "", func() (err error) {
+		blobExists, err = dbDriver.BlobExists(id)
+		return
+	})
 	if err != nil {
-		return util.Errorf("cannot determine if blob exists: %v", err)
+		return false, util.Errorf("cannot determine if blob exists: %v", err)
 	}
 	if blobExists {
 		if !allowUpdate {
-			return util.Errorf("trying to create an existing blob")
+			return false, util.Errorf("%w: trying to create an existing blob", ErrConflict)
 		}
-		return dbDriver.BlobUpdate(id, contents)
+		return false, measure("BlobUpdate", "", func() error {
+			return dbDriver.BlobUpdate(id, contents)
+		})
 	} else {
 		if !allowCreate {
-			return util.Errorf("trying to update an unexistent blob")
+			return false, util.Errorf("%w: trying to update an unexistent blob", ErrNotFound)
 		}
-		return dbDriver.BlobCreate(id, contents)
+		return true, measure("BlobCreate", "", func() error {
+			return dbDriver.BlobCreate(id, contents)
+		})
 	}
 }
 
@@ -275,27 +492,56 @@ func execExists(inputType reflect.Type, id string) (output bool) {
 	return
 }
 
+// checkRetrieveBy validates the attribute and the value searched against the learnt type
+func checkRetrieveBy(lt *util.LearntType, attribute string, value any) error {
+	filterType := lt.Fields[attribute]
+	if filterType == nil || reflect.TypeOf(value) != filterType {
+		return util.Errorf("cannot retrieve by attribute named %s with type %v: filter type is %v", attribute, reflect.TypeOf(value), filterType)
+	}
+	return nil
+}
+
 func execExistsBy(inputType reflect.Type, attribute string, object any) (bool, error) {
 	output, err := execRetrieveBy(inputType, attribute, object)
 	return output != nil, err
 }
 
-func execNewID(inputType reflect.Type) (string, error) {
+func execNewID(inputType reflect.Type, object any) (string, error) {
+	generator := idGenerators[inputType]
+	if generator == nil {
+		generator = defaultIDGenerator
+	}
+	if generator.unique {
+		id, err := generator.generate(inputType, object)
+		if err != nil {
+			return "", err
+		}
+		if execExists(inputType, id) {
+			return "", util.Errorf("generated id %s is already in use", id)
+		}
+		return id, nil
+	}
 	for i := 0; i < 100; i++ {
-		id := uuid.New().String()
+		id, err := generator.generate(inputType, object)
+		if err != nil {
+			return "", err
+		}
 		if !execExists(inputType, id) {
 			return id, nil
 		}
 	}
-	return "", util.Errorf("cannot generate a unique UUID after 100 attempts")
+	return "", util.Errorf("cannot generate an unused id after 100 attempts")
 }
 
 func newInternalAction(code int, inputType reflect.Type, object ...any) *internalAction {
+	queueDepth.Add(1)
 	return &internalAction{
 		code:      code,
 		inputType: inputType,
 		object:    object,
-		output:    make(chan actionOutput, 1)}
+		output:    make(chan actionOutput, 1),
+		queued:    time.Now(),
+		ctx:       context.Background()}
 }
 
 func getTableName(inputType reflect.Type) (output string) {
@@ -0,0 +1,238 @@
+package elephant
+
+import (
+	"cmp"
+	"context"
+	"reflect"
+	"slices"
+
+	"github.com/gonimals/elephant/internal/util"
+)
+
+// Number is the constraint of the results of Sum
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Count gets the number of elements of a specific type
+func Count[inputType any]() (int, error) {
+	return CountWhereContext[inputType](context.Background())
+}
+
+// CountContext is like Count, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func CountContext[inputType any](ctx context.Context) (int, error) {
+	return CountWhereContext[inputType](ctx)
+}
+
+// CountWhere gets the number of elements of a specific type matching every condition
+func CountWhere[inputType any](conditions ...Condition) (int, error) {
+	return CountWhereContext[inputType](context.Background(), conditions...)
+}
+
+// CountWhereContext is like CountWhere, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func CountWhereContext[inputType any](ctx context.Context, conditions ...Condition) (int, error) {
+	checkInitialization()
+	action := newInternalAction(actionCount, reflect.TypeFor[*inputType](), conditions)
+	action.ctx = ctx
+	output := send(action)
+	if output.err != nil {
+		return 0, output.err
+	}
+	return output.data.(int), nil
+}
+
+// Sum adds the numeric attribute of every element of a specific type
+func Sum[inputType any, N Number](attribute string) (N, error) {
+	return SumContext[inputType, N](context.Background(), attribute)
+}
+
+// SumContext is like Sum, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func SumContext[inputType any, N Number](ctx context.Context, attribute string) (N, error) {
+	checkInitialization()
+	action := newInternalAction(actionSum, reflect.TypeFor[*inputType](), attribute, reflect.TypeFor[N]())
+	action.ctx = ctx
+	output := send(action)
+	if output.err != nil {
+		return 0, output.err
+	}
+	return output.data.(N), nil
+}
+
+// MinBy gets the element of a specific type with the lowest numeric or string attribute.
+// Ties are resolved by id. Returns nil if there are no elements
+func MinBy[inputType any](attribute string) (*inputType, error) {
+	return MinByContext[inputType](context.Background(), attribute)
+}
+
+// MinByContext is like MinBy, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func MinByContext[inputType any](ctx context.Context, attribute string) (*inputType, error) {
+	checkInitialization()
+	action := newInternalAction(actionMinMax, reflect.TypeFor[*inputType](), attribute, -1)
+	action.ctx = ctx
+	return handleOutputType[*inputType](send(action), false)
+}
+
+// MaxBy gets the element of a specific type with the highest numeric or string attribute.
+// Ties are resolved by id. Returns nil if there are no elements
+func MaxBy[inputType any](attribute string) (*inputType, error) {
+	return MaxByContext[inputType](context.Background(), attribute)
+}
+
+// MaxByContext is like MaxBy, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func MaxByContext[inputType any](ctx context.Context, attribute string) (*inputType, error) {
+	checkInitialization()
+	action := newInternalAction(actionMinMax, reflect.TypeFor[*inputType](), attribute, 1)
+	action.ctx = ctx
+	return handleOutputType[*inputType](send(action), false)
+}
+
+// GroupBy gets the elements of a specific type grouped by an attribute of type K.
+// Elements in each group are ordered by id
+func GroupBy[inputType any, K comparable](attribute string) (map[K][]*inputType, error) {
+	return GroupByContext[inputType, K](context.Background(), attribute)
+}
+
+// GroupByContext is like GroupBy, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func GroupByContext[inputType any, K comparable](ctx context.Context, attribute string) (map[K][]*inputType, error) {
+	checkInitialization()
+	action := newInternalAction(actionGroupBy, reflect.TypeFor[*inputType](), attribute, reflect.TypeFor[K]())
+	action.ctx = ctx
+	output := send(action)
+	if output.err != nil {
+		return nil, output.err
+	}
+	groups := make(map[K][]*inputType)
+	for key, objects := range output.data.(map[any][]any) {
+		for _, object := range objects {
+			groups[key.(K)] = append(groups[key.(K)], object.(*inputType))
+		}
+	}
+	return groups, nil
+}
+
+// execCount counts the cached objects matching the conditions without copying them
+func execCount(inputType reflect.Type, conditions []Condition) (output any, err error) {
+	err = checkConditions(learntTypes[inputType], conditions)
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	for _, elem := range data[inputType] {
+		if matches(elem, conditions) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// checkAttribute returns an error if the attribute does not exist or has none of the given kinds
+func checkAttribute(inputType reflect.Type, attribute string, kinds ...reflect.Kind) error {
+	fieldType := learntTypes[inputType].Fields[attribute]
+	if fieldType == nil {
+		return util.Errorf("unknown attribute %s", attribute)
+	}
+	if len(kinds) > 0 && !slices.Contains(kinds, fieldType.Kind()) {
+		return util.Errorf("attribute %s has an unsupported type: %v", attribute, fieldType)
+	}
+	return nil
+}
+
+var /*const*/ numericKinds = []reflect.Kind{
+	reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+	reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+	reflect.Float32, reflect.Float64,
+}
+
+func execSum(inputType reflect.Type, attribute string, resultType reflect.Type) (output any, err error) {
+	err = checkAttribute(inputType, attribute, numericKinds...)
+	if err != nil {
+		return nil, err
+	}
+	sum := reflect.New(resultType).Elem()
+	for _, elem := range data[inputType] {
+		value := reflect.ValueOf(elem).Elem().FieldByName(attribute).Convert(resultType)
+		switch {
+		case sum.CanInt():
+			sum.SetInt(sum.Int() + value.Int())
+		case sum.CanUint():
+			sum.SetUint(sum.Uint() + value.Uint())
+		default:
+			sum.SetFloat(sum.Float() + value.Float())
+		}
+	}
+	return sum.Interface(), nil
+}
+
+// compareFields compares two values of the same numeric or string type
+func compareFields(first reflect.Value, second reflect.Value) int {
+	switch {
+	case first.CanInt():
+		return cmp.Compare(first.Int(), second.Int())
+	case first.CanUint():
+		return cmp.Compare(first.Uint(), second.Uint())
+	case first.CanFloat():
+		return cmp.Compare(first.Float(), second.Float())
+	default:
+		return cmp.Compare(first.String(), second.String())
+	}
+}
+
+// execMinMax finds the object with the lowest attribute if sign is -1, or the highest if it is 1
+func execMinMax(inputType reflect.Type, attribute string, sign int) (output any, err error) {
+	err = checkAttribute(inputType, attribute, append(numericKinds, reflect.String)...)
+	if err != nil {
+		return nil, err
+	}
+	var selected any
+	var selectedId string
+	for id, elem := range data[inputType] {
+		if selected == nil {
+			selected, selectedId = elem, id
+			continue
+		}
+		comparison := compareFields(reflect.ValueOf(elem).Elem().FieldByName(attribute),
+			reflect.ValueOf(selected).Elem().FieldByName(attribute)) * sign
+		if comparison > 0 || (comparison == 0 && id < selectedId) {
+			selected, selectedId = elem, id
+		}
+	}
+	if selected == nil {
+		return nil, nil
+	}
+	return util.CopyEntireObject(selected)
+}
+
+func execGroupBy(inputType reflect.Type, attribute string, keyType reflect.Type) (output any, err error) {
+	err = checkAttribute(inputType, attribute)
+	if err != nil {
+		return nil, err
+	}
+	if learntTypes[inputType].Fields[attribute] != keyType {
+		return nil, util.Errorf("cannot group by attribute %s of type %v with keys of type %v",
+			attribute, learntTypes[inputType].Fields[attribute], keyType)
+	}
+	ids := execListIDs(inputType)
+	groups := make(map[any][]any)
+	for _, id := range ids {
+		elem := data[inputType][id]
+		key := reflect.ValueOf(elem).Elem().FieldByName(attribute).Interface()
+		elemCopy, err := util.CopyEntireObject(elem)
+		if err != nil {
+			return nil, err
+		}
+		groups[key] = append(groups[key], elemCopy)
+	}
+	return groups, nil
+}
+
+// execListIDs returns the ids of the cached objects, sorted
+func execListIDs(inputType reflect.Type) []string {
+	ids := make([]string, 0, len(data[inputType]))
+	for id := range data[inputType] {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
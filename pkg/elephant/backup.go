@@ -0,0 +1,346 @@
+package elephant
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// RestoreMode decides what happens with the stored data when a backup is restored
+type RestoreMode int
+
+const (
+	// RestoreReplace removes every stored object and blob before restoring the backup
+	RestoreReplace RestoreMode = iota
+	// RestoreMerge keeps the stored data, overwriting the objects and blobs found in the backup
+	RestoreMerge
+)
+
+// backupFormat and backupVersion identify the backups. Newer versions are rejected.
+// Version 2 records the context symbol of the source in the header
+const (
+	backupFormat  = "elephant-backup"
+	backupVersion = 2
+)
+
+// backupRecord is each line of a backup. The first one is a header with the format,
+// version and context symbol, followed by one line per object or blob and a last one counting them
+type backupRecord struct {
+	Format        string    `json:"format,omitempty"`
+	Version       int       `json:"version,omitempty"`
+	Created       time.Time `json:"created,omitzero"`
+	ContextSymbol string    `json:"contextSymbol,omitempty"`
+	Table         string    `json:"table,omitempty"`
+	Id            string    `json:"id,omitempty"`
+	Value         string    `json:"value,omitempty"`
+	Blob          string    `json:"blob,omitempty"`
+	Data          []byte    `json:"data,omitempty"`
+	End           bool      `json:"end,omitempty"`
+	Objects       int       `json:"objects,omitempty"`
+	Blobs         int       `json:"blobs,omitempty"`
+}
+
+// Backup writes every table and blob of the database to w, one JSON object per line.
+// Values are copied as stored, so types not used by this program are included too.
+// Other calls wait until the backup is written, so it reflects a single moment.
+// The driver must implement db.Lister
+func Backup(w io.Writer) error {
+	return BackupContext(context.Background(), w)
+}
+
+// BackupContext is like Backup, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func BackupContext(ctx context.Context, w io.Writer) error {
+	checkInitialization()
+	action := newInternalAction(actionBackup, blobReflectType, w)
+	action.ctx = ctx
+	return (send(action)).err
+}
+
+// Restore reads a backup written by Backup and stores its contents. The whole backup is
+// read and checked before changing anything, but a driver failure while restoring can
+// leave it partially applied. Hooks and references are not checked. RestoreReplace
+// needs a driver implementing db.Lister
+func Restore(r io.Reader, mode RestoreMode) error {
+	return RestoreContext(context.Background(), r, mode)
+}
+
+// RestoreContext is like Restore, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func RestoreContext(ctx context.Context, r io.Reader, mode RestoreMode) error {
+	checkInitialization()
+	if mode != RestoreReplace && mode != RestoreMerge {
+		return util.Errorf("unknown restore mode: %d", mode)
+	}
+	if remote != nil {
+		// the remote store reads and checks the backup itself, with its context symbol
+		action := newInternalAction(actionRestore, blobReflectType, r, mode)
+		action.ctx = ctx
+		return (send(action)).err
+	}
+	records, err := readBackup(r, dbDriver.GetContextSymbol())
+	if err != nil {
+		return err
+	}
+	action := newInternalAction(actionRestore, blobReflectType, records, mode)
+	action.ctx = ctx
+	return (send(action)).err
+}
+
+// readBackup returns the object and blob records of a backup, checking that it is complete.
+// Table names are renamed from the context symbol of the source to contextSymbol, like in Copy
+func readBackup(r io.Reader, contextSymbol string) (output []backupRecord, err error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	var header backupRecord
+	if err = decoder.Decode(&header); err != nil {
+		return nil, util.Errorf("cannot read backup header: %v", err)
+	}
+	if header.Format != backupFormat || header.Version < 1 || header.Version > backupVersion {
+		return nil, util.Errorf("unsupported backup: %s version %d", header.Format, header.Version)
+	}
+	sourceSymbol := header.ContextSymbol
+	if header.Version < 2 {
+		sourceSymbol = contextSymbol // older backups do not record it
+	}
+	if len(sourceSymbol) != 1 || util.IsAlphanumeric(sourceSymbol) {
+		return nil, util.Errorf("invalid context symbol in backup: %q", sourceSymbol)
+	}
+	objects, blobs := 0, 0
+	for {
+		var record backupRecord
+		if err = decoder.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, util.Errorf("backup is truncated after %d objects and %d blobs", objects, blobs)
+			}
+			return nil, util.Errorf("cannot read backup: %v", err)
+		}
+		switch {
+		case record.End:
+			if record.Objects != objects || record.Blobs != blobs {
+				return nil, util.Errorf("backup contains %d objects and %d blobs, expected %d and %d",
+					objects, blobs, record.Objects, record.Blobs)
+			}
+			if decoder.More() {
+				return nil, util.Errorf("backup contains data after its end")
+			}
+			return output, nil
+		case record.Table != "":
+			table := strings.ReplaceAll(record.Table, sourceSymbol, contextSymbol)
+			if !db.IsValidTableName(record.Table, sourceSymbol) || !db.IsValidTableName(table, contextSymbol) ||
+				strings.EqualFold(table, BlobsTable) || record.Id == "" || record.Value == "" {
+				return nil, util.Errorf("invalid object in backup: %s %s", record.Table, record.Id)
+			}
+			record.Table = table
+			objects++
+		case record.Blob != "":
+			blobs++
+		default:
+			return nil, util.Errorf("unknown record in backup after %d objects and %d blobs", objects, blobs)
+		}
+		output = append(output, record)
+	}
+}
+
+// execBackup writes the backup of the whole database to w
+func execBackup(w io.Writer) error {
+	lister, isLister := dbDriver.(db.Lister)
+	if !isLister {
+		return util.Errorf("the driver cannot list its contents")
+	}
+	var tables, blobIDs []string
+	err := measure("Tables", "", func() (err error) {
+		tables, err = lister.Tables()
+		return
+	})
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	header := backupRecord{Format: backupFormat, Version: backupVersion, Created: time.Now().UTC(), ContextSymbol: dbDriver.GetContextSymbol()}
+	if err = encoder.Encode(header); err != nil {
+		return util.Errorf("cannot write backup: %v", err)
+	}
+	trailer := backupRecord{End: true}
+	for _, table := range tables {
+		err = execScanTable(table, func(id string, value string) error {
+			trailer.Objects++
+			return encoder.Encode(backupRecord{Table: table, Id: id, Value: value})
+		})
+		if err != nil {
+			return util.Errorf("cannot back up %s: %v", table, err)
+		}
+	}
+	err = measure("BlobIDs", "", func() (err error) {
+		blobIDs, err = lister.BlobIDs()
+		return
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range blobIDs {
+		var blob *[]byte
+		err = measure("BlobRetrieve", "", func() (err error) {
+			blob, err = dbDriver.BlobRetrieve(id)
+			return
+		})
+		if err != nil {
+			return util.Errorf("cannot back up blob %s: %v", id, err)
+		}
+		trailer.Blobs++
+		if err = encoder.Encode(backupRecord{Blob: id, Data: *blob}); err != nil {
+			return util.Errorf("cannot write backup: %v", err)
+		}
+	}
+	if err = encoder.Encode(trailer); err != nil {
+		return util.Errorf("cannot write backup: %v", err)
+	}
+	if err = writer.Flush(); err != nil {
+		return util.Errorf("cannot write backup: %v", err)
+	}
+	logger.Info("backup written", "tables", len(tables), "objects", trailer.Objects, "blobs", trailer.Blobs)
+	return nil
+}
+
+// execRestore stores the records of a backup and reloads the managed types
+func execRestore(records []backupRecord, mode RestoreMode) (err error) {
+	defer func() {
+		if reloadErr := execReloadTypes(); err == nil {
+			err = reloadErr
+		}
+	}()
+	if mode == RestoreReplace {
+		if err = execEmptyDatabase(); err != nil {
+			return err
+		}
+	}
+	for _, record := range records {
+		if record.Table != "" {
+			err = execRestoreObject(record.Table, record.Id, record.Value)
+		} else {
+			err = execBlobRestore(record.Blob, record.Data)
+		}
+		if err != nil {
+			logger.Error("restore interrupted", "table", record.Table, "id", record.Id, "blob", record.Blob, "error", err)
+			return err
+		}
+	}
+	logger.Info("backup restored", "records", len(records))
+	return nil
+}
+
+// execEmptyDatabase removes every object and blob stored
+func execEmptyDatabase() error {
+	lister, isLister := dbDriver.(db.Lister)
+	if !isLister {
+		return util.Errorf("the driver cannot list its contents")
+	}
+	var tables, blobIDs []string
+	err := measure("Tables", "", func() (err error) {
+		tables, err = lister.Tables()
+		return
+	})
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		var ids []string
+		err = execScanTable(table, func(id string, value string) error {
+			ids = append(ids, id)
+			return nil
+		})
+		for _, id := range ids {
+			if err != nil {
+				break
+			}
+			err = measure("Remove", table, func() error {
+				return dbDriver.Remove(table, id)
+			})
+		}
+		if err != nil {
+			return util.Errorf("cannot empty %s: %v", table, err)
+		}
+	}
+	err = measure("BlobIDs", "", func() (err error) {
+		blobIDs, err = lister.BlobIDs()
+		return
+	})
+	for _, id := range blobIDs {
+		if err != nil {
+			break
+		}
+		err = measure("BlobRemove", "", func() error {
+			return dbDriver.BlobRemove(id)
+		})
+	}
+	if err != nil {
+		return util.Errorf("cannot empty blobs: %v", err)
+	}
+	return nil
+}
+
+// execRestoreObject creates or updates a stored object
+func execRestoreObject(table string, id string, value string) error {
+	var stored string
+	err := measure("Retrieve", table, func() (err error) {
+		stored, err = dbDriver.Retrieve(table, id)
+		return
+	})
+	if err != nil || stored == value {
+		return err
+	}
+	if stored == "" {
+		return measure("Create", table, func() error {
+			return dbDriver.Create(table, id, value)
+		})
+	}
+	return measure("Update", table, func() error {
+		return dbDriver.Update(table, id, value)
+	})
+}
+
+// execBlobRestore creates or updates a stored blob
+func execBlobRestore(id string, contents []byte) error {
+	var exists bool
+	err := measure("BlobExists", "", func() (err error) {
+		exists, err = dbDriver.BlobExists(id)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return measure("BlobCreate", "", func() error {
+			return dbDriver.BlobCreate(id, &contents)
+		})
+	}
+	return measure("BlobUpdate", "", func() error {
+		return dbDriver.BlobUpdate(id, &contents)
+	})
+}
+
+// execReloadTypes discards the cache and loads again every managed type
+func execReloadTypes() error {
+	clear(sequences)
+	var reloaded []reflect.Type
+	for inputType := range managedTypes {
+		if inputType != blobReflectType {
+			reloaded = append(reloaded, inputType)
+		}
+	}
+	var errs []error
+	for _, inputType := range reloaded {
+		delete(managedTypes, inputType)
+		delete(searchIndexes, inputType)
+		if err := execManageType(inputType); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
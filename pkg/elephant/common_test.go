@@ -2,12 +2,16 @@ package elephant
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 )
 
 const mysqlTestDB = "root:@tcp(127.0.0.1:33060)/elephant"
 const sqlite3TestDB = "/tmp/foo.db"
+const kvTestDB = "/tmp/foo.kv"
+const memoryTestDB = "foo"
 
 type structCheck struct {
 	Mystring string `db:"id"`
@@ -23,6 +27,131 @@ type failingStructCheck struct {
 	Mybool   bool
 }
 
+type hookedStructCheck struct {
+	Mystring string `db:"id"`
+	Myint    int
+	Updates  int
+	Loaded   bool
+}
+
+func (h *hookedStructCheck) BeforeCreate() error {
+	h.Updates = 0
+	return nil
+}
+
+func (h *hookedStructCheck) BeforeUpdate(old hookedStructCheck) error {
+	h.Updates = old.Updates + 1
+	return nil
+}
+
+func (h *hookedStructCheck) AfterLoad() error {
+	if h.Loaded {
+		return errors.New("Loaded should not be stored")
+	}
+	return nil
+}
+
+func (h *hookedStructCheck) Validate() error {
+	if h.Myint < 0 {
+		return errors.New("Myint cannot be negative")
+	}
+	return nil
+}
+
+type timestampedStructCheck struct {
+	Mystring  string `db:"id"`
+	Myint     int
+	CreatedAt time.Time `db:"created"`
+	UpdatedAt time.Time `db:"updated"`
+}
+
+type failingTimestampedStructCheck struct {
+	Mystring  string `db:"id"`
+	CreatedAt string `db:"created"`
+}
+
+type customerCheck struct {
+	Id   string `db:"id"`
+	Name string
+}
+
+type orderCheck struct {
+	Id         string `db:"id"`
+	CustomerID string `db:"ref=customerCheck"`
+}
+
+type orderLineCheck struct {
+	Id      string `db:"id"`
+	OrderID string `db:"ref=orderCheck,onremove=cascade"`
+}
+
+type noteCheck struct {
+	Id         string `db:"id"`
+	CustomerID string `db:"ref=customerCheck,onremove=setempty"`
+}
+
+type projectCheck struct {
+	Id string `db:"id"`
+}
+
+type taskCheck struct {
+	Id        string `db:"id"`
+	ProjectID string `db:"ref=projectCheck,onremove=cascade"`
+}
+
+type commentCheck struct {
+	Id        string `db:"id"`
+	TaskID    string `db:"ref=taskCheck"`
+	ProjectID string `db:"ref=projectCheck,onremove=cascade"`
+}
+
+type invoiceCheck struct {
+	Id         string `db:"id"`
+	CustomerID string `db:"ref=customerCheck"`
+}
+
+type failingRefCheck struct {
+	Id         string `db:"id"`
+	CustomerID string `db:"ref=customerCheck,onremove=unknown"`
+}
+
+type indexedStructCheck struct {
+	Id     string `db:"id"`
+	Email  string `db:"index" json:"email"`
+	Age    int    `db:"index"`
+	Active bool   `db:"index"`
+}
+
+type filteredStructCheck struct {
+	Id    string `db:"id"`
+	Name  string `json:"name"`
+	Score uint   `db:"index"`
+	Level int    `json:",omitempty"`
+	Admin bool
+	Tags  []string
+}
+
+type searchableStructCheck struct {
+	Id    string `db:"id"`
+	Title string `db:"search"`
+	Body  string `db:"search"`
+}
+
+type failingSearchCheck struct {
+	Id    string `db:"id"`
+	Views int    `db:"search"`
+}
+
+type failingIndexCheck struct {
+	Id   string   `db:"id"`
+	Tags []string `db:"index"`
+}
+
+type failingIndexNameCheck struct {
+	Id    string `db:"id"`
+	Email string `db:"index" json:"e-mail"`
+}
+
 func cleanMysqlTestDB() {
 	// Connect
 	db, err := sql.Open("mysql", mysqlTestDB)
@@ -0,0 +1,279 @@
+package elephant
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// BlobsTable is the name given to the blobs in CopyOptions and CopyStats
+const BlobsTable = "blobs"
+
+// CopyOptions configures Copy
+type CopyOptions struct {
+	// Tables limits the copy to these tables, BlobsTable included. Everything is copied when empty
+	Tables []string
+	// Prune removes from the destination the elements which are not in the source
+	Prune bool
+}
+
+// CopyStats describes the copy of one table
+type CopyStats struct {
+	Table    string // name of the table in the source
+	Copied   int    // elements created or updated in the destination
+	Skipped  int    // elements already in the destination with the same value
+	Removed  int    // elements removed from the destination because of CopyOptions.Prune
+	Count    int    // elements in the source, checked in the destination after the copy
+	Checksum string // checksum of the elements, the same in both databases after the copy
+}
+
+// Copy stores the tables and blobs of the database at srcURI in the one at dstURI, using
+// their drivers without initializing elephant. Elements already present with the same value
+// are skipped, so an interrupted copy can be resumed by repeating it. Every table is verified
+// after being copied, comparing the count and checksum of the elements in both databases,
+// so elements only present in the destination make the copy fail unless they are pruned.
+// JSON values are compared ignoring formatting and key order, because some databases
+// rewrite them. Table names are translated to the context symbol of the destination.
+// Both drivers must implement db.Lister
+func Copy(srcURI string, dstURI string, options CopyOptions) (output []CopyStats, err error) {
+	if srcURI == dstURI {
+		return nil, util.Errorf("source and destination are the same: %s", srcURI)
+	}
+	src, err := OpenDriver(srcURI)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	dst, err := OpenDriver(dstURI)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+	srcLister, isLister := src.(db.Lister)
+	if !isLister {
+		return nil, util.Errorf("the source driver cannot list its contents")
+	}
+	if _, isLister = dst.(db.Lister); !isLister {
+		return nil, util.Errorf("the destination driver cannot list its contents")
+	}
+
+	tables, err := srcLister.Tables()
+	if err != nil {
+		return nil, util.Errorf("cannot list the source tables: %v", err)
+	}
+	includeBlobs := len(options.Tables) == 0 || slices.Contains(options.Tables, BlobsTable)
+	if len(options.Tables) > 0 {
+		for _, table := range options.Tables {
+			if table != BlobsTable && !slices.Contains(tables, table) {
+				return nil, util.Errorf("table not found in the source: %s", table)
+			}
+		}
+		tables = slices.DeleteFunc(tables, func(table string) bool {
+			return !slices.Contains(options.Tables, table)
+		})
+	}
+	for _, table := range tables {
+		dstTable := strings.ReplaceAll(table, src.GetContextSymbol(), dst.GetContextSymbol())
+		if !db.IsValidTableName(dstTable, dst.GetContextSymbol()) {
+			return output, util.Errorf("cannot copy %s: the destination does not accept the name %s", table, dstTable)
+		}
+		stats, err := copyTable(src, dst, table, dstTable, options.Prune)
+		if err != nil {
+			return output, util.Errorf("cannot copy %s: %v", table, err)
+		}
+		logger.Info("table copied", "table", table, "copied", stats.Copied, "skipped", stats.Skipped, "removed", stats.Removed)
+		output = append(output, stats)
+	}
+	if includeBlobs {
+		stats, err := copyBlobs(src, dst, options.Prune)
+		if err != nil {
+			return output, util.Errorf("cannot copy blobs: %v", err)
+		}
+		logger.Info("blobs copied", "copied", stats.Copied, "skipped", stats.Skipped, "removed", stats.Removed)
+		output = append(output, stats)
+	}
+	return output, nil
+}
+
+// elementChecksum accumulates the hashes of the elements of a table. The order of the elements does not matter
+type elementChecksum struct {
+	count int
+	sum   [sha256.Size]byte
+}
+
+func (c *elementChecksum) add(hash [sha256.Size]byte) {
+	c.count++
+	for i := range c.sum {
+		c.sum[i] ^= hash[i]
+	}
+}
+
+func (c *elementChecksum) String() string {
+	return hex.EncodeToString(c.sum[:])
+}
+
+// hashElement returns the hash of an element, ignoring the formatting of JSON values
+func hashElement(id string, value []byte) [sha256.Size]byte {
+	if json.Valid(value) {
+		var decoded any
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+		if decoder.Decode(&decoded) == nil {
+			if canonical, err := json.Marshal(decoded); err == nil {
+				value = canonical
+			}
+		}
+	}
+	return hashBlob(id, value)
+}
+
+// hashBlob returns the hash of an element without interpreting its value
+func hashBlob(id string, value []byte) [sha256.Size]byte {
+	return sha256.Sum256(append(append([]byte(id), 0), value...))
+}
+
+// checksumTable returns the checksum of the elements of a table
+func checksumTable(driver db.Driver, table string) (output elementChecksum, err error) {
+	err = db.Scan(driver, table, func(id string, value string) error {
+		output.add(hashElement(id, []byte(value)))
+		return nil
+	})
+	return
+}
+
+// copyTable copies the elements of a table which are missing or different in dstTable
+func copyTable(src db.Driver, dst db.Driver, table string, dstTable string, prune bool) (stats CopyStats, err error) {
+	stats.Table = table
+	existing := make(map[string][sha256.Size]byte)
+	err = db.Scan(dst, dstTable, func(id string, value string) error {
+		existing[id] = hashElement(id, []byte(value))
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	err = db.Scan(src, table, func(id string, value string) error {
+		stored, exists := existing[id]
+		delete(existing, id)
+		switch {
+		case !exists:
+			stats.Copied++
+			return dst.Create(dstTable, id, value)
+		case stored != hashElement(id, []byte(value)):
+			stats.Copied++
+			return dst.Update(dstTable, id, value)
+		default:
+			stats.Skipped++
+			return nil
+		}
+	})
+	if err != nil {
+		return
+	}
+	if prune {
+		for id := range existing {
+			if err = dst.Remove(dstTable, id); err != nil {
+				return
+			}
+			stats.Removed++
+		}
+	}
+	srcChecksum, err := checksumTable(src, table)
+	if err != nil {
+		return
+	}
+	dstChecksum, err := checksumTable(dst, dstTable)
+	if err != nil {
+		return
+	}
+	if srcChecksum != dstChecksum {
+		return stats, util.Errorf("verification failed: %d elements (%s) in the source, %d (%s) in the destination",
+			srcChecksum.count, &srcChecksum, dstChecksum.count, &dstChecksum)
+	}
+	stats.Count = srcChecksum.count
+	stats.Checksum = srcChecksum.String()
+	return
+}
+
+// checksumBlobs returns the checksum of every blob, and their hashes if requested
+func checksumBlobs(driver db.Driver, hashes map[string][sha256.Size]byte) (output elementChecksum, err error) {
+	ids, err := driver.(db.Lister).BlobIDs()
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		blob, err := driver.BlobRetrieve(id)
+		if err != nil {
+			return output, err
+		}
+		hash := hashBlob(id, *blob)
+		output.add(hash)
+		if hashes != nil {
+			hashes[id] = hash
+		}
+	}
+	return
+}
+
+// copyBlobs copies the blobs which are missing or different in the destination
+func copyBlobs(src db.Driver, dst db.Driver, prune bool) (stats CopyStats, err error) {
+	stats.Table = BlobsTable
+	existing := make(map[string][sha256.Size]byte)
+	if _, err = checksumBlobs(dst, existing); err != nil {
+		return
+	}
+	ids, err := src.(db.Lister).BlobIDs()
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		blob, err := src.BlobRetrieve(id)
+		if err != nil {
+			return stats, err
+		}
+		stored, exists := existing[id]
+		delete(existing, id)
+		switch {
+		case !exists:
+			err = dst.BlobCreate(id, blob)
+		case stored != hashBlob(id, *blob):
+			err = dst.BlobUpdate(id, blob)
+		default:
+			stats.Skipped++
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Copied++
+	}
+	if prune {
+		for id := range existing {
+			if err = dst.BlobRemove(id); err != nil {
+				return
+			}
+			stats.Removed++
+		}
+	}
+	srcChecksum, err := checksumBlobs(src, nil)
+	if err != nil {
+		return
+	}
+	dstChecksum, err := checksumBlobs(dst, nil)
+	if err != nil {
+		return
+	}
+	if srcChecksum != dstChecksum {
+		return stats, util.Errorf("verification failed: %d blobs (%s) in the source, %d (%s) in the destination",
+			srcChecksum.count, &srcChecksum, dstChecksum.count, &dstChecksum)
+	}
+	stats.Count = srcChecksum.count
+	stats.Checksum = srcChecksum.String()
+	return
+}
@@ -1,25 +1,29 @@
 package elephant
 
 import (
-	"log"
 	"reflect"
 	"sync"
 
-	"github.com/gonimals/elephant/internal/db"
 	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
 )
 
 var (
-	data         map[reflect.Type](map[string]any)
-	learntTypes  map[reflect.Type]*util.LearntType
-	channel      chan *internalAction
-	waitgroup    sync.WaitGroup
-	managedTypes map[reflect.Type]bool
-	dbDriver     db.Driver
+	data          map[reflect.Type](map[string]any)
+	learntTypes   map[reflect.Type]*util.LearntType
+	channel       chan *internalAction
+	waitgroup     sync.WaitGroup
+	managedTypes  map[reflect.Type]bool
+	dbDriver      db.Driver
+	idGenerators  map[reflect.Type]*idGenerator
+	sequences     map[string]uint64
+	searchIndexes map[reflect.Type]*searchIndex
+	// remote is set instead of the rest when initialized with a remote: uri
+	remote *remoteClient
 )
 
 func checkInitialization() {
-	if dbDriver == nil {
-		log.Panic("Trying to use an uninitialized instance")
+	if dbDriver == nil && remote == nil {
+		panic(util.Errorf("trying to use an uninitialized instance"))
 	}
 }
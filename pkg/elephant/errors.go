@@ -0,0 +1,19 @@
+package elephant
+
+import (
+	"errors"
+
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// Errors wrapped by the errors returned by elephant, telling why an operation was rejected.
+// Other errors come from the driver or from types elephant cannot manage
+var (
+	// ErrNotFound is wrapped when the object or blob to update or remove does not exist
+	ErrNotFound = db.ErrNotFound
+	// ErrConflict is wrapped when the operation conflicts with the stored data, like
+	// creating an object with an id in use or removing a referenced object
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid is wrapped when the object is rejected by its hooks, references or size
+	ErrInvalid = errors.New("invalid")
+)
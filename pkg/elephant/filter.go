@@ -0,0 +1,179 @@
+package elephant
+
+import (
+	"cmp"
+	"context"
+	"reflect"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// Condition compares an attribute of the stored objects with a value of the same type.
+// Conditions are built with Eq, Ne, Gt, Ge, Lt and Le
+type Condition = db.Condition
+
+// Eq matches the objects whose attribute equals value
+func Eq(attribute string, value any) Condition {
+	return Condition{Attribute: attribute, Operator: db.Equal, Value: value}
+}
+
+// Ne matches the objects whose attribute is different from value
+func Ne(attribute string, value any) Condition {
+	return Condition{Attribute: attribute, Operator: db.NotEqual, Value: value}
+}
+
+// Gt matches the objects whose attribute is greater than value
+func Gt(attribute string, value any) Condition {
+	return Condition{Attribute: attribute, Operator: db.Greater, Value: value}
+}
+
+// Ge matches the objects whose attribute is greater than or equal to value
+func Ge(attribute string, value any) Condition {
+	return Condition{Attribute: attribute, Operator: db.GreaterOrEqual, Value: value}
+}
+
+// Lt matches the objects whose attribute is less than value
+func Lt(attribute string, value any) Condition {
+	return Condition{Attribute: attribute, Operator: db.Less, Value: value}
+}
+
+// Le matches the objects whose attribute is less than or equal to value
+func Le(attribute string, value any) Condition {
+	return Condition{Attribute: attribute, Operator: db.LessOrEqual, Value: value}
+}
+
+// Filter gets the elements of a specific type matching every condition. Attributes are
+// string, integer or bool parameters. SQL backends evaluate the conditions in the database
+func Filter[inputType any](conditions ...Condition) (map[string]*inputType, error) {
+	return FilterContext[inputType](context.Background(), conditions...)
+}
+
+// FilterContext is like Filter, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func FilterContext[inputType any](ctx context.Context, conditions ...Condition) (map[string]*inputType, error) {
+	checkInitialization()
+	action := newInternalAction(actionFilter, reflect.TypeFor[*inputType](), conditions)
+	action.ctx = ctx
+	output := send(action)
+	if output.err != nil {
+		return nil, output.err
+	}
+	return handleOutputMap[map[string]*inputType](output)
+}
+
+// checkConditions validates the conditions against the learnt type
+func checkConditions(lt *util.LearntType, conditions []Condition) error {
+	for _, condition := range conditions {
+		filterType := lt.Fields[condition.Attribute]
+		if filterType == nil || reflect.TypeOf(condition.Value) != filterType {
+			return util.Errorf("cannot filter by attribute named %s with type %v: filter type is %v",
+				condition.Attribute, reflect.TypeOf(condition.Value), filterType)
+		}
+		value := util.IndexValue(condition.Value)
+		if value == nil {
+			return util.Errorf("cannot filter by attribute named %s: only strings, integers and bools are allowed", condition.Attribute)
+		}
+		_, isBool := value.(bool)
+		if !db.IsValidOperator(condition.Operator) ||
+			(isBool && condition.Operator != db.Equal && condition.Operator != db.NotEqual) {
+			return util.Errorf("cannot filter by attribute named %s with operator %s", condition.Attribute, condition.Operator)
+		}
+	}
+	return nil
+}
+
+// matches evaluates the conditions over an object
+func matches(object any, conditions []Condition) bool {
+	for _, condition := range conditions {
+		comparison := compareValues(reflect.ValueOf(object).Elem().FieldByName(condition.Attribute).Interface(), condition.Value)
+		var matched bool
+		switch condition.Operator {
+		case db.Equal:
+			matched = comparison == 0
+		case db.NotEqual:
+			matched = comparison != 0
+		case db.Greater:
+			matched = comparison > 0
+		case db.GreaterOrEqual:
+			matched = comparison >= 0
+		case db.Less:
+			matched = comparison < 0
+		case db.LessOrEqual:
+			matched = comparison <= 0
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// compareValues compares two values of the same string, integer or bool type. false is less than true
+func compareValues(first any, second any) int {
+	switch reference := util.IndexValue(second).(type) {
+	case string:
+		return cmp.Compare(util.IndexValue(first).(string), reference)
+	case int64:
+		return cmp.Compare(util.IndexValue(first).(int64), reference)
+	case uint64:
+		return cmp.Compare(util.IndexValue(first).(uint64), reference)
+	case bool:
+		if util.IndexValue(first).(bool) == reference {
+			return 0
+		} else if reference {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// execFilter asks the driver for the candidates, if it can evaluate the conditions,
+// and checks them against the cached objects
+func execFilter(inputType reflect.Type, conditions []Condition) (output any, err error) {
+	err = checkConditions(learntTypes[inputType], conditions)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]any)
+	if ids, pushed := execQueryDriver(inputType, conditions); pushed {
+		for _, id := range ids {
+			if elem, exists := data[inputType][id]; exists && matches(elem, conditions) {
+				result[id] = elem
+			}
+		}
+		return result, nil
+	}
+	for id, elem := range data[inputType] {
+		if matches(elem, conditions) {
+			result[id] = elem
+		}
+	}
+	return result, nil
+}
+
+// execQueryDriver returns the candidates given by the driver. pushed is false when
+// the driver cannot evaluate the conditions and the cache must be scanned
+func execQueryDriver(inputType reflect.Type, conditions []Condition) (ids []string, pushed bool) {
+	querier, isQuerier := dbDriver.(db.Querier)
+	if !isQuerier || len(conditions) == 0 {
+		return nil, false
+	}
+	translated := make([]Condition, len(conditions))
+	for i, condition := range conditions {
+		attribute, stored := learntTypes[inputType].Stored[condition.Attribute]
+		if !stored {
+			return nil, false // missing attributes would not match the zero value
+		}
+		translated[i] = Condition{Attribute: attribute, Operator: condition.Operator, Value: util.IndexValue(condition.Value)}
+	}
+	err := measure("RetrieveIDsWhere", getTableName(inputType), func() (err error) {
+		ids, err = querier.RetrieveIDsWhere(getTableName(inputType), translated)
+		return
+	})
+	if err != nil {
+		logger.Warn("filter in the database failed, scanning the cache", "type", learntTypes[inputType].Name, "error", err)
+		return nil, false
+	}
+	return ids, true
+}
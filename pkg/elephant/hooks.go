@@ -0,0 +1,80 @@
+package elephant
+
+import (
+	"reflect"
+
+	"github.com/gonimals/elephant/internal/util"
+)
+
+// BeforeCreator is implemented by stored types which need to run code
+// before being inserted. Returning an error aborts the creation
+type BeforeCreator interface {
+	BeforeCreate() error
+}
+
+// BeforeUpdater is implemented by stored types which need to run code
+// before being updated. old is a copy of the currently stored object.
+// Returning an error aborts the update
+type BeforeUpdater[T any] interface {
+	BeforeUpdate(old T) error
+}
+
+// AfterLoader is implemented by stored types which need to run code
+// after being read from the database. If any object returns an error,
+// the type is not loaded and every call using it fails until it is fixed
+type AfterLoader interface {
+	AfterLoad() error
+}
+
+// Validator is implemented by stored types which must be checked before
+// any write. Returning an error aborts the write
+type Validator interface {
+	Validate() error
+}
+
+// runBeforeWriteHooks invokes BeforeCreate or BeforeUpdate on object
+func runBeforeWriteHooks(inputType reflect.Type, object any, oldObject any, existingObject bool) error {
+	if existingObject {
+		method := reflect.ValueOf(object).MethodByName("BeforeUpdate")
+		if method.IsValid() {
+			methodType := method.Type()
+			if methodType.NumIn() != 1 || methodType.In(0) != inputType.Elem() ||
+				methodType.NumOut() != 1 || methodType.Out(0) != reflect.TypeFor[error]() {
+				return util.Errorf("%s has a BeforeUpdate method with an unexpected signature", inputType.String())
+			}
+			oldCopy, err := util.CopyEntireObject(oldObject)
+			if err != nil {
+				return err
+			}
+			result := method.Call([]reflect.Value{reflect.ValueOf(oldCopy).Elem()})
+			if err, _ := result[0].Interface().(error); err != nil {
+				return util.Errorf("%w: BeforeUpdate failed: %w", ErrInvalid, err)
+			}
+		}
+	} else if hook, ok := object.(BeforeCreator); ok {
+		if err := hook.BeforeCreate(); err != nil {
+			return util.Errorf("%w: BeforeCreate failed: %w", ErrInvalid, err)
+		}
+	}
+	return nil
+}
+
+// runValidateHook invokes Validate on object if implemented
+func runValidateHook(object any) error {
+	if hook, ok := object.(Validator); ok {
+		if err := hook.Validate(); err != nil {
+			return util.Errorf("%w: validation failed: %w", ErrInvalid, err)
+		}
+	}
+	return nil
+}
+
+// runAfterLoadHook invokes AfterLoad on object if implemented
+func runAfterLoadHook(object any) error {
+	if hook, ok := object.(AfterLoader); ok {
+		if err := hook.AfterLoad(); err != nil {
+			return util.Errorf("AfterLoad failed: %w", err)
+		}
+	}
+	return nil
+}
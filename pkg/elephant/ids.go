@@ -0,0 +1,172 @@
+package elephant
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/google/uuid"
+)
+
+// IDStrategy decides how ids are generated for objects created without id
+type IDStrategy int
+
+const (
+	// IDUUIDv4 generates random UUIDs. This is the default strategy
+	IDUUIDv4 IDStrategy = iota
+	// IDUUIDv7 generates UUIDs which sort by creation time
+	IDUUIDv7
+	// IDULID generates ULIDs, which sort by creation time
+	IDULID
+	// IDSequence generates increasing integers, persisted in the sequences table.
+	// They are padded with zeros to sequenceIDLength digits, so they sort as numbers
+	IDSequence
+	// idDerived marks the generators of SetIDFunc, which only exist in the process configuring them
+	idDerived IDStrategy = -1
+)
+
+// Name for the table to store the last value of each sequence. It starts with
+// a digit, so it cannot be the name of a Go type
+const sequencesTableName = "0sequences"
+
+// sequenceIDLength is the number of digits of the largest uint64
+const sequenceIDLength = 20
+
+// idGenerator returns a candidate id for object, which can be nil when
+// no object is available (i.e. NewID)
+type idGenerator struct {
+	generate func(inputType reflect.Type, object any) (string, error)
+	// unique is true when the generator never repeats ids, so collisions are errors instead of retries
+	unique bool
+	// strategy is sent to remote stores, which build their own generator
+	strategy IDStrategy
+}
+
+var /*const*/ defaultIDGenerator = &idGenerator{generate: func(reflect.Type, any) (string, error) {
+	return uuid.New().String(), nil
+}, strategy: IDUUIDv4}
+
+// SetIDStrategy configures how ids are generated for inputType
+func SetIDStrategy[inputType any](strategy IDStrategy) error {
+	return SetIDStrategyContext[inputType](context.Background(), strategy)
+}
+
+// SetIDStrategyContext is like SetIDStrategy, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func SetIDStrategyContext[inputType any](ctx context.Context, strategy IDStrategy) error {
+	checkInitialization()
+	generator, err := newIDGenerator(strategy)
+	if err != nil {
+		return err
+	}
+	action := newInternalAction(actionSetIDGenerator, reflect.TypeFor[*inputType](), generator)
+	action.ctx = ctx
+	return (send(action)).err
+}
+
+// newIDGenerator returns the generator of a strategy
+func newIDGenerator(strategy IDStrategy) (*idGenerator, error) {
+	switch strategy {
+	case IDUUIDv4:
+		return defaultIDGenerator, nil
+	case IDUUIDv7:
+		return &idGenerator{generate: func(reflect.Type, any) (string, error) {
+			id, err := uuid.NewV7()
+			if err != nil {
+				return "", util.Errorf("cannot generate UUIDv7: %v", err)
+			}
+			return id.String(), nil
+		}, strategy: strategy}, nil
+	case IDULID:
+		return &idGenerator{generate: func(reflect.Type, any) (string, error) {
+			return util.NewULID(time.Now()), nil
+		}, strategy: strategy}, nil
+	case IDSequence:
+		return &idGenerator{generate: execNextSequenceValue, unique: true, strategy: strategy}, nil
+	default:
+		return nil, util.Errorf("unknown id strategy: %d", strategy)
+	}
+}
+
+// SetIDFunc configures a function to derive the id of inputType objects from their contents (natural keys).
+// Creating an object whose derived id is already in use fails
+func SetIDFunc[inputType any](generate func(*inputType) string) error {
+	return SetIDFuncContext[inputType](context.Background(), generate)
+}
+
+// SetIDFuncContext is like SetIDFunc, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func SetIDFuncContext[inputType any](ctx context.Context, generate func(*inputType) string) error {
+	checkInitialization()
+	generator := &idGenerator{
+		generate: func(_ reflect.Type, object any) (string, error) {
+			typedObject, ok := object.(*inputType)
+			if !ok || typedObject == nil {
+				return "", util.Errorf("cannot derive an id without an object")
+			}
+			id := generate(typedObject)
+			if id == "" {
+				return "", util.Errorf("derived id is empty")
+			}
+			return id, nil
+		},
+		unique:   true,
+		strategy: idDerived,
+	}
+	action := newInternalAction(actionSetIDGenerator, reflect.TypeFor[*inputType](), generator)
+	action.ctx = ctx
+	return (send(action)).err
+}
+
+// execNextSequenceValue increments and persists the sequence of inputType.
+// Values whose ids are already in use are skipped before persisting, so
+// collisions do not consume more than one write
+func execNextSequenceValue(inputType reflect.Type, _ any) (string, error) {
+	tableName := getTableName(inputType)
+	value, exists := sequences[tableName]
+	if !exists {
+		var stored string
+		err := measure("Retrieve", sequencesTableName, func() (err error) {
+			stored, err = dbDriver.Retrieve(sequencesTableName, tableName)
+			return
+		})
+		if err != nil {
+			return "", util.Errorf("cannot read sequence for %s: %v", tableName, err)
+		}
+		if stored != "" {
+			value, err = strconv.ParseUint(stored, 10, 64)
+			if err != nil {
+				return "", util.Errorf("invalid sequence value for %s: %v", tableName, err)
+			}
+		}
+	}
+	next := value + 1
+	for execExists(inputType, formatSequenceID(next)) {
+		next++
+	}
+	var err error
+	if !exists && value == 0 {
+		err = measure("Create", sequencesTableName, func() error {
+			return dbDriver.Create(sequencesTableName, tableName, strconv.FormatUint(next, 10))
+		})
+	} else {
+		err = measure("Update", sequencesTableName, func() error {
+			return dbDriver.Update(sequencesTableName, tableName, strconv.FormatUint(next, 10))
+		})
+	}
+	if err != nil {
+		return "", util.Errorf("cannot store sequence for %s: %v", tableName, err)
+	}
+	sequences[tableName] = next
+	return formatSequenceID(next), nil
+}
+
+// formatSequenceID pads the value with zeros, so ids sort like the values
+func formatSequenceID(value uint64) string {
+	return fmt.Sprintf("%0*d", sequenceIDLength, value)
+}
+
+func execSetIDGenerator(inputType reflect.Type, generator *idGenerator) {
+	idGenerators[inputType] = generator
+}
@@ -0,0 +1,52 @@
+package elephant
+
+import (
+	"reflect"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// execCreateIndexes asks the driver to index the fields tagged with `db:"index"`,
+// if it supports indexes
+func execCreateIndexes(inputType reflect.Type) error {
+	indexer, isIndexer := dbDriver.(db.Indexer)
+	if !isIndexer {
+		return nil
+	}
+	for field, attribute := range learntTypes[inputType].Indexes {
+		err := measure("CreateIndex", getTableName(inputType), func() error {
+			return indexer.CreateIndex(getTableName(inputType), attribute)
+		})
+		if err != nil {
+			return util.Errorf("cannot index %s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// execRetrieveIndexed finds an object by an indexed field using the driver.
+// found is false when the driver cannot answer and the cache must be scanned
+func execRetrieveIndexed(inputType reflect.Type, attribute string, object any) (output any, found bool) {
+	indexer, isIndexer := dbDriver.(db.Indexer)
+	jsonName, indexed := learntTypes[inputType].Indexes[attribute]
+	if !isIndexer || !indexed {
+		return nil, false
+	}
+	var ids []string
+	err := measure("RetrieveIDsBy", getTableName(inputType), func() (err error) {
+		ids, err = indexer.RetrieveIDsBy(getTableName(inputType), jsonName, util.IndexValue(object))
+		return
+	})
+	if err != nil {
+		logger.Warn("indexed retrieval failed, scanning the cache", "type", learntTypes[inputType].Name, "error", err)
+		return nil, false
+	}
+	for _, id := range ids {
+		if elem, exists := data[inputType][id]; exists &&
+			object == reflect.ValueOf(elem).Elem().FieldByName(attribute).Interface() {
+			return elem, true
+		}
+	}
+	return nil, true
+}
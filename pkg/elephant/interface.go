@@ -1,174 +1,330 @@
 package elephant
 
 import (
+	"context"
 	"reflect"
 	"strings"
 
-	"github.com/gonimals/elephant/internal/db/sql"
 	"github.com/gonimals/elephant/internal/util"
 )
 
 // Initialize requires a supported uri using one of the following supported formats:
 // - sqlite3:path/to/file.db
 // - mysql:user:password@tcp(hostname:port)/database
+// - kv:path/to/file.kv
+// - memory:name (the name is optional)
+// - remote:http://hostname:port?token=T or remote:unix:/path/to/socket?token=T, using the store
+// served by another process with NewRemoteHandler (see elephant serve)
+// Other formats can be added with RegisterDriver
 func Initialize(uri string) (err error) {
-	switch {
-	case strings.HasPrefix(uri, "sqlite3:"):
-		dbDriver, err = sql.ConnectSqlite3(strings.TrimPrefix(uri, "sqlite3:"))
-	case strings.HasPrefix(uri, "mysql:"):
-		dbDriver, err = sql.ConnectMySQL(strings.TrimPrefix(uri, "mysql:"))
-	default:
-		err = util.Errorf("unsupported uri string: %s", uri)
+	if address, isRemote := strings.CutPrefix(uri, "remote:"); isRemote {
+		remote, err = connectRemote(address)
+		return
 	}
+	driver, err := OpenDriver(uri)
 	if err != nil {
-		dbDriver = nil
 		return
 	}
+	InitializeDriver(driver)
+	return nil
+}
+
+// InitializeDriver is like Initialize, using a driver which is already open.
+// The driver is closed by Close
+func InitializeDriver(driver Driver) {
+	dbDriver = driver
+	setDriverMetrics()
+	setDriverLogger()
 
 	data = make(map[reflect.Type](map[string]any))
 	learntTypes = make(map[reflect.Type]*util.LearntType)
 	channel = make(chan *internalAction)
 	managedTypes = make(map[reflect.Type]bool)
+	idGenerators = make(map[reflect.Type]*idGenerator)
+	sequences = make(map[string]uint64)
+	searchIndexes = make(map[reflect.Type]*searchIndex)
 	learntTypes[blobReflectType] = &util.LearntType{
 		Name: "blob",
 	}
 	managedTypes[blobReflectType] = true
 	waitgroup.Add(1)
 	go mainRoutine()
-	return nil
 }
 
 // Retrieve gets one element from a specific type filtering by id. Returns the element if found and nil if not
 func Retrieve[inputType any](id string) (*inputType, error) {
+	return RetrieveContext[inputType](context.Background(), id)
+}
+
+// RetrieveContext is like Retrieve, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func RetrieveContext[inputType any](ctx context.Context, id string) (*inputType, error) {
 	checkInitialization()
 	action := newInternalAction(actionRetrieve, reflect.TypeFor[*inputType](), id)
-	channel <- action
-	return handleOutputType[*inputType](<-action.output, true)
+	action.ctx = ctx
+	return handleOutputType[*inputType](send(action), true)
 }
 
 // RetrieveBy gets one element from a specific type filtering by other attribute. Returns the element if found and nil if parameters are incorrect or the element is not found
 func RetrieveBy[inputType any](attribute string, input any) (*inputType, error) {
+	return RetrieveByContext[inputType](context.Background(), attribute, input)
+}
+
+// RetrieveByContext is like RetrieveBy, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func RetrieveByContext[inputType any](ctx context.Context, attribute string, input any) (*inputType, error) {
 	checkInitialization()
 	action := newInternalAction(actionRetrieveBy, reflect.TypeFor[*inputType](), attribute, input)
-	channel <- action
-	return handleOutputType[*inputType](<-action.output, true)
+	action.ctx = ctx
+	return handleOutputType[*inputType](send(action), true)
 }
 
 // RetrieveAll gets all elements with a specific type. Returns a map with all elements. It will be empty if there are no elements
 func RetrieveAll[inputType any]() (map[string]*inputType, error) {
+	return RetrieveAllContext[inputType](context.Background())
+}
+
+// RetrieveAllContext is like RetrieveAll, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func RetrieveAllContext[inputType any](ctx context.Context) (map[string]*inputType, error) {
 	checkInitialization()
 	action := newInternalAction(actionRetrieveAll, reflect.TypeFor[*inputType](), nil)
-	channel <- action
-	return handleOutputMap[map[string]*inputType](<-action.output)
+	action.ctx = ctx
+	return handleOutputMap[map[string]*inputType](send(action))
 }
 
 // Remove deletes one element from the database. Returns err if the object does not exist
 func Remove(input any) error {
+	return RemoveContext(context.Background(), input)
+}
+
+// RemoveContext is like Remove, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func RemoveContext(ctx context.Context, input any) error {
 	checkInitialization()
 	action := newInternalAction(actionRemove, reflect.TypeOf(input), input)
-	channel <- action
-	return (<-action.output).err
+	action.ctx = ctx
+	return (send(action)).err
 }
 
 // RemoveById deletes one element from the database. Returns err if the object does not exist
 func RemoveById[inputType any](id string) error {
+	return RemoveByIdContext[inputType](context.Background(), id)
+}
+
+// RemoveByIdContext is like RemoveById, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func RemoveByIdContext[inputType any](ctx context.Context, id string) error {
 	checkInitialization()
 	action := newInternalAction(actionRemoveById, reflect.TypeFor[*inputType](), id)
-	channel <- action
-	return (<-action.output).err
+	action.ctx = ctx
+	return (send(action)).err
 }
 
 // Update modifies an element on the database
 func Update(input any) error {
+	return UpdateContext(context.Background(), input)
+}
+
+// UpdateContext is like Update, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func UpdateContext(ctx context.Context, input any) error {
 	checkInitialization()
 	action := newInternalAction(actionUpdate, reflect.TypeOf(input), input)
-	channel <- action
-	return (<-action.output).err
+	action.ctx = ctx
+	return (send(action)).err
+}
+
+// Modify updates the object with the provided id by calling modify with a copy of the stored one.
+// Reading and writing happen in one action, so concurrent calls cannot lose each other's changes.
+// modify runs inside the action loop and must not call elephant. Returns the updated object or
+// ErrNotFound if it does not exist. With a remote: uri, modify runs in the caller and is called
+// again if the object changes before writing it
+func Modify[inputType any](id string, modify func(*inputType) error) (*inputType, error) {
+	return ModifyContext(context.Background(), id, modify)
+}
+
+// ModifyContext is like Modify, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func ModifyContext[inputType any](ctx context.Context, id string, modify func(*inputType) error) (*inputType, error) {
+	checkInitialization()
+	action := newInternalAction(actionModify, reflect.TypeFor[*inputType](), id, func(object any) error {
+		return modify(object.(*inputType))
+	})
+	action.ctx = ctx
+	return handleOutputType[*inputType](send(action), false)
 }
 
 // Create adds one element to the database. If the id attribute value is empty (""), a new one will be assigned
 func Create(input any) (string, error) {
+	return CreateContext(context.Background(), input)
+}
+
+// CreateContext is like Create, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func CreateContext(ctx context.Context, input any) (string, error) {
 	checkInitialization()
 	action := newInternalAction(actionCreate, reflect.TypeOf(input), input)
-	channel <- action
-	return handleOutputType[string](<-action.output, false)
+	action.ctx = ctx
+	return handleOutputType[string](send(action), false)
 }
 
 // Exists checks if one id is in use in the database
 func Exists[inputType any](id string) (bool, error) {
+	return ExistsContext[inputType](context.Background(), id)
+}
+
+// ExistsContext is like Exists, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func ExistsContext[inputType any](ctx context.Context, id string) (bool, error) {
 	checkInitialization()
 	action := newInternalAction(actionExists, reflect.TypeFor[*inputType](), id)
-	channel <- action
-	return handleOutputType[bool](<-action.output, false)
+	action.ctx = ctx
+	return handleOutputType[bool](send(action), false)
 }
 
 // ExistsBy gets one element from a specific type filtering by other attribute. Returns true if found and false if parameters are incorrect or the element is not found
 func ExistsBy[inputType any](attribute string, input any) (bool, error) {
+	return ExistsByContext[inputType](context.Background(), attribute, input)
+}
+
+// ExistsByContext is like ExistsBy, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func ExistsByContext[inputType any](ctx context.Context, attribute string, input any) (bool, error) {
 	checkInitialization()
 	action := newInternalAction(actionExistsBy, reflect.TypeFor[*inputType](), attribute, input)
-	channel <- action
-	return handleOutputType[bool](<-action.output, false)
+	action.ctx = ctx
+	return handleOutputType[bool](send(action), false)
 }
 
 // NewID gives an empty id to create a new entry
 //
 // Deprecated: It is better to leave the object without ID so elephant can assign the ID in that moment
 func NewID[inputType any]() (string, error) {
+	return NewIDContext[inputType](context.Background())
+}
+
+// NewIDContext is like NewID, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+//
+// Deprecated: It is better to leave the object without ID so elephant can assign the ID in that moment
+func NewIDContext[inputType any](ctx context.Context) (string, error) {
 	checkInitialization()
 	action := newInternalAction(actionNextID, reflect.TypeFor[*inputType]())
-	channel <- action
-	return handleOutputType[string](<-action.output, false)
+	action.ctx = ctx
+	return handleOutputType[string](send(action), false)
 }
 
 // Upsert updates or inserts the entry. Returns the id of the modified object or an error
 func Upsert(input any) (string, error) {
+	return UpsertContext(context.Background(), input)
+}
+
+// UpsertContext is like Upsert, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func UpsertContext(ctx context.Context, input any) (string, error) {
+	id, _, err := UpsertCreatedContext(ctx, input)
+	return id, err
+}
+
+// UpsertCreated is like Upsert, also reporting whether the object was created
+func UpsertCreated(input any) (id string, created bool, err error) {
+	return UpsertCreatedContext(context.Background(), input)
+}
+
+// UpsertCreatedContext is like UpsertCreated, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func UpsertCreatedContext(ctx context.Context, input any) (id string, created bool, err error) {
 	checkInitialization()
 	action := newInternalAction(actionUpsert, reflect.TypeOf(input), input)
-	channel <- action
-	return handleOutputType[string](<-action.output, false)
+	action.ctx = ctx
+	output, err := handleOutputType[upsertOutput](send(action), false)
+	return output.id, output.created, err
 }
 
-// BlobRetrieve returns blob contents if found. If not, returns nil
+// BlobRetrieve returns blob contents if found. If not, returns nil without error.
+// Driver errors are returned, after the driver retried the transient ones
 func BlobRetrieve(id string) (*[]byte, error) {
+	return BlobRetrieveContext(context.Background(), id)
+}
+
+// BlobRetrieveContext is like BlobRetrieve, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func BlobRetrieveContext(ctx context.Context, id string) (*[]byte, error) {
 	checkInitialization()
 	action := newInternalAction(actionBlobRetrieve, blobReflectType, id)
-	channel <- action
-	return handleOutputType[*[]byte](<-action.output, false)
+	action.ctx = ctx
+	return handleOutputType[*[]byte](send(action), false)
 }
 
 // BlobCreate adds one byte blob to the database
 func BlobCreate(id string, contents *[]byte) error {
+	return BlobCreateContext(context.Background(), id, contents)
+}
+
+// BlobCreateContext is like BlobCreate, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func BlobCreateContext(ctx context.Context, id string, contents *[]byte) error {
 	checkInitialization()
 	action := newInternalAction(actionBlobCreate, blobReflectType, id, contents)
-	channel <- action
-	return (<-action.output).err
+	action.ctx = ctx
+	return (send(action)).err
 }
 
 // BlobRemove removes one byte blob from the database
 func BlobRemove(id string) error {
+	return BlobRemoveContext(context.Background(), id)
+}
+
+// BlobRemoveContext is like BlobRemove, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func BlobRemoveContext(ctx context.Context, id string) error {
 	checkInitialization()
 	action := newInternalAction(actionBlobRemove, blobReflectType, id)
-	channel <- action
-	return (<-action.output).err
+	action.ctx = ctx
+	return (send(action)).err
 }
 
 func BlobUpdate(id string, contents *[]byte) error {
+	return BlobUpdateContext(context.Background(), id, contents)
+}
+
+// BlobUpdateContext is like BlobUpdate, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func BlobUpdateContext(ctx context.Context, id string, contents *[]byte) error {
 	checkInitialization()
 	action := newInternalAction(actionBlobUpdate, blobReflectType, id, contents)
-	channel <- action
-	return (<-action.output).err
+	action.ctx = ctx
+	return (send(action)).err
+}
+
+// BlobUpsert updates or inserts one byte blob
+func BlobUpsert(id string, contents *[]byte) error {
+	return BlobUpsertContext(context.Background(), id, contents)
+}
+
+// BlobUpsertContext is like BlobUpsert, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func BlobUpsertContext(ctx context.Context, id string, contents *[]byte) error {
+	_, err := BlobUpsertCreatedContext(ctx, id, contents)
+	return err
+}
+
+// BlobUpsertCreated is like BlobUpsert, also reporting whether the blob was created
+func BlobUpsertCreated(id string, contents *[]byte) (created bool, err error) {
+	return BlobUpsertCreatedContext(context.Background(), id, contents)
+}
+
+// BlobUpsertCreatedContext is like BlobUpsertCreated, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func BlobUpsertCreatedContext(ctx context.Context, id string, contents *[]byte) (created bool, err error) {
+	checkInitialization()
+	action := newInternalAction(actionBlobUpsert, blobReflectType, id, contents)
+	action.ctx = ctx
+	return handleOutputType[bool](send(action), false)
 }
 
 // BlobExists checks if one id is in use in the blobs table
 func BlobExists(id string) (bool, error) {
+	return BlobExistsContext(context.Background(), id)
+}
+
+// BlobExistsContext is like BlobExists, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func BlobExistsContext(ctx context.Context, id string) (bool, error) {
 	checkInitialization()
 	action := newInternalAction(actionBlobExists, blobReflectType, id)
-	channel <- action
-	return handleOutputType[bool](<-action.output, false)
+	action.ctx = ctx
+	return handleOutputType[bool](send(action), false)
 }
 
 // Close should be called as a deferred method after Initialize
 func Close() {
+	if remote != nil {
+		remote.close()
+		remote = nil
+		return
+	}
 	channel <- nil
 	close(channel)
 	waitgroup.Wait()
@@ -1,13 +1,26 @@
 package elephant
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/http/httptest"
 	"os"
 	"reflect"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gonimals/elephant/internal/db/memory"
 	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/metrics"
 )
 
 func TestIncorrectUri(t *testing.T) {
@@ -17,6 +30,446 @@ func TestIncorrectUri(t *testing.T) {
 	}
 }
 
+func TestRegisterDriver(t *testing.T) {
+	RegisterDriver("registered", func(dsn string) (Driver, error) {
+		return OpenDriver("memory:" + dsn)
+	})
+	if !slices.Contains(Drivers(), "registered") {
+		t.Error("Registered driver is not listed:", Drivers())
+	}
+	if err := Initialize("registered:"); err != nil {
+		t.Error("Initialization with a registered driver failed:", err)
+		return
+	}
+	if _, err := Create(&structCheck{Mystring: "1"}); err != nil {
+		t.Error("Creation failed:", err)
+	}
+	Close()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Registering a driver twice should panic")
+		}
+	}()
+	RegisterDriver("registered", func(dsn string) (Driver, error) {
+		return nil, nil
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	sink := metrics.NewPrometheus()
+	SetMetrics(sink)
+	defer SetMetrics(nil)
+	os.Remove(sqlite3TestDB)
+	if err := Initialize("sqlite3:" + sqlite3TestDB); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	Create(&customerCheck{Id: "c1", Name: "customer"})
+	Create(&customerCheck{Id: "c2", Name: "customer"})
+	Retrieve[customerCheck]("c1")
+	Create(&customerCheck{Id: "c1", Name: "duplicated"})
+	Close()
+
+	output := new(strings.Builder)
+	sink.WriteTo(output)
+	for _, expected := range []string{
+		`elephant_actions_total{action="create"} 3`,
+		`elephant_actions_total{action="retrieve"} 1`,
+		`elephant_action_errors_total{action="create"} 1`,
+		`elephant_action_seconds_count{action="create"} 3`,
+		`elephant_queue_seconds_count{action="retrieve"} 1`,
+		`elephant_queue_depth 0`,
+		`elephant_cached_objects{type="customerCheck"} 2`,
+		`elephant_driver_seconds_count{operation="Create",table="customerCheck"} 2`,
+		`elephant_driver_seconds_count{operation="Scan",table="customerCheck"} 1`,
+		`elephant_sql_statement_seconds_count{operation="Create"} 2`,
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("metrics do not contain %q:\n%s", expected, output)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	output := new(bytes.Buffer)
+	SetLogger(slog.New(slog.NewJSONHandler(output, nil)))
+	SetSlowCallThreshold(0)
+	defer SetLogger(nil)
+	defer SetSlowCallThreshold(100 * time.Millisecond)
+
+	memory.Drop("logger")
+	defer memory.Drop("logger")
+	store, _ := memory.Connect("logger")
+	store.Create("customerCheck", "broken", "{")
+	if err := Initialize("memory:logger"); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	if err := Register[customerCheck](); err == nil {
+		t.Error("Registration with broken objects should fail")
+	}
+	store.Remove("customerCheck", "broken")
+	if err := Register[customerCheck](); err != nil {
+		t.Error("Registration should be retried after fixing the objects:", err)
+	}
+	store.Create("customerCheck", "unknown", "{}")
+	if _, err := Create(&customerCheck{Id: "unknown"}); err == nil {
+		t.Error("Creation of an id only known by the driver should fail")
+	}
+	Close()
+
+	os.Remove(sqlite3TestDB)
+	if err := Initialize("sqlite3:" + sqlite3TestDB); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	Register[customerCheck]()
+	Close()
+
+	messages := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		record := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal("Log records are not structured:", line)
+		}
+		messages[record["msg"].(string)] = record
+	}
+	for message, attribute := range map[string]string{
+		"cannot load object":   "id",
+		"creation rolled back": "id",
+		"slow driver call":     "operation",
+		"table created":        "table",
+		"type registered":      "objects",
+	} {
+		if messages[message] == nil || messages[message][attribute] == nil {
+			t.Errorf("Missing log record %q with %s: %v", message, attribute, messages[message])
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	os.Remove(sqlite3TestDB)
+	os.Remove(kvTestDB)
+	sqlite3URI, kvURI := "sqlite3:"+sqlite3TestDB, "kv:"+kvTestDB
+	if err := Initialize(sqlite3URI); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	Create(&customerCheck{Id: "c1", Name: "customer"})
+	Create(&customerCheck{Id: "c2", Name: "customer"})
+	Create(&orderCheck{Id: "o1", CustomerID: "c1"})
+	BlobCreate("b1", &[]byte{0x01})
+	Close()
+
+	stats, err := Copy(sqlite3URI, kvURI, CopyOptions{})
+	if err != nil || len(stats) != 4 {
+		t.Fatal("Copy failed:", stats, err)
+	}
+	if stats[0].Table != referencesTableName || stats[1].Table != "customerCheck" || stats[1].Copied != 2 || stats[1].Count != 2 ||
+		stats[3].Table != BlobsTable || stats[3].Copied != 1 {
+		t.Error("Copy reports wrong stats:", stats)
+	}
+
+	kv, err := OpenDriver(kvURI)
+	if err != nil {
+		t.Fatal("cannot open the destination:", err)
+	}
+	kv.Remove("customerCheck", "c2")
+	kv.Update("customerCheck", "c1", `{"Name":"changed","Id":"c1"}`)
+	kv.Create("customerCheck", "c3", `{"Id":"c3"}`)
+	kv.Close()
+	if stats, err = Copy(sqlite3URI, kvURI, CopyOptions{}); err == nil || len(stats) != 1 {
+		t.Error("Copy should fail the verification with extra elements in the destination:", stats)
+	}
+	stats, err = Copy(sqlite3URI, kvURI, CopyOptions{Tables: []string{"customerCheck"}, Prune: true})
+	if err != nil || len(stats) != 1 || stats[0].Copied != 0 || stats[0].Skipped != 2 || stats[0].Removed != 1 {
+		t.Error("Resumed copy reports wrong stats:", stats, err)
+	}
+	stats, err = Copy(kvURI, sqlite3URI, CopyOptions{})
+	if err != nil || stats[1].Copied != 0 || stats[1].Skipped != 2 {
+		t.Error("Copy of equal databases should skip every element:", stats, err)
+	}
+	if _, err := Copy(kvURI, sqlite3URI, CopyOptions{Tables: []string{"unknown"}}); err == nil {
+		t.Error("Copy of unknown tables should fail")
+	}
+	if _, err := Copy(kvURI, kvURI, CopyOptions{}); err == nil {
+		t.Error("Copy to the same database should fail")
+	}
+
+	if err := Initialize(kvURI); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	defer Close()
+	if customer, _ := Retrieve[customerCheck]("c1"); customer == nil || customer.Name != "customer" {
+		t.Error("Copied objects are not readable:", customer)
+	}
+	if blob, err := BlobRetrieve("b1"); err != nil || !util.BlobsEqual(blob, &[]byte{0x01}) {
+		t.Error("Copied blobs are not readable:", err)
+	}
+}
+
+// dashedDriver stores in a memory driver but uses "-" as context symbol, like MySQL
+type dashedDriver struct {
+	db.Driver
+}
+
+// dotted gives the name of the table in the memory driver, or an invalid one if
+// the table would be rejected by a driver using "-"
+func (d dashedDriver) dotted(table string) string {
+	if !db.IsValidTableName(table, "-") {
+		return "invalid " + table
+	}
+	return strings.ReplaceAll(table, "-", ".")
+}
+
+func (d dashedDriver) GetContextSymbol() string { return "-" }
+func (d dashedDriver) Retrieve(table string, id string) (string, error) {
+	return d.Driver.Retrieve(d.dotted(table), id)
+}
+func (d dashedDriver) RetrieveAll(table string) (map[string]string, error) {
+	return d.Driver.RetrieveAll(d.dotted(table))
+}
+func (d dashedDriver) Create(table string, id string, value string) error {
+	return d.Driver.Create(d.dotted(table), id, value)
+}
+func (d dashedDriver) Update(table string, id string, value string) error {
+	return d.Driver.Update(d.dotted(table), id, value)
+}
+func (d dashedDriver) Remove(table string, id string) error {
+	return d.Driver.Remove(d.dotted(table), id)
+}
+func (d dashedDriver) BlobIDs() ([]string, error) { return d.Driver.(db.Lister).BlobIDs() }
+func (d dashedDriver) Tables() (output []string, err error) {
+	output, err = d.Driver.(db.Lister).Tables()
+	for i := range output {
+		output[i] = strings.ReplaceAll(output[i], ".", "-")
+	}
+	return
+}
+
+func init() {
+	RegisterDriver("dashed", func(dsn string) (Driver, error) {
+		driver, err := OpenDriver("memory:" + dsn)
+		return dashedDriver{driver}, err
+	})
+}
+
+func TestCopyContextSymbol(t *testing.T) {
+	os.Remove(kvTestDB)
+	kvURI := "kv:" + kvTestDB
+	kv, err := OpenDriver(kvURI)
+	if err != nil {
+		t.Fatal("cannot open the source:", err)
+	}
+	kv.Create("tenant.customerCheck", "c1", `{"Id":"c1"}`)
+	kv.Close()
+
+	if _, err := Copy(kvURI, "dashed:copy", CopyOptions{}); err != nil {
+		t.Fatal("Copy failed:", err)
+	}
+	dashed, _ := OpenDriver("dashed:copy")
+	if tables, _ := dashed.(db.Lister).Tables(); !slices.Equal(tables, []string{"tenant-customerCheck"}) {
+		t.Error("tables should use the context symbol of the destination:", tables)
+	}
+	if value, _ := dashed.Retrieve("tenant-customerCheck", "c1"); value == "" {
+		t.Error("copied element not found")
+	}
+	if stats, err := Copy("dashed:copy", kvURI, CopyOptions{}); err != nil || len(stats) != 2 || stats[0].Skipped != 1 {
+		t.Error("Copy back should find every element in place:", stats, err)
+	}
+}
+
+func TestBackupContextSymbol(t *testing.T) {
+	memory.Drop("backupsource")
+	defer memory.Drop("backupsource")
+	source, _ := OpenDriver("memory:backupsource")
+	source.Create("tenant.customerCheck", "c1", `{"Id":"c1"}`)
+	source.Close()
+	if err := Initialize("memory:backupsource"); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	backup := new(bytes.Buffer)
+	if err := Backup(backup); err != nil {
+		t.Error("Backup failed:", err)
+	}
+	Close()
+
+	memory.Drop("backupdestination")
+	defer memory.Drop("backupdestination")
+	if err := Initialize("dashed:backupdestination"); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	defer Close()
+	if err := Restore(bytes.NewReader(backup.Bytes()), RestoreMerge); err != nil {
+		t.Error("Restoring in a driver with another context symbol failed:", err)
+	}
+	destination, _ := OpenDriver("dashed:backupdestination")
+	if value, _ := destination.Retrieve("tenant-customerCheck", "c1"); value == "" {
+		t.Error("Restored tables should use the context symbol of the destination")
+	}
+	crafted := `{"format":"elephant-backup","version":2,"contextSymbol":"."}
+{"table":"blobs","id":"b1","value":"{}"}
+{"end":true,"objects":1}`
+	if err := Restore(strings.NewReader(crafted), RestoreMerge); err == nil {
+		t.Error("Backups writing in the blobs table should be rejected")
+	}
+}
+
+// serveRemote serves a memory store with NewRemoteHandler, returning the uri of the
+// remote store. Both are closed when the test ends
+func serveRemote(t *testing.T, token string) string {
+	store, _ := memory.Connect("")
+	InitializeDriver(store)
+	server := httptest.NewServer(NewRemoteHandler(token))
+	t.Cleanup(func() {
+		server.Close()
+		Close()
+	})
+	return "remote:" + server.URL + "?token=" + token
+}
+
+func TestRemote(t *testing.T) {
+	uri := serveRemote(t, "secret")
+	if err := Initialize(strings.Replace(uri, "secret", "wrong", 1)); err == nil {
+		Close()
+		t.Fatal("Connections with a wrong token should be rejected")
+	}
+	if err := Initialize(uri); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	defer Close()
+	other, err := connectRemote(strings.TrimPrefix(uri, "remote:"))
+	if err != nil {
+		t.Fatal("cannot connect another client:", err)
+	}
+	defer other.close()
+	schema, _ := describeType(reflect.TypeFor[*hookedStructCheck]())
+
+	element := &hookedStructCheck{Mystring: "a", Myint: 1}
+	Create(element)
+	if err := Update(element); err != nil || element.Updates != 1 {
+		t.Error("The hooks of the served store should run and change the object:", element, err)
+	}
+	if err := Update(&hookedStructCheck{Mystring: "a", Myint: -1}); !errors.Is(err, ErrInvalid) {
+		t.Error("The served store should validate the writes:", err)
+	}
+	if _, err := Create(&invoiceCheck{Id: "i1", CustomerID: "c1"}); !errors.Is(err, ErrInvalid) {
+		t.Error("The served store should check the references:", err)
+	}
+	if cached := data[reflect.TypeFor[*hookedStructCheck]()]["a"]; cached == nil || cached.(*hookedStructCheck).Updates != 1 {
+		t.Error("Written objects should be kept in the cache of the served store:", cached)
+	}
+
+	response, err := other.call(context.Background(), remoteRequest{Action: "retrieve", Type: "hookedStructCheck",
+		Schema: schema, Id: "a"}, false)
+	if err != nil || !strings.Contains(string(response.Object), `"Updates":1`) {
+		t.Error("Other clients should read the writes from the shared cache:", string(response.Object), err)
+	}
+	_, err = other.call(context.Background(), remoteRequest{Action: "update", Type: "hookedStructCheck", Schema: schema,
+		Object: json.RawMessage(`{"Mystring":"a","Myint":5}`)}, false)
+	if err != nil {
+		t.Error("Update of other client failed:", err)
+	}
+	if element, _ := Retrieve[hookedStructCheck]("a"); element == nil || element.Myint != 5 || element.Updates != 2 {
+		t.Error("Writes of other clients should be visible at once:", element)
+	}
+	if _, err := other.call(context.Background(), remoteRequest{Action: "retrieve", Type: "hookedStructCheck",
+		Schema: &remoteSchema{Fields: schema.Fields}, Id: "a"}, false); !errors.Is(err, ErrInvalid) {
+		t.Error("Types described differently than in the served program should be rejected:", err)
+	}
+}
+
+func TestRemoteTypes(t *testing.T) {
+	uri := serveRemote(t, "")
+	client, err := connectRemote(strings.TrimPrefix(uri, "remote:"))
+	if err != nil {
+		t.Fatal("cannot connect:", err)
+	}
+	defer client.close()
+	call := func(request remoteRequest) (remoteResponse, error) {
+		return client.call(context.Background(), request, false)
+	}
+	gadget := &remoteSchema{Fields: []remoteField{{Name: "Id", Kind: "string", Tag: `db:"id"`},
+		{Name: "Name", Kind: "string", Tag: `db:"index"`}, {Name: "Tags", Kind: "json"}}}
+	part := &remoteSchema{Fields: []remoteField{{Name: "Id", Kind: "string", Tag: `db:"id"`},
+		{Name: "GadgetID", Kind: "string", Tag: `db:"ref=gadgetCheck"`}}}
+
+	if _, err := call(remoteRequest{Action: "create", Type: "gadgetCheck", Schema: gadget,
+		Object: json.RawMessage(`{"Id":"g1","Name":"lamp","Tags":["a","b"]}`)}); err != nil {
+		t.Fatal("Types unknown by the served program should be built:", err)
+	}
+	if response, err := call(remoteRequest{Action: "retrieve", Type: "gadgetCheck", Schema: gadget, Id: "g1"}); err != nil ||
+		string(response.Object) != `{"Id":"g1","Name":"lamp","Tags":["a","b"]}` {
+		t.Error("Objects of built types should be kept as sent:", string(response.Object), err)
+	}
+	response, err := call(remoteRequest{Action: "filter", Type: "gadgetCheck", Schema: gadget,
+		Conditions: []remoteCondition{{Attribute: "Name", Operator: db.Equal, Value: json.RawMessage(`"lamp"`)}}})
+	if err != nil || !slices.Equal(response.Ids, []string{"g1"}) {
+		t.Error("Built types should be filtered by their fields:", response.Ids, err)
+	}
+	if _, err := call(remoteRequest{Action: "create", Type: "partCheck", Schema: part,
+		Object: json.RawMessage(`{"Id":"p1","GadgetID":"g2"}`)}); !errors.Is(err, ErrInvalid) {
+		t.Error("References of built types should be checked:", err)
+	}
+	call(remoteRequest{Action: "create", Type: "partCheck", Schema: part, Object: json.RawMessage(`{"Id":"p1","GadgetID":"g1"}`)})
+	if _, err := call(remoteRequest{Action: "remove_by_id", Type: "gadgetCheck", Schema: gadget, Id: "g1"}); !errors.Is(err, ErrConflict) {
+		t.Error("Referenced objects of built types should not be removed:", err)
+	}
+
+	if _, err := call(remoteRequest{Action: "retrieve", Type: "gadgetCheck", Schema: part, Id: "g1"}); !errors.Is(err, ErrInvalid) {
+		t.Error("Types described differently by other clients should be rejected:", err)
+	}
+	hooked := &remoteSchema{Fields: gadget.Fields, Hooks: []string{"Validate"}}
+	if _, err := call(remoteRequest{Action: "retrieve", Type: "hookedGadgetCheck", Schema: hooked, Id: "g1"}); !errors.Is(err, ErrInvalid) {
+		t.Error("Types with hooks unknown by the served program should be rejected:", err)
+	}
+	invalid := &remoteSchema{Fields: []remoteField{{Name: "Id", Kind: "string", Tag: `db:"id"`}, {Name: "lower", Kind: "string"}}}
+	if _, err := call(remoteRequest{Action: "retrieve", Type: "invalidCheck", Schema: invalid, Id: "i1"}); !errors.Is(err, ErrInvalid) {
+		t.Error("Types with unexported fields should be rejected:", err)
+	}
+	if _, err := call(remoteRequest{Action: "retrieve", Type: "invalid-name", Schema: gadget, Id: "i1"}); !errors.Is(err, ErrInvalid) {
+		t.Error("Types with invalid names should be rejected:", err)
+	}
+}
+
+func TestResolveConcurrently(t *testing.T) {
+	memory.Drop("resolve")
+	defer memory.Drop("resolve")
+	if err := Initialize("memory:resolve"); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	Register[orderCheck]()
+	Close()
+
+	if err := Initialize("memory:resolve"); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	defer Close()
+	Create(&customerCheck{Id: "c1"})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// invoiceCheck is examined for the first time while the removal looks for known types
+		if _, err := Resolve[customerCheck](&invoiceCheck{Id: "i1", CustomerID: "c1"}, "CustomerID"); err != nil {
+			t.Error("Resolve failed:", err)
+		}
+	}()
+	if err := RemoveById[customerCheck]("c1"); err != nil {
+		t.Error("Removal failed:", err)
+	}
+	<-done
+}
+
+func TestInterfaceRemote(t *testing.T) {
+	// testHooks is left out since it writes through the driver
+	tests := map[string]func(string, *testing.T){"reuseDB": testReuseDB, "correctFunctions": testCorrectFunctions,
+		"update": testUpdate, "upsert": testUpsert, "correctBlobs": testCorrectBlobs, "timestamps": testTimestamps,
+		"idStrategies": testIDStrategies, "references": testReferences, "indexes": testIndexes, "filter": testFilter,
+		"all": testAll, "page": testPage, "aggregates": testAggregates, "search": testSearch, "backup": testBackup}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test(serveRemote(t, "token"), t)
+		})
+	}
+}
+
 func TestInterfaceSqlite3(t *testing.T) {
 	uri := "sqlite3:" + sqlite3TestDB
 
@@ -34,6 +487,143 @@ func TestInterfaceSqlite3(t *testing.T) {
 
 	os.Remove(sqlite3TestDB)
 	testCorrectBlobs(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testHooks(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testTimestamps(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testIDStrategies(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testReferences(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testIndexes(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testFilter(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testAll(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testPage(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testAggregates(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testSearch(uri, t)
+
+	os.Remove(sqlite3TestDB)
+	testBackup(uri, t)
+}
+
+func TestInterfaceKV(t *testing.T) {
+	uri := "kv:" + kvTestDB
+
+	os.Remove(kvTestDB)
+	testReuseDB(uri, t)
+
+	os.Remove(kvTestDB)
+	testCorrectFunctions(uri, t)
+
+	os.Remove(kvTestDB)
+	testUpdate(uri, t)
+
+	os.Remove(kvTestDB)
+	testUpsert(uri, t)
+
+	os.Remove(kvTestDB)
+	testCorrectBlobs(uri, t)
+
+	os.Remove(kvTestDB)
+	testHooks(uri, t)
+
+	os.Remove(kvTestDB)
+	testTimestamps(uri, t)
+
+	os.Remove(kvTestDB)
+	testIDStrategies(uri, t)
+
+	os.Remove(kvTestDB)
+	testReferences(uri, t)
+
+	os.Remove(kvTestDB)
+	testIndexes(uri, t)
+
+	os.Remove(kvTestDB)
+	testFilter(uri, t)
+
+	os.Remove(kvTestDB)
+	testAll(uri, t)
+
+	os.Remove(kvTestDB)
+	testPage(uri, t)
+
+	os.Remove(kvTestDB)
+	testAggregates(uri, t)
+
+	os.Remove(kvTestDB)
+	testSearch(uri, t)
+
+	os.Remove(kvTestDB)
+	testBackup(uri, t)
+}
+
+func TestInterfaceMemory(t *testing.T) {
+	uri := "memory:" + memoryTestDB
+
+	memory.Drop(memoryTestDB)
+	testReuseDB(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testCorrectFunctions(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testUpdate(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testUpsert(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testCorrectBlobs(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testHooks(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testTimestamps(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testIDStrategies(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testReferences(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testIndexes(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testFilter(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testAll(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testPage(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testAggregates(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testSearch(uri, t)
+
+	memory.Drop(memoryTestDB)
+	testBackup(uri, t)
 }
 
 func TestDriverMySQL(t *testing.T) {
@@ -53,6 +643,39 @@ func TestDriverMySQL(t *testing.T) {
 
 	cleanMysqlTestDB()
 	testCorrectBlobs(uri, t)
+
+	cleanMysqlTestDB()
+	testHooks(uri, t)
+
+	cleanMysqlTestDB()
+	testTimestamps(uri, t)
+
+	cleanMysqlTestDB()
+	testIDStrategies(uri, t)
+
+	cleanMysqlTestDB()
+	testReferences(uri, t)
+
+	cleanMysqlTestDB()
+	testIndexes(uri, t)
+
+	cleanMysqlTestDB()
+	testFilter(uri, t)
+
+	cleanMysqlTestDB()
+	testAll(uri, t)
+
+	cleanMysqlTestDB()
+	testPage(uri, t)
+
+	cleanMysqlTestDB()
+	testAggregates(uri, t)
+
+	cleanMysqlTestDB()
+	testSearch(uri, t)
+
+	cleanMysqlTestDB()
+	testBackup(uri, t)
 }
 
 func testReuseDB(uri string, t *testing.T) {
@@ -211,6 +834,15 @@ func testUpdate(uri string, t *testing.T) {
 	if err := Update(workingInstance); err == nil {
 		t.Error("Instance should be too long to be stored in the database")
 	}
+	if _, err := Create(&customerCheck{Name: strings.Repeat("A", util.MaxStructLength)}); !errors.Is(err, ErrInvalid) {
+		t.Error("Creation of a too long object should be invalid:", err)
+	}
+	if _, err := Create(&structCheck{Mystring: "1"}); !errors.Is(err, ErrConflict) {
+		t.Error("Creation with an id in use should conflict:", err)
+	}
+	if err := Update(&structCheck{Mystring: "2"}); !errors.Is(err, ErrNotFound) {
+		t.Error("Update of an unexistent object should not find it:", err)
+	}
 	workingInstance, err = Retrieve[structCheck]("1")
 	if workingInstance == nil || err != nil {
 		t.Error("Retrieve operation failed")
@@ -219,6 +851,41 @@ func testUpdate(uri string, t *testing.T) {
 	if len(workingInstance.Mystring) == util.MaxStructLength {
 		t.Error("The database runtime is not consistent with the stored data")
 	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if _, err := Modify("1", func(object *structCheck) error {
+				object.Myint++
+				return nil
+			}); err != nil {
+				t.Error("Modify failed:", err)
+			}
+		})
+	}
+	wg.Wait()
+	if modified, err := Retrieve[structCheck]("1"); err != nil || modified == nil || modified.Myint != 244 || !modified.Mybool {
+		t.Error("Concurrent modifications should not lose changes:", modified, err)
+	}
+	if _, err := Modify("2", func(*structCheck) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Error("Modify of an unexistent object should not find it:", err)
+	}
+	if _, err := Modify("1", func(object *structCheck) error {
+		object.Mystring = "3"
+		return nil
+	}); !errors.Is(err, ErrInvalid) {
+		t.Error("Modify should not change the id:", err)
+	}
+	failure := errors.New("failure")
+	if _, err := Modify("1", func(object *structCheck) error {
+		object.Myint = 0
+		return failure
+	}); !errors.Is(err, failure) {
+		t.Error("Modify should return the error of the function:", err)
+	}
+	if modified, _ := Retrieve[structCheck]("1"); modified == nil || modified.Myint != 244 {
+		t.Error("Failed modifications should not be stored:", modified)
+	}
 }
 func testUpsert(uri string, t *testing.T) {
 	if err := Initialize(uri); err != nil {
@@ -244,6 +911,19 @@ func testUpsert(uri string, t *testing.T) {
 		t.Error("Creation failed")
 	}
 
+	if id, created, err := UpsertCreated(&structCheck{Mystring: id2, Myint: 346}); id != id2 || created || err != nil {
+		t.Error("UpsertCreated should report the update:", id, created, err)
+	}
+	if id, created, err := UpsertCreated(&structCheck{}); id == "" || !created || err != nil {
+		t.Error("UpsertCreated should report the creation:", id, created, err)
+	}
+	if created, err := BlobUpsertCreated("upserted", &[]byte{0x01}); !created || err != nil {
+		t.Error("BlobUpsertCreated should report the creation:", created, err)
+	}
+	if created, err := BlobUpsertCreated("upserted", &[]byte{0x02}); created || err != nil {
+		t.Error("BlobUpsertCreated should report the update:", created, err)
+	}
+
 	workingInstance, err := Retrieve[structCheck](id1)
 	if workingInstance == nil || err != nil {
 		t.Error("Retrieve operation failed")
@@ -290,8 +970,8 @@ func testCorrectBlobs(uri string, t *testing.T) {
 	if BlobUpdate("2", &[]byte{0x01}) == nil {
 		t.Error("Update of unexistent blob should not be nil")
 	}
-	if BlobRemove("2") == nil {
-		t.Error("Fake deletion didn't give any error")
+	if err := BlobRemove("2"); !errors.Is(err, ErrNotFound) {
+		t.Error("Fake deletion should not find the blob:", err)
 	}
 	if err := BlobRemove("1"); err != nil {
 		t.Error("Remove operation failed, when should be correct:", err)
@@ -300,3 +980,755 @@ func testCorrectBlobs(uri string, t *testing.T) {
 		t.Error("Remove operation successful, when should be incorrect:", err)
 	}
 }
+
+func testHooks(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+
+	instance := &hookedStructCheck{Mystring: "1", Myint: 1, Updates: 10}
+	if _, err := Create(instance); err != nil {
+		t.Error("Creation failed:", err)
+	}
+	if instance.Updates != 0 {
+		t.Error("BeforeCreate was not invoked")
+	}
+	if _, err := Create(&hookedStructCheck{Mystring: "2", Myint: -1}); err == nil {
+		t.Error("Creation of invalid instance should fail")
+	}
+	if exists, _ := Exists[hookedStructCheck]("2"); exists {
+		t.Error("Invalid instance should not be stored")
+	}
+	instance.Myint = 2
+	if err := Update(instance); err != nil {
+		t.Error("Update failed:", err)
+	}
+	instance.Myint = -2
+	if err := Update(instance); err == nil {
+		t.Error("Update of invalid instance should fail")
+	}
+	retrieved, err := Retrieve[hookedStructCheck]("1")
+	if err != nil || retrieved == nil {
+		t.Error("Retrieve operation failed")
+		Close()
+		return
+	}
+	if retrieved.Updates != 1 || retrieved.Myint != 2 {
+		t.Error("Cache should only contain the valid update:", retrieved)
+	}
+	Close()
+
+	if err := Initialize(uri); err != nil {
+		t.Error("Renitialization failed", err)
+	}
+	if err := Update(&hookedStructCheck{Mystring: "1", Myint: 3}); err != nil {
+		t.Error("Update failed:", err)
+	}
+	retrieved, err = Retrieve[hookedStructCheck]("1")
+	if err != nil || retrieved == nil {
+		t.Error("Retrieve operation failed")
+	} else if retrieved.Updates != 2 {
+		t.Error("BeforeUpdate should receive the stored object:", retrieved)
+	}
+	Close()
+
+	driver, err := OpenDriver(uri)
+	if err != nil {
+		t.Error("cannot open the driver:", err)
+		return
+	}
+	driver.Create("hookedStructCheck", "3", `{"Mystring":"3","Loaded":true}`)
+	driver.Close()
+	if err := Initialize(uri); err != nil {
+		t.Error("Renitialization failed", err)
+	}
+	defer Close()
+	for range 2 {
+		if _, err := Retrieve[hookedStructCheck]("1"); err == nil {
+			t.Error("Types with objects failing AfterLoad should not be loaded")
+		}
+	}
+	if err := RemoveById[hookedStructCheck]("3"); err == nil {
+		t.Error("Types with objects failing AfterLoad should not be modified")
+	}
+}
+
+func testTimestamps(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	defer Close()
+
+	if _, err := Create(&failingTimestampedStructCheck{}); err == nil {
+		t.Error("Creation of struct with non time.Time timestamp should fail")
+	}
+	before := time.Now()
+	instance := &timestampedStructCheck{Mystring: "1", CreatedAt: before.Add(-time.Hour)}
+	if _, err := Create(instance); err != nil {
+		t.Error("Creation failed:", err)
+	}
+	created, err := Retrieve[timestampedStructCheck]("1")
+	if err != nil || created == nil {
+		t.Error("Retrieve operation failed")
+		return
+	}
+	if created.CreatedAt.Before(before) || !created.CreatedAt.Equal(created.UpdatedAt) {
+		t.Error("Timestamps were not filled on creation:", created)
+	}
+	time.Sleep(time.Millisecond)
+	created.Myint = 1
+	created.CreatedAt = time.Time{}
+	if err := Update(created); err != nil {
+		t.Error("Update failed:", err)
+	}
+	updated, err := Retrieve[timestampedStructCheck]("1")
+	if err != nil || updated == nil {
+		t.Error("Retrieve operation failed")
+		return
+	}
+	if !updated.CreatedAt.Equal(instance.CreatedAt) {
+		t.Error("Created timestamp should not be overwritten:", updated.CreatedAt, instance.CreatedAt)
+	}
+	if !updated.UpdatedAt.After(instance.UpdatedAt) {
+		t.Error("Updated timestamp should be refreshed:", updated.UpdatedAt, instance.UpdatedAt)
+	}
+}
+
+func testIDStrategies(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	if err := SetIDStrategy[structCheck](IDSequence); err != nil {
+		t.Error("Setting the sequence strategy failed:", err)
+	}
+	for _, expected := range []string{"00000000000000000001", "00000000000000000002"} {
+		if id, err := Create(&structCheck{}); id != expected || err != nil {
+			t.Error("Sequence id should be", expected, "but got", id, err)
+		}
+	}
+	if err := SetIDStrategy[hookedStructCheck](IDULID); err != nil {
+		t.Error("Setting the ULID strategy failed:", err)
+	}
+	first, err := Create(&hookedStructCheck{})
+	if err != nil {
+		t.Error("Creation with ULID failed:", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second, err := Create(&hookedStructCheck{})
+	if err != nil {
+		t.Error("Creation with ULID failed:", err)
+	}
+	if len(first) != 26 || first >= second {
+		t.Error("ULIDs should be sortable by creation time:", first, second)
+	}
+	previous := second
+	for range 100 {
+		next, err := Create(&hookedStructCheck{})
+		if err != nil || next <= previous {
+			t.Error("ULIDs created in the same millisecond should be monotonic:", previous, next, err)
+			break
+		}
+		previous = next
+	}
+	if err := SetIDStrategy[timestampedStructCheck](IDUUIDv7); err != nil {
+		t.Error("Setting the UUIDv7 strategy failed:", err)
+	}
+	if id, err := Create(&timestampedStructCheck{}); len(id) != 36 || id[14] != '7' || err != nil {
+		t.Error("UUIDv7 creation failed:", id, err)
+	}
+	if err := SetIDStrategy[structCheck](IDStrategy(-1)); err == nil {
+		t.Error("Setting an unknown strategy should fail")
+	}
+	Close()
+
+	if err := Initialize(uri); err != nil {
+		t.Error("Renitialization failed", err)
+	}
+	defer Close()
+	if err := SetIDStrategy[structCheck](IDSequence); err != nil {
+		t.Error("Setting the sequence strategy failed:", err)
+	}
+	if id, err := Create(&structCheck{}); id != "00000000000000000003" || err != nil {
+		t.Error("Sequence should be persisted, but got", id, err)
+	}
+	if _, err := Create(&structCheck{Mystring: "00000000000000000004"}); err != nil {
+		t.Error("Creation with an explicit id failed:", err)
+	}
+	if id, err := Create(&structCheck{}); id != "00000000000000000005" || err != nil {
+		t.Error("Sequence should skip the ids in use, but got", id, err)
+	}
+	if id, err := Create(&structCheck{}); id != "00000000000000000006" || err != nil {
+		t.Error("Sequence should only advance once per id, but got", id, err)
+	}
+	if err := SetIDFunc(func(input *structCheck) string {
+		return fmt.Sprintf("natural-%d", input.Myint)
+	}); err != nil {
+		t.Error("Setting the id function failed:", err)
+	}
+	if id, err := Create(&structCheck{Myint: 5}); id != "natural-5" || err != nil {
+		t.Error("Natural key creation failed:", id, err)
+	}
+	if _, err := Create(&structCheck{Myint: 5}); err == nil {
+		t.Error("Repeated natural key creation should fail")
+	}
+	if _, err := NewID[structCheck](); err == nil {
+		t.Error("NewID should fail for natural keys")
+	}
+}
+
+func testReferences(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	defer Close()
+
+	if err := Register[failingRefCheck](); err == nil {
+		t.Error("Registration of unknown onremove policy should fail")
+	}
+	if _, err := Create(&customerCheck{Id: "c1", Name: "customer"}); err != nil {
+		t.Error("Creation failed:", err)
+	}
+	if _, err := Create(&orderCheck{Id: "o0", CustomerID: "unexistent"}); err == nil {
+		t.Error("Creation with dangling reference should fail")
+	}
+	if exists, _ := Exists[orderCheck]("o0"); exists {
+		t.Error("Object with dangling reference should not be stored")
+	}
+	if _, err := Create(&orderCheck{Id: "o1", CustomerID: "c1"}); err != nil {
+		t.Error("Creation failed:", err)
+	}
+	if _, err := Create(&orderLineCheck{Id: "l1", OrderID: "o1"}); err != nil {
+		t.Error("Creation failed:", err)
+	}
+	if _, err := Create(&noteCheck{Id: "n1", CustomerID: "c1"}); err != nil {
+		t.Error("Creation failed:", err)
+	}
+	if err := Update(&orderCheck{Id: "o1", CustomerID: "unexistent"}); err == nil {
+		t.Error("Update with dangling reference should fail")
+	}
+	customer, err := Resolve[customerCheck](&orderCheck{Id: "o1", CustomerID: "c1"}, "CustomerID")
+	if err != nil || customer == nil || customer.Name != "customer" {
+		t.Error("Resolve failed:", customer, err)
+	}
+	if _, err := Resolve[orderCheck](&orderCheck{Id: "o1", CustomerID: "c1"}, "CustomerID"); err == nil {
+		t.Error("Resolve with the wrong type should fail")
+	}
+	if err := RemoveById[customerCheck]("c1"); err == nil {
+		t.Error("Removal of restricted reference should fail")
+	}
+	if exists, _ := Exists[customerCheck]("c1"); !exists {
+		t.Error("Restricted removal should not remove anything")
+	}
+	if err := RemoveById[orderCheck]("o1"); err != nil {
+		t.Error("Removal failed:", err)
+	}
+	if exists, _ := Exists[orderLineCheck]("l1"); exists {
+		t.Error("Removal should cascade")
+	}
+	if err := RemoveById[customerCheck]("c1"); err != nil {
+		t.Error("Removal failed:", err)
+	}
+	note, err := Retrieve[noteCheck]("n1")
+	if err != nil || note == nil || note.CustomerID != "" {
+		t.Error("Removal should empty references:", note, err)
+	}
+
+	// The restricted reference from the comment to the task is removed by the cascade,
+	// whatever the order in which the references are visited
+	for i := range 20 {
+		Create(&projectCheck{Id: "p1"})
+		Create(&taskCheck{Id: "t1", ProjectID: "p1"})
+		Create(&commentCheck{Id: "m1", TaskID: "t1", ProjectID: "p1"})
+		if err := RemoveById[projectCheck]("p1"); err != nil {
+			t.Error("Removal with a restriction removed by the cascade failed in attempt", i, err)
+			break
+		}
+	}
+
+	// Types known by the process are loaded, even if they were not used since Initialize
+	Create(&customerCheck{Id: "c2"})
+	Create(&noteCheck{Id: "n2", CustomerID: "c2"})
+	Close()
+	if err := Initialize(uri); err != nil {
+		t.Error("Reinitialization failed", err)
+	}
+	if err := RemoveById[customerCheck]("c2"); err != nil {
+		t.Error("Removal failed:", err)
+	}
+	if note, _ := Retrieve[noteCheck]("n2"); note == nil || note.CustomerID != "" {
+		t.Error("Removal should empty references from types not used yet:", note)
+	}
+
+	// Types unknown by the process cannot apply their policies, so they refuse the removal
+	Create(&customerCheck{Id: "c3"})
+	dbDriver.Create(referencesTableName, "ghostCheck.CustomerID",
+		`{"Type":"ghostCheck","Field":"CustomerID","Target":"customerCheck","OnRemove":1}`)
+	dbDriver.Create("ghostCheck", "g1", `{"Id":"g1","CustomerID":"c3"}`)
+	if err := RemoveById[customerCheck]("c3"); !errors.Is(err, ErrConflict) {
+		t.Error("Removal referenced by an unknown type should fail:", err)
+	}
+	if exists, _ := Exists[customerCheck]("c3"); !exists {
+		t.Error("Refused removal should not remove anything")
+	}
+}
+
+// failingRemoveDriver fails the removal of one id, to check that removal plans are undone
+type failingRemoveDriver struct {
+	Driver
+	failingID string
+}
+
+func (d *failingRemoveDriver) Remove(inputType string, id string) error {
+	if id == d.failingID {
+		return errors.New("removal failed")
+	}
+	return d.Driver.Remove(inputType, id)
+}
+
+func TestRemovalRollback(t *testing.T) {
+	RegisterDriver("failingremove", func(dsn string) (Driver, error) {
+		backend, err := OpenDriver("memory:" + dsn)
+		return &failingRemoveDriver{backend, "c1"}, err
+	})
+	memory.Drop("rollback")
+	defer memory.Drop("rollback")
+	if err := Initialize("failingremove:rollback"); err != nil {
+		t.Fatal("Initialization failed", err)
+	}
+	defer Close()
+	Create(&customerCheck{Id: "c1"})
+	Create(&noteCheck{Id: "n1", CustomerID: "c1"})
+	if err := RemoveById[customerCheck]("c1"); err == nil {
+		t.Error("Removal should fail")
+	}
+	if note, _ := Retrieve[noteCheck]("n1"); note == nil || note.CustomerID != "c1" {
+		t.Error("Emptied reference was not restored:", note)
+	}
+	if stored, _ := dbDriver.Retrieve("noteCheck", "n1"); !strings.Contains(stored, `"c1"`) {
+		t.Error("Emptied reference was not restored in the database:", stored)
+	}
+}
+
+func testIndexes(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	defer Close()
+
+	if err := Register[failingIndexCheck](); err == nil {
+		t.Error("Registration of an index over a slice should fail")
+	}
+	if err := Register[failingIndexNameCheck](); err == nil {
+		t.Error("Registration of an index with a non alphanumeric name should fail")
+	}
+	for _, object := range []*indexedStructCheck{
+		{Id: "1", Email: "alice@example.com", Age: 30, Active: true},
+		{Id: "2", Email: "bob@example.com", Age: 40},
+	} {
+		if _, err := Create(object); err != nil {
+			t.Error("Creation failed:", err)
+		}
+	}
+	if retrieved, err := RetrieveBy[indexedStructCheck]("Email", "bob@example.com"); err != nil || retrieved == nil || retrieved.Id != "2" {
+		t.Error("RetrieveBy over indexed string failed:", retrieved, err)
+	}
+	if retrieved, err := RetrieveBy[indexedStructCheck]("Age", 30); err != nil || retrieved == nil || retrieved.Id != "1" {
+		t.Error("RetrieveBy over indexed int failed:", retrieved, err)
+	}
+	if retrieved, err := RetrieveBy[indexedStructCheck]("Active", false); err != nil || retrieved == nil || retrieved.Id != "2" {
+		t.Error("RetrieveBy over indexed bool failed:", retrieved, err)
+	}
+	long := &indexedStructCheck{Id: "3", Email: strings.Repeat("ñ", util.MaxIndexedLength+1)}
+	if _, err := Create(long); !errors.Is(err, ErrInvalid) {
+		t.Error("Indexed strings longer than the limit should be rejected:", err)
+	}
+	long.Email = strings.Repeat("ñ", util.MaxIndexedLength)
+	if _, err := Create(long); err != nil {
+		t.Error("Indexed strings as long as the limit should be accepted:", err)
+	}
+	if err := Update(&indexedStructCheck{Id: "2", Email: "robert@example.com", Age: 41}); err != nil {
+		t.Error("Update failed:", err)
+	}
+	if retrieved, err := RetrieveBy[indexedStructCheck]("Email", "bob@example.com"); err != nil || retrieved != nil {
+		t.Error("RetrieveBy found an outdated value:", retrieved, err)
+	}
+	if retrieved, _ := RetrieveBy[indexedStructCheck]("Email", "robert@example.com"); retrieved == nil || retrieved.Age != 41 {
+		t.Error("RetrieveBy did not find the updated value:", retrieved)
+	}
+	if err := RemoveById[indexedStructCheck]("1"); err != nil {
+		t.Error("Removal failed:", err)
+	}
+	if exists, err := ExistsBy[indexedStructCheck]("Age", 30); err != nil || exists {
+		t.Error("ExistsBy found a removed object:", exists, err)
+	}
+}
+
+func testFilter(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	defer Close()
+
+	for _, object := range []*filteredStructCheck{
+		{Id: "1", Name: "alice", Score: 10, Admin: true},
+		{Id: "2", Name: "bob", Score: 20, Level: 1},
+		{Id: "3", Name: "carol", Score: 30, Level: 2, Admin: true},
+	} {
+		if _, err := Create(object); err != nil {
+			t.Error("Creation failed:", err)
+		}
+	}
+	for _, check := range []struct {
+		conditions []Condition
+		ids        string
+	}{
+		{nil, "123"},
+		{[]Condition{Eq("Name", "bob")}, "2"},
+		{[]Condition{Ne("Name", "bob")}, "13"},
+		{[]Condition{Gt("Score", uint(10)), Le("Score", uint(20))}, "2"},
+		{[]Condition{Ge("Name", "bob"), Eq("Admin", true)}, "3"},
+		{[]Condition{Lt("Level", 1)}, "1"},
+		{[]Condition{Eq("Level", 0), Eq("Admin", false)}, ""},
+	} {
+		filtered, err := Filter[filteredStructCheck](check.conditions...)
+		if err != nil {
+			t.Error("Filter failed:", err)
+			continue
+		}
+		ids := ""
+		for _, id := range []string{"1", "2", "3"} {
+			if object, found := filtered[id]; found && object.Id == id {
+				ids += id
+			}
+		}
+		if ids != check.ids || len(filtered) != len(ids) {
+			t.Errorf("Filter %v gives %v", check.conditions, filtered)
+		}
+	}
+	if err := Update(&filteredStructCheck{Id: "1", Name: "alice", Score: 50}); err != nil {
+		t.Error("Update failed:", err)
+	}
+	if filtered, _ := Filter[filteredStructCheck](Gt("Score", uint(40))); len(filtered) != 1 || filtered["1"] == nil {
+		t.Error("Filter does not see updates:", filtered)
+	}
+	for _, invalid := range []Condition{
+		Eq("Unexistent", "x"), Eq("Score", 10), Gt("Admin", true), Eq("Tags", []string{}), {Attribute: "Name", Operator: "like", Value: "a"},
+	} {
+		if _, err := Filter[filteredStructCheck](invalid); err == nil {
+			t.Error("Filter with invalid condition should fail:", invalid)
+		}
+	}
+}
+
+func testAll(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	defer Close()
+
+	const total = 250
+	for i := range total {
+		if _, err := Create(&customerCheck{Id: fmt.Sprintf("%03d", i), Name: "customer"}); err != nil {
+			t.Error("Creation failed:", err)
+		}
+	}
+	visited := 0
+	previous := ""
+	objects, iterationErr := AllErr[customerCheck](context.Background())
+	for id, customer := range objects {
+		if customer == nil || customer.Id != id || id <= previous {
+			t.Error("All gives unordered or wrong objects:", previous, id, customer)
+			break
+		}
+		customer.Name = "modified"
+		previous = id
+		visited++
+	}
+	if visited != total || iterationErr() != nil {
+		t.Error("All visited", visited, "objects instead of", total, iterationErr())
+	}
+	if customer, _ := Retrieve[customerCheck]("000"); customer.Name != "customer" {
+		t.Error("All gives objects shared with the cache")
+	}
+
+	visited = 0
+	for id := range All[customerCheck](context.Background()) {
+		if id == "010" {
+			RemoveById[customerCheck]("200")
+			Create(&customerCheck{Id: "2000", Name: "customer"})
+		}
+		if id == "200" {
+			t.Error("All visits objects removed during the iteration")
+		}
+		visited++
+		if id == "220" {
+			break
+		}
+	}
+	if visited != 221 {
+		t.Error("All does not visit the objects created after the current one or does not stop:", visited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	visited = 0
+	objects, iterationErr = AllErr[customerCheck](ctx)
+	for range objects {
+		visited++
+		if visited == 5 {
+			cancel()
+		}
+	}
+	if visited != 5 || !errors.Is(iterationErr(), context.Canceled) {
+		t.Error("All does not stop with an error when the context is canceled:", visited, iterationErr())
+	}
+}
+
+func testPage(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	defer Close()
+
+	for i := range 25 {
+		if _, err := Create(&customerCheck{Id: fmt.Sprintf("%02d", i*2), Name: "customer"}); err != nil {
+			t.Error("Creation failed:", err)
+		}
+	}
+	page, next, err := Page[customerCheck]("", 10)
+	if err != nil || len(page) != 10 || page[0].Id != "00" || page[9].Id != "18" || next != "18" {
+		t.Fatal("First page is wrong:", page, next, err)
+	}
+	// Changes before the cursor and removals after it do not alter the following pages
+	Create(&customerCheck{Id: "01", Name: "customer"})
+	RemoveById[customerCheck]("20")
+	page, next, err = Page[customerCheck](next, 10)
+	if err != nil || len(page) != 10 || page[0].Id != "22" || page[9].Id != "40" || next != "40" {
+		t.Error("Second page is wrong:", page, next, err)
+	}
+	page, next, err = Page[customerCheck](next, 10)
+	if err != nil || len(page) != 4 || page[3].Id != "48" || next != "" {
+		t.Error("Last page is wrong:", page, next, err)
+	}
+	if _, _, err = Page[customerCheck]("", 0); err == nil {
+		t.Error("Page with invalid limit should fail")
+	}
+
+	for _, object := range []*filteredStructCheck{
+		{Id: "a", Score: 3}, {Id: "b", Score: 1}, {Id: "c", Score: 2}, {Id: "d", Score: 1},
+	} {
+		Create(object)
+	}
+	ids := ""
+	cursor := ""
+	for pages := 0; pages < 3; pages++ {
+		filtered, next, err := PageBy[filteredStructCheck]("Score", cursor, 3)
+		if err != nil {
+			t.Error("PageBy failed:", err)
+			break
+		}
+		for _, object := range filtered {
+			ids += object.Id
+		}
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+	if ids != "bdca" {
+		t.Error("PageBy gives a wrong order:", ids)
+	}
+	if _, _, err = PageBy[filteredStructCheck]("Tags", "", 3); err == nil {
+		t.Error("PageBy over a slice should fail")
+	}
+	if _, _, err = PageBy[filteredStructCheck]("Name", "", 3); err == nil {
+		t.Error("PageBy over a field without index should fail")
+	}
+	if _, _, err = PageBy[filteredStructCheck]("Score", "invalid", 3); err == nil {
+		t.Error("PageBy with invalid cursor should fail")
+	}
+}
+
+func testAggregates(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	defer Close()
+
+	if count, err := Count[filteredStructCheck](); err != nil || count != 0 {
+		t.Error("Count of empty type is wrong:", count, err)
+	}
+	if object, err := MaxBy[filteredStructCheck]("Score"); err != nil || object != nil {
+		t.Error("MaxBy of empty type is wrong:", object, err)
+	}
+	for _, object := range []*filteredStructCheck{
+		{Id: "1", Name: "alice", Score: 10, Level: -3, Admin: true},
+		{Id: "2", Name: "bob", Score: 30, Level: 2},
+		{Id: "3", Name: "carol", Score: 30, Level: 1, Admin: true},
+		{Id: "4", Name: "dave", Score: 5, Level: 2},
+	} {
+		if _, err := Create(object); err != nil {
+			t.Error("Creation failed:", err)
+		}
+	}
+	if count, err := Count[filteredStructCheck](); err != nil || count != 4 {
+		t.Error("Count is wrong:", count, err)
+	}
+	if count, err := CountWhere[filteredStructCheck](Eq("Admin", true), Ge("Score", uint(10))); err != nil || count != 2 {
+		t.Error("CountWhere is wrong:", count, err)
+	}
+	if sum, err := Sum[filteredStructCheck, uint]("Score"); err != nil || sum != 75 {
+		t.Error("Sum is wrong:", sum, err)
+	}
+	if sum, err := Sum[filteredStructCheck, float64]("Level"); err != nil || sum != 2 {
+		t.Error("Sum is wrong:", sum, err)
+	}
+	if object, err := MaxBy[filteredStructCheck]("Score"); err != nil || object == nil || object.Id != "2" {
+		t.Error("MaxBy is wrong:", object, err)
+	}
+	if object, err := MinBy[filteredStructCheck]("Level"); err != nil || object == nil || object.Id != "1" {
+		t.Error("MinBy is wrong:", object, err)
+	}
+	if object, err := MaxBy[filteredStructCheck]("Name"); err != nil || object == nil || object.Id != "4" {
+		t.Error("MaxBy over strings is wrong:", object, err)
+	}
+	groups, err := GroupBy[filteredStructCheck, bool]("Admin")
+	if err != nil || len(groups) != 2 || len(groups[true]) != 2 || groups[true][0].Id != "1" || groups[false][1].Id != "4" {
+		t.Error("GroupBy is wrong:", groups, err)
+	}
+	groups[true][0].Name = "modified"
+	if object, _ := Retrieve[filteredStructCheck]("1"); object.Name != "alice" {
+		t.Error("GroupBy gives objects shared with the cache")
+	}
+	if _, err := Sum[filteredStructCheck, int]("Name"); err == nil {
+		t.Error("Sum over strings should fail")
+	}
+	if _, err := MinBy[filteredStructCheck]("Unexistent"); err == nil {
+		t.Error("MinBy over unknown attributes should fail")
+	}
+	if _, err := GroupBy[filteredStructCheck, string]("Score"); err == nil {
+		t.Error("GroupBy with wrong key type should fail")
+	}
+	if _, err := CountWhere[filteredStructCheck](Eq("Score", 10)); err == nil {
+		t.Error("CountWhere with invalid condition should fail")
+	}
+}
+
+func testSearch(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+
+	if err := Register[failingSearchCheck](); err == nil {
+		t.Error("Registration of a search over an int should fail")
+	}
+	for _, object := range []*searchableStructCheck{
+		{Id: "1", Title: "Shopping list", Body: "Milk, eggs and bread"},
+		{Id: "2", Title: "Go notes", Body: "Channels, goroutines and generics in Go"},
+		{Id: "3", Title: "Travel", Body: "Go to the airport at 6"},
+	} {
+		if _, err := Create(object); err != nil {
+			t.Error("Creation failed:", err)
+		}
+	}
+	ids := func(objects []*searchableStructCheck) (output string) {
+		for _, object := range objects {
+			output += object.Id
+		}
+		return
+	}
+	if found, err := Search[searchableStructCheck]("GO", 10); err != nil || ids(found) != "23" {
+		t.Error("Search gives wrong results:", ids(found), err)
+	}
+	if found, err := Search[searchableStructCheck]("bread airport", 1); err != nil || len(found) != 1 {
+		t.Error("Search does not respect the limit:", ids(found), err)
+	}
+	if found, err := Search[searchableStructCheck]("unknown", 10); err != nil || len(found) != 0 {
+		t.Error("Search finds unknown words:", ids(found), err)
+	}
+	if err := Update(&searchableStructCheck{Id: "2", Title: "Rust notes", Body: "Traits"}); err != nil {
+		t.Error("Update failed:", err)
+	}
+	if err := RemoveById[searchableStructCheck]("1"); err != nil {
+		t.Error("Removal failed:", err)
+	}
+	if found, _ := Search[searchableStructCheck]("go bread", 10); ids(found) != "3" {
+		t.Error("Search does not follow updates and removals:", ids(found))
+	}
+	if _, err := Search[customerCheck]("customer", 10); err == nil {
+		t.Error("Search over types without searchable parameters should fail")
+	}
+	Close()
+
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	defer Close()
+	if found, _ := Search[searchableStructCheck]("traits", 10); ids(found) != "2" {
+		t.Error("Search index is not rebuilt when loading:", ids(found))
+	}
+}
+
+func testBackup(uri string, t *testing.T) {
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	Create(&customerCheck{Id: "c1", Name: "customer"})
+	Create(&customerCheck{Id: "c2", Name: "customer"})
+	Create(&searchableStructCheck{Id: "1", Title: "Backup notes"})
+	BlobCreate("b1", &[]byte{0x01, 0x02})
+	backup := new(bytes.Buffer)
+	if err := Backup(backup); err != nil {
+		t.Error("Backup failed:", err)
+	}
+
+	Update(&customerCheck{Id: "c1", Name: "changed"})
+	Create(&customerCheck{Id: "c3", Name: "new"})
+	RemoveById[searchableStructCheck]("1")
+	BlobRemove("b1")
+	BlobCreate("b2", &[]byte{0x03})
+	if err := Restore(bytes.NewReader(backup.Bytes()), RestoreMerge); err != nil {
+		t.Error("Merge failed:", err)
+	}
+	if restored, _ := Retrieve[customerCheck]("c1"); restored == nil || restored.Name != "customer" {
+		t.Error("Merge does not restore objects:", restored)
+	}
+	if exists, _ := Exists[customerCheck]("c3"); !exists {
+		t.Error("Merge removes objects not in the backup")
+	}
+	if blob, err := BlobRetrieve("b1"); err != nil || !util.BlobsEqual(blob, &[]byte{0x01, 0x02}) {
+		t.Error("Merge does not restore blobs:", err)
+	}
+	if found, _ := Search[searchableStructCheck]("notes", 10); len(found) != 1 {
+		t.Error("Search index is not rebuilt after restoring:", found)
+	}
+
+	truncated := backup.Bytes()[:backup.Len()-10]
+	if err := Restore(bytes.NewReader(truncated), RestoreReplace); err == nil {
+		t.Error("Truncated backups should be rejected")
+	}
+	if exists, _ := Exists[customerCheck]("c3"); !exists {
+		t.Error("Rejected backups should not change anything")
+	}
+	if err := Restore(strings.NewReader(`{"format":"elephant-backup","version":99}`), RestoreMerge); err == nil {
+		t.Error("Newer backup versions should be rejected")
+	}
+
+	if err := Restore(bytes.NewReader(backup.Bytes()), RestoreReplace); err != nil {
+		t.Error("Replace failed:", err)
+	}
+	if exists, _ := Exists[customerCheck]("c3"); exists {
+		t.Error("Replace keeps objects not in the backup")
+	}
+	if exists, _ := BlobExists("b2"); exists {
+		t.Error("Replace keeps blobs not in the backup")
+	}
+	Close()
+
+	if err := Initialize(uri); err != nil {
+		t.Error("Initialization failed", err)
+	}
+	defer Close()
+	if all, _ := RetrieveAll[customerCheck](); len(all) != 2 || all["c1"].Name != "customer" {
+		t.Error("Restored objects are not persisted:", all)
+	}
+}
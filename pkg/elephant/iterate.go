@@ -0,0 +1,57 @@
+package elephant
+
+import (
+	"context"
+	"iter"
+	"reflect"
+)
+
+// allBatchSize is the number of objects copied by each action during iterations
+const allBatchSize = 100
+
+// All iterates over the elements of a specific type in id order, yielding the ids and
+// copies of the objects. Each batch of ids is read with the cursor of the driver, if
+// it implements db.Pager, so the whole type is never listed nor copied at once.
+// Objects removed during the iteration are skipped and created ones are visited if
+// their ids come after the current one. The iteration stops when ctx is done or a
+// batch cannot be read: use AllErr to know why
+func All[inputType any](ctx context.Context) iter.Seq2[string, *inputType] {
+	objects, _ := AllErr[inputType](ctx)
+	return objects
+}
+
+// AllErr is like All, but also returns a function giving the error which stopped
+// the last iteration, or nil if it visited every object or the loop was broken
+func AllErr[inputType any](ctx context.Context) (iter.Seq2[string, *inputType], func() error) {
+	var err error
+	objects := func(yield func(string, *inputType) bool) {
+		checkInitialization()
+		err = nil
+		after := ""
+		for {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			action := newInternalAction(actionPage, reflect.TypeFor[*inputType](), after, allBatchSize)
+			action.ctx = ctx
+			output := send(action)
+			if err = output.err; err != nil {
+				return
+			}
+			page := output.data.(pageOutput)
+			for i, object := range page.objects {
+				if err = ctx.Err(); err != nil {
+					return
+				}
+				if !yield(page.ids[i], object.(*inputType)) {
+					return
+				}
+			}
+			if page.next == "" {
+				return
+			}
+			after = page.next
+		}
+	}
+	return objects, func() error { return err }
+}
@@ -0,0 +1,36 @@
+package elephant
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+var (
+	logger            = slog.New(slog.DiscardHandler)
+	slowCallThreshold = 100 * time.Millisecond
+)
+
+// SetLogger makes elephant write structured records about type registration, table
+// creation, load errors, slow driver calls and rollbacks. Nothing is logged by default
+// or when logger is nil. It must be called before Initialize
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(slog.DiscardHandler)
+	}
+	logger = l
+}
+
+// SetSlowCallThreshold changes the duration from which driver calls are logged as slow.
+// It must be called before Initialize
+func SetSlowCallThreshold(threshold time.Duration) {
+	slowCallThreshold = threshold
+}
+
+// setDriverLogger passes the logger to drivers which log their own events
+func setDriverLogger() {
+	if loggingDriver, isLogging := dbDriver.(db.Logging); isLogging {
+		loggingDriver.SetLogger(logger)
+	}
+}
@@ -0,0 +1,112 @@
+package elephant
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gonimals/elephant/pkg/metrics"
+)
+
+var (
+	metricsSink metrics.Metrics
+	queueDepth  atomic.Int64
+)
+
+// Names of the actions in the measurements
+var /*const*/ actionNames = [...]string{
+	actionRetrieve:       "retrieve",
+	actionRetrieveBy:     "retrieve_by",
+	actionRetrieveAll:    "retrieve_all",
+	actionUpdate:         "update",
+	actionUpsert:         "upsert",
+	actionModify:         "modify",
+	actionRemove:         "remove",
+	actionRemoveById:     "remove_by_id",
+	actionCreate:         "create",
+	actionExists:         "exists",
+	actionExistsBy:       "exists_by",
+	actionNextID:         "next_id",
+	actionBlobRetrieve:   "blob_retrieve",
+	actionBlobCreate:     "blob_create",
+	actionBlobRemove:     "blob_remove",
+	actionBlobUpdate:     "blob_update",
+	actionBlobUpsert:     "blob_upsert",
+	actionBlobExists:     "blob_exists",
+	actionSetIDGenerator: "set_id_generator",
+	actionRegister:       "register",
+	actionFilter:         "filter",
+	actionPage:           "page",
+	actionPageBy:         "page_by",
+	actionCount:          "count",
+	actionSum:            "sum",
+	actionMinMax:         "min_max",
+	actionGroupBy:        "group_by",
+	actionSearch:         "search",
+	actionBackup:         "backup",
+	actionRestore:        "restore",
+}
+
+// SetMetrics makes elephant report its measurements to m. Names are defined in
+// the metrics package. It must be called before Initialize, and nil disables the reports
+func SetMetrics(m metrics.Metrics) {
+	metricsSink = m
+}
+
+// observeQueue reports the time the action waited to be executed and returns the current time
+func observeQueue(action *internalAction) time.Time {
+	now := time.Now()
+	depth := queueDepth.Add(-1)
+	if metricsSink != nil {
+		metricsSink.Set(metrics.QueueDepth, float64(depth))
+		metricsSink.Observe(metrics.QueueSeconds, now.Sub(action.queued).Seconds(),
+			metrics.Label{Name: "action", Value: actionNames[action.code]})
+	}
+	return now
+}
+
+// observeAction reports the execution of an action which started at started
+func observeAction(action *internalAction, started time.Time, err error) {
+	if metricsSink == nil {
+		return
+	}
+	label := metrics.Label{Name: "action", Value: actionNames[action.code]}
+	metricsSink.Observe(metrics.ActionSeconds, time.Since(started).Seconds(), label)
+	metricsSink.Add(metrics.ActionsTotal, 1, label)
+	if err != nil {
+		metricsSink.Add(metrics.ActionErrorsTotal, 1, label)
+	}
+	if action.inputType != blobReflectType && learntTypes[action.inputType] != nil {
+		metricsSink.Set(metrics.CachedObjects, float64(len(data[action.inputType])),
+			metrics.Label{Name: "type", Value: learntTypes[action.inputType].Name})
+	}
+}
+
+// measure performs a driver call, reporting its duration and failure and logging it if it is slow
+func measure(operation string, table string, call func() error) error {
+	span := traceDriver(operation, table)
+	started := time.Now()
+	err := call()
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+	if elapsed := time.Since(started); elapsed >= slowCallThreshold {
+		logger.Warn("slow driver call", "operation", operation, "table", table, "duration", elapsed, "error", err)
+	}
+	if metricsSink == nil {
+		return err
+	}
+	labels := []metrics.Label{{Name: "operation", Value: operation}, {Name: "table", Value: table}}
+	metricsSink.Observe(metrics.DriverSeconds, time.Since(started).Seconds(), labels...)
+	if err != nil {
+		metricsSink.Add(metrics.DriverErrorsTotal, 1, labels...)
+	}
+	return err
+}
+
+// setDriverMetrics passes the metrics to drivers which report their own measurements
+func setDriverMetrics() {
+	if instrumented, isInstrumented := dbDriver.(metrics.Instrumented); isInstrumented && metricsSink != nil {
+		instrumented.SetMetrics(metricsSink)
+	}
+}
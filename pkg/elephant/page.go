@@ -0,0 +1,199 @@
+package elephant
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// pageOutput is the data returned by page actions
+type pageOutput struct {
+	ids     []string
+	objects []any
+	next    string
+}
+
+// Page gets up to limit elements of a specific type with ids greater than after, ordered by id.
+// The first page is requested with an empty after. next is the after value of the following
+// page, and empty on the last one. Pages are stable while objects are created or removed
+func Page[inputType any](after string, limit int) (output []*inputType, next string, err error) {
+	return PageContext[inputType](context.Background(), after, limit)
+}
+
+// PageContext is like Page, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func PageContext[inputType any](ctx context.Context, after string, limit int) (output []*inputType, next string, err error) {
+	checkInitialization()
+	action := newInternalAction(actionPage, reflect.TypeFor[*inputType](), after, limit)
+	action.ctx = ctx
+	return handleOutputPage[inputType](send(action))
+}
+
+// PageBy gets up to limit elements of a specific type ordered by an attribute tagged with
+// `db:"index"` and then by id. cursor is empty for the first page and next for the following ones.
+// Every page sorts the cached objects after the cursor, so it costs O(n log n) in the size of the type
+func PageBy[inputType any](attribute string, cursor string, limit int) (output []*inputType, next string, err error) {
+	return PageByContext[inputType](context.Background(), attribute, cursor, limit)
+}
+
+// PageByContext is like PageBy, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func PageByContext[inputType any](ctx context.Context, attribute string, cursor string, limit int) (output []*inputType, next string, err error) {
+	checkInitialization()
+	action := newInternalAction(actionPageBy, reflect.TypeFor[*inputType](), attribute, cursor, limit)
+	action.ctx = ctx
+	return handleOutputPage[inputType](send(action))
+}
+
+func handleOutputPage[inputType any](output actionOutput) ([]*inputType, string, error) {
+	if output.err != nil {
+		return nil, "", output.err
+	}
+	page := output.data.(pageOutput)
+	objects := make([]*inputType, 0, len(page.objects))
+	for _, object := range page.objects {
+		objects = append(objects, object.(*inputType))
+	}
+	return objects, page.next, nil
+}
+
+// execPage lists the ids with the driver, if it can, or with the cache
+func execPage(inputType reflect.Type, after string, limit int) (output any, err error) {
+	if limit <= 0 {
+		return nil, util.Errorf("invalid page limit: %d", limit)
+	}
+	var ids []string
+	pager, isPager := dbDriver.(db.Pager)
+	if isPager {
+		err = measure("RetrieveIDsAfter", getTableName(inputType), func() (err error) {
+			ids, err = pager.RetrieveIDsAfter(getTableName(inputType), after, limit+1)
+			return
+		})
+	}
+	if err != nil {
+		logger.Warn("paging in the database failed, sorting the cache", "type", learntTypes[inputType].Name, "error", err)
+	}
+	if !isPager || err != nil {
+		ids = execSmallestIDsAfter(inputType, after, limit+1)
+	}
+	page := pageOutput{}
+	if len(ids) > limit {
+		ids = ids[:limit]
+		page.next = ids[limit-1]
+	}
+	objects, err := execRetrieveIDs(inputType, ids)
+	for i, object := range objects {
+		if object != nil { // nil when the driver knows ids missing in the cache
+			page.ids = append(page.ids, ids[i])
+			page.objects = append(page.objects, object)
+		}
+	}
+	return page, err
+}
+
+// execSmallestIDsAfter returns up to limit cached ids greater than after, sorted,
+// without sorting the whole type
+func execSmallestIDsAfter(inputType reflect.Type, after string, limit int) []string {
+	ids := make([]string, 0, min(limit, len(data[inputType])))
+	for id := range data[inputType] {
+		if id <= after || (len(ids) == limit && id >= ids[limit-1]) {
+			continue
+		}
+		if len(ids) == limit {
+			ids = ids[:limit-1]
+		}
+		position, _ := slices.BinarySearch(ids, id)
+		ids = slices.Insert(ids, position, id)
+	}
+	return ids
+}
+
+// execRetrieveIDs returns copies of the objects with the given ids, with nil for the missing ones
+func execRetrieveIDs(inputType reflect.Type, ids []string) (output []any, err error) {
+	output = make([]any, len(ids))
+	for i, id := range ids {
+		if object, exists := data[inputType][id]; exists {
+			output[i], err = util.CopyEntireObject(object)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
+
+// execPageBy sorts the cached objects by the attribute and id. The attribute is indexed,
+// so the values are strings, integers or bools
+func execPageBy(inputType reflect.Type, attribute string, cursor string, limit int) (output any, err error) {
+	if limit <= 0 {
+		return nil, util.Errorf("invalid page limit: %d", limit)
+	}
+	if _, indexed := learntTypes[inputType].Indexes[attribute]; !indexed {
+		return nil, util.Errorf("cannot page by attribute named %s: only attributes tagged with `db:\"index\"` are allowed", attribute)
+	}
+	fieldType := learntTypes[inputType].Fields[attribute]
+	attributeOf := func(id string) any {
+		return reflect.ValueOf(data[inputType][id]).Elem().FieldByName(attribute).Interface()
+	}
+	compare := func(firstValue any, firstId string, secondValue any, secondId string) int {
+		if comparison := compareValues(firstValue, secondValue); comparison != 0 {
+			return comparison
+		}
+		return strings.Compare(firstId, secondId)
+	}
+	var afterValue any
+	var afterId string
+	if cursor != "" {
+		afterValue, afterId, err = decodeCursor(fieldType, cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var ids []string
+	for id := range data[inputType] {
+		if cursor == "" || compare(attributeOf(id), id, afterValue, afterId) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(first, second string) int {
+		return compare(attributeOf(first), first, attributeOf(second), second)
+	})
+	page := pageOutput{}
+	if len(ids) > limit {
+		ids = ids[:limit]
+		page.next, err = encodeCursor(attributeOf(ids[limit-1]), ids[limit-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	page.ids = ids
+	page.objects, err = execRetrieveIDs(inputType, ids)
+	return page, err
+}
+
+// encodeCursor builds the cursor of PageBy, which is a JSON array with the attribute and the id
+func encodeCursor(value any, id string) (string, error) {
+	cursor, err := json.Marshal([]any{value, id})
+	if err != nil {
+		return "", util.Errorf("cannot encode cursor: %v", err)
+	}
+	return string(cursor), nil
+}
+
+func decodeCursor(fieldType reflect.Type, cursor string) (value any, id string, err error) {
+	var parts []json.RawMessage
+	if err = json.Unmarshal([]byte(cursor), &parts); err != nil || len(parts) != 2 {
+		return nil, "", util.Errorf("invalid cursor: %s", cursor)
+	}
+	valuePtr := reflect.New(fieldType)
+	if err = json.Unmarshal(parts[0], valuePtr.Interface()); err != nil {
+		return nil, "", util.Errorf("invalid cursor: %s", cursor)
+	}
+	if err = json.Unmarshal(parts[1], &id); err != nil {
+		return nil, "", util.Errorf("invalid cursor: %s", cursor)
+	}
+	return valuePtr.Elem().Interface(), id, nil
+}
@@ -0,0 +1,416 @@
+package elephant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/gonimals/elephant/internal/util"
+)
+
+// Name for the table storing the references declared by the managed types, so removals
+// know about referencing types not loaded by this process. It starts with a digit,
+// so it cannot be the name of a Go type
+const referencesTableName = "0references"
+
+// storedReference is the value stored in the references table for every reference field
+type storedReference struct {
+	Type     string // referencing type
+	Field    string // JSON name of the referencing field
+	Target   string // referenced type
+	OnRemove int
+}
+
+// removalPlan stores the changes needed to remove an object keeping referential integrity
+type removalPlan struct {
+	removals     []plannedChange
+	setEmpties   []plannedChange
+	restrictions []plannedChange // objects which must be removed too, or the removal is refused
+	visited      map[reflect.Type]map[string]bool
+	unmanaged    []storedReference                       // references from types this process does not know
+	unmanagedIDs map[storedReference]map[string][]string // referenced id to referencing ids, read when needed
+}
+
+type plannedChange struct {
+	inputType reflect.Type
+	id        string
+	field     string
+	target    string // referenced id of restrictions
+}
+
+// appliedChange stores what a change of the plan replaced, so it can be undone
+type appliedChange struct {
+	plannedChange
+	removed bool
+	old     any
+	oldJSON string
+}
+
+// Register makes elephant manage inputType, loading its data and recording its references,
+// so removals of referenced objects take them into account even if inputType is not registered
+func Register[inputType any]() error {
+	return RegisterContext[inputType](context.Background())
+}
+
+// RegisterContext is like Register, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func RegisterContext[inputType any](ctx context.Context) error {
+	checkInitialization()
+	action := newInternalAction(actionRegister, reflect.TypeFor[*inputType]())
+	action.ctx = ctx
+	return (send(action)).err
+}
+
+// Resolve returns the object referenced by the attribute of input, tagged with `db:"ref=..."`.
+// Returns nil if the reference is empty
+func Resolve[targetType any](input any, attribute string) (*targetType, error) {
+	return ResolveContext[targetType](context.Background(), input, attribute)
+}
+
+// ResolveContext is like Resolve, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func ResolveContext[targetType any](ctx context.Context, input any, attribute string) (*targetType, error) {
+	checkInitialization()
+	learntType, err := util.ExamineType(reflect.TypeOf(input))
+	if err != nil {
+		return nil, err
+	}
+	reference := learntType.Refs[attribute]
+	if reference == nil {
+		return nil, util.Errorf("%s is not a reference of %s", attribute, learntType.Name)
+	}
+	targetLearntType, err := util.ExamineType(reflect.TypeFor[*targetType]())
+	if err != nil {
+		return nil, err
+	}
+	if reference.Type != targetLearntType.Name {
+		return nil, util.Errorf("%s references %s, not %s", attribute, reference.Type, targetLearntType.Name)
+	}
+	if reflect.ValueOf(input).IsNil() {
+		return nil, util.Errorf("cannot resolve references of nil")
+	}
+	id := reflect.ValueOf(input).Elem().FieldByName(attribute).String()
+	if id == "" {
+		return nil, nil
+	}
+	return RetrieveContext[targetType](ctx, id)
+}
+
+// findManagedType returns the managed type with the provided name, or nil if not found
+func findManagedType(name string) reflect.Type {
+	for inputType, learntType := range learntTypes {
+		if learntType.Name == name && inputType != blobReflectType {
+			return inputType
+		}
+	}
+	return nil
+}
+
+// execCheckReferences returns an error if any reference of object points to an unexistent object
+func execCheckReferences(inputType reflect.Type, object any) error {
+	for field, reference := range learntTypes[inputType].Refs {
+		id := reflect.ValueOf(object).Elem().FieldByName(field).String()
+		if id == "" {
+			continue
+		}
+		if targetType := findManagedType(reference.Type); targetType != nil {
+			if !execExists(targetType, id) {
+				return util.Errorf("%w: %s references an unexistent %s: %s", ErrInvalid, field, reference.Type, id)
+			}
+			continue
+		}
+		var stored string
+		err := measure("Retrieve", reference.Type, func() (err error) {
+			stored, err = dbDriver.Retrieve(reference.Type, id)
+			return
+		})
+		if err != nil {
+			return util.Errorf("cannot check reference %s: %v", field, err)
+		}
+		if stored == "" {
+			return util.Errorf("%w: %s references an unexistent %s: %s", ErrInvalid, field, reference.Type, id)
+		}
+	}
+	return nil
+}
+
+// referenceID is the id in the references table of the reference stored in field
+func referenceID(typeName string, field string) string {
+	return typeName + "." + field
+}
+
+// execReadReferences returns the references recorded by every type which has been managed
+func execReadReferences() (output map[string]storedReference, err error) {
+	var stored map[string]string
+	err = measure("RetrieveAll", referencesTableName, func() (err error) {
+		stored, err = dbDriver.RetrieveAll(referencesTableName)
+		return
+	})
+	if err != nil {
+		return nil, util.Errorf("cannot read references: %v", err)
+	}
+	output = make(map[string]storedReference, len(stored))
+	for id, value := range stored {
+		var reference storedReference
+		if err = json.Unmarshal([]byte(value), &reference); err != nil {
+			return nil, util.Errorf("invalid reference %s: %v", id, err)
+		}
+		output[id] = reference
+	}
+	return
+}
+
+// execStoreReferences records the references of inputType, forgetting the ones it no longer has
+func execStoreReferences(inputType reflect.Type) error {
+	learntType := learntTypes[inputType]
+	stored, err := execReadReferences()
+	if err != nil {
+		return err
+	}
+	expected := make(map[string]storedReference, len(learntType.Refs))
+	for field, reference := range learntType.Refs {
+		structField, _ := inputType.Elem().FieldByName(field)
+		expected[referenceID(learntType.Name, field)] = storedReference{
+			Type:     learntType.Name,
+			Field:    util.JSONName(structField),
+			Target:   reference.Type,
+			OnRemove: reference.OnRemove,
+		}
+	}
+	for id, reference := range expected {
+		current, exists := stored[id]
+		if exists && current == reference {
+			continue
+		}
+		value, _ := json.Marshal(reference)
+		if exists {
+			err = measure("Update", referencesTableName, func() error {
+				return dbDriver.Update(referencesTableName, id, string(value))
+			})
+		} else {
+			err = measure("Create", referencesTableName, func() error {
+				return dbDriver.Create(referencesTableName, id, string(value))
+			})
+		}
+		if err != nil {
+			return util.Errorf("cannot record reference %s: %w", id, err)
+		}
+	}
+	for id, reference := range stored {
+		if _, exists := expected[id]; exists || reference.Type != learntType.Name {
+			continue
+		}
+		err = measure("Remove", referencesTableName, func() error {
+			return dbDriver.Remove(referencesTableName, id)
+		})
+		if err != nil {
+			return util.Errorf("cannot forget reference %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// newRemovalPlan loads the referencing types known by this process which are not managed yet,
+// and remembers the references from unknown types, so they can be checked
+func newRemovalPlan() (*removalPlan, error) {
+	plan := &removalPlan{
+		visited:      make(map[reflect.Type]map[string]bool),
+		unmanagedIDs: make(map[storedReference]map[string][]string),
+	}
+	references, err := execReadReferences()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range slices.Sorted(maps.Keys(references)) {
+		reference := references[id]
+		if findManagedType(reference.Type) != nil {
+			continue
+		}
+		if knownType := util.FindLearntType(reference.Type); knownType != nil {
+			if err = execManageType(knownType); err != nil {
+				return nil, util.Errorf("cannot load referencing type %s: %w", reference.Type, err)
+			}
+			continue
+		}
+		plan.unmanaged = append(plan.unmanaged, reference)
+	}
+	return plan, nil
+}
+
+// planRemoval adds to plan the removal of the object and the changes needed in the objects referencing it.
+// Restrictions are checked by checkRestrictions once every cascade is planned
+func (plan *removalPlan) planRemoval(inputType reflect.Type, id string) error {
+	if plan.visited[inputType] == nil {
+		plan.visited[inputType] = make(map[string]bool)
+	}
+	if plan.visited[inputType][id] {
+		return nil
+	}
+	plan.visited[inputType][id] = true
+	plan.removals = append(plan.removals, plannedChange{inputType: inputType, id: id})
+
+	name := learntTypes[inputType].Name
+	if err := plan.checkUnmanaged(name, id); err != nil {
+		return err
+	}
+	for referencingType, learntType := range learntTypes {
+		for field, reference := range learntType.Refs {
+			if reference.Type != name {
+				continue
+			}
+			for referencingId, object := range data[referencingType] {
+				if reflect.ValueOf(object).Elem().FieldByName(field).String() != id {
+					continue
+				}
+				switch reference.OnRemove {
+				case util.RefRestrict:
+					plan.restrictions = append(plan.restrictions, plannedChange{referencingType, referencingId, field, id})
+				case util.RefCascade:
+					err := plan.planRemoval(referencingType, referencingId)
+					if err != nil {
+						return err
+					}
+				case util.RefSetEmpty:
+					plan.setEmpties = append(plan.setEmpties, plannedChange{referencingType, referencingId, field, id})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkUnmanaged refuses the removal if an object of a type unknown by this process references it,
+// because its policy cannot be applied without the type
+func (plan *removalPlan) checkUnmanaged(name string, id string) error {
+	for _, reference := range plan.unmanaged {
+		if reference.Target != name {
+			continue
+		}
+		if plan.unmanagedIDs[reference] == nil {
+			plan.unmanagedIDs[reference] = make(map[string][]string)
+			err := execScanTable(reference.Type, func(referencingId string, value string) error {
+				var object map[string]any
+				if json.Unmarshal([]byte(value), &object) == nil {
+					if target, ok := object[reference.Field].(string); ok && target != "" {
+						plan.unmanagedIDs[reference][target] = append(plan.unmanagedIDs[reference][target], referencingId)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return util.Errorf("cannot check references from %s: %v", reference.Type, err)
+			}
+		}
+		if referencing := plan.unmanagedIDs[reference][id]; len(referencing) > 0 {
+			return util.Errorf("%w: %s is referenced by %s %s, which must be registered before removing it",
+				ErrConflict, id, reference.Type, referencing[0])
+		}
+	}
+	return nil
+}
+
+// checkRestrictions returns an error if any restricted reference is not removed by the plan
+func (plan *removalPlan) checkRestrictions() error {
+	for _, restriction := range plan.restrictions {
+		if !plan.visited[restriction.inputType][restriction.id] {
+			return util.Errorf("%w: %s is referenced by %s %s", ErrConflict, restriction.target,
+				learntTypes[restriction.inputType].Name, restriction.id)
+		}
+	}
+	return nil
+}
+
+// execApplyRemovalPlan performs the changes in the plan, emptying references before removing objects.
+// If a change fails, the applied ones are undone. The error lists the changes which could not be undone
+func execApplyRemovalPlan(plan *removalPlan) (err error) {
+	var applied []appliedChange
+	defer func() {
+		if err != nil && len(applied) > 0 {
+			err = execUndoRemovalPlan(applied, err)
+		}
+	}()
+	for _, change := range plan.setEmpties {
+		if plan.visited[change.inputType][change.id] {
+			continue // the object is going to be removed
+		}
+		old := data[change.inputType][change.id]
+		oldJSON, err := json.Marshal(old)
+		if err != nil {
+			return util.Errorf("cannot convert object to json: %s error: %v", old, err)
+		}
+		object, err := util.CopyEntireObject(old)
+		if err != nil {
+			return err
+		}
+		reflect.ValueOf(object).Elem().FieldByName(change.field).SetString("")
+		objectString, err := json.Marshal(object)
+		if err != nil {
+			return util.Errorf("cannot convert object to json: %s error: %v", object, err)
+		}
+		err = measure("Update", getTableName(change.inputType), func() error {
+			return dbDriver.Update(getTableName(change.inputType), change.id, string(objectString))
+		})
+		if err != nil {
+			return err
+		}
+		applied = append(applied, appliedChange{change, false, old, string(oldJSON)})
+		data[change.inputType][change.id] = object
+		execIndexObject(change.inputType, change.id)
+	}
+	// Referencing objects are removed first
+	for i := len(plan.removals) - 1; i >= 0; i-- {
+		change := plan.removals[i]
+		old := data[change.inputType][change.id]
+		oldJSON, err := json.Marshal(old)
+		if err != nil {
+			return util.Errorf("cannot convert object to json: %s error: %v", old, err)
+		}
+		err = measure("Remove", getTableName(change.inputType), func() error {
+			return dbDriver.Remove(getTableName(change.inputType), change.id)
+		})
+		if err != nil {
+			return err
+		}
+		applied = append(applied, appliedChange{change, true, old, string(oldJSON)})
+		delete(data[change.inputType], change.id)
+		execIndexObject(change.inputType, change.id)
+	}
+	return nil
+}
+
+// execUndoRemovalPlan restores the objects modified or removed by a failed plan, in reverse order
+func execUndoRemovalPlan(applied []appliedChange, cause error) error {
+	var remaining []string
+	for i := len(applied) - 1; i >= 0; i-- {
+		change := applied[i]
+		table := getTableName(change.inputType)
+		var err error
+		if change.removed {
+			err = measure("Create", table, func() error {
+				return dbDriver.Create(table, change.id, change.oldJSON)
+			})
+		} else {
+			err = measure("Update", table, func() error {
+				return dbDriver.Update(table, change.id, change.oldJSON)
+			})
+		}
+		if err != nil {
+			description := fmt.Sprintf("%s %s emptied %s", learntTypes[change.inputType].Name, change.id, change.field)
+			if change.removed {
+				description = fmt.Sprintf("%s %s removed", learntTypes[change.inputType].Name, change.id)
+			}
+			remaining = append(remaining, description)
+			continue
+		}
+		data[change.inputType][change.id] = change.old
+		execIndexObject(change.inputType, change.id)
+	}
+	if len(remaining) > 0 {
+		logger.Error("removal partially applied", "error", cause, "applied", remaining)
+		return util.Errorf("%v. These changes were applied and could not be undone: %s", cause, strings.Join(remaining, ", "))
+	}
+	logger.Warn("removal rolled back", "error", cause)
+	return cause
+}
@@ -0,0 +1,82 @@
+package elephant
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gonimals/elephant/internal/db/kv"
+	"github.com/gonimals/elephant/internal/db/memory"
+	"github.com/gonimals/elephant/internal/db/sql"
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// Driver is the interface implemented by elephant backends
+type Driver = db.Driver
+
+var (
+	drivers      = map[string]db.Factory{}
+	driversMutex sync.RWMutex
+)
+
+func init() {
+	RegisterDriver("sqlite3", builtinFactory(sql.ConnectSqlite3))
+	RegisterDriver("mysql", builtinFactory(sql.ConnectMySQL))
+	RegisterDriver("kv", builtinFactory(kv.Connect))
+	RegisterDriver("memory", builtinFactory(memory.Connect))
+}
+
+// builtinFactory adapts the connect functions of the included drivers, which return concrete types
+func builtinFactory[driverType Driver](connect func(dsn string) (driverType, error)) db.Factory {
+	return func(dsn string) (Driver, error) {
+		output, err := connect(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return output, nil
+	}
+}
+
+// RegisterDriver makes a backend available for uris starting with "scheme:".
+// The factory receives the rest of the uri. If RegisterDriver is called twice
+// with the same scheme or if factory is nil, it panics
+func RegisterDriver(scheme string, factory func(dsn string) (Driver, error)) {
+	driversMutex.Lock()
+	defer driversMutex.Unlock()
+	if factory == nil {
+		panic(util.Errorf("RegisterDriver factory is nil"))
+	}
+	if scheme == "" || strings.Contains(scheme, ":") {
+		panic(util.Errorf("RegisterDriver called with an invalid scheme: %s", scheme))
+	}
+	if _, duplicated := drivers[scheme]; duplicated {
+		panic(util.Errorf("RegisterDriver called twice for scheme %s", scheme))
+	}
+	drivers[scheme] = factory
+}
+
+// Drivers returns a sorted list of the registered schemes
+func Drivers() []string {
+	driversMutex.RLock()
+	defer driversMutex.RUnlock()
+	output := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		output = append(output, scheme)
+	}
+	sort.Strings(output)
+	return output
+}
+
+// OpenDriver creates a driver for the uri using the registered backends,
+// without initializing elephant. The caller must close it
+func OpenDriver(uri string) (Driver, error) {
+	scheme, dsn, found := strings.Cut(uri, ":")
+	driversMutex.RLock()
+	factory := drivers[scheme]
+	driversMutex.RUnlock()
+	if !found || factory == nil {
+		return nil, util.Errorf("unsupported uri string: %s", uri)
+	}
+	return factory(dsn)
+}
@@ -0,0 +1,665 @@
+package elephant
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// Paths served by NewRemoteHandler
+const (
+	remoteCallPath    = "/v2/call"
+	remoteBackupPath  = "/v2/backup"
+	remoteRestorePath = "/v2/restore"
+)
+
+// remoteHello is the call made when connecting, which only checks the token
+const remoteHello = "hello"
+
+// remoteRequest is a call to a remote store. Objects and values are sent in JSON and
+// decoded by the remote store into the types of its fields
+type remoteRequest struct {
+	Action     string            `json:"action"`
+	Type       string            `json:"type,omitempty"`
+	Schema     *remoteSchema     `json:"schema,omitempty"`
+	Id         string            `json:"id,omitempty"`
+	Object     json.RawMessage   `json:"object,omitempty"`
+	Old        json.RawMessage   `json:"old,omitempty"`
+	Attribute  string            `json:"attribute,omitempty"`
+	Value      json.RawMessage   `json:"value,omitempty"`
+	Conditions []remoteCondition `json:"conditions,omitempty"`
+	After      string            `json:"after,omitempty"`
+	Cursor     string            `json:"cursor,omitempty"`
+	Query      string            `json:"query,omitempty"`
+	Limit      int               `json:"limit,omitempty"`
+	Sign       int               `json:"sign,omitempty"`
+	Kind       string            `json:"kind,omitempty"`
+	Strategy   IDStrategy        `json:"strategy,omitempty"`
+	Blob       []byte            `json:"blob,omitempty"`
+}
+
+type remoteCondition struct {
+	Attribute string          `json:"attribute"`
+	Operator  string          `json:"operator"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// remoteResponse is the output of a call. Error and Kind are only set by failed calls
+type remoteResponse struct {
+	Id      string            `json:"id,omitempty"`
+	Object  json.RawMessage   `json:"object,omitempty"`
+	Ids     []string          `json:"ids,omitempty"`
+	Objects []json.RawMessage `json:"objects,omitempty"`
+	Groups  []remoteGroup     `json:"groups,omitempty"`
+	Next    string            `json:"next,omitempty"`
+	Value   json.RawMessage   `json:"value,omitempty"`
+	Exists  bool              `json:"exists,omitempty"`
+	Created bool              `json:"created,omitempty"`
+	Blob    []byte            `json:"blob,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Kind    string            `json:"kind,omitempty"`
+}
+
+type remoteGroup struct {
+	Key     json.RawMessage   `json:"key"`
+	Objects []json.RawMessage `json:"objects"`
+}
+
+// errRemoteModified is returned by the remote store when the object given to Modify
+// changed before writing it, so the client applies the modification again
+var errRemoteModified = errors.New("modified meanwhile")
+
+// remoteErrorKinds are the sentinels kept by the errors of the remote store, with the
+// status answered for them
+var /*const*/ remoteErrorKinds = []struct {
+	name     string
+	sentinel error
+	status   int
+}{
+	{"notFound", ErrNotFound, http.StatusNotFound},
+	{"conflict", ErrConflict, http.StatusConflict},
+	{"invalid", ErrInvalid, http.StatusUnprocessableEntity},
+	{"transient", db.ErrTransient, http.StatusServiceUnavailable},
+	{"modified", errRemoteModified, http.StatusConflict},
+}
+
+// remoteError is an error of the remote store, which keeps its message and sentinel
+type remoteError struct {
+	message  string
+	sentinel error
+}
+
+func (e *remoteError) Error() string {
+	return e.message
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.sentinel
+}
+
+// remoteSchema describes a stored type, so the remote store can build it when the
+// serving program does not know it. Hooks lists the hooks implemented by the type
+type remoteSchema struct {
+	Fields []remoteField `json:"fields"`
+	Hooks  []string      `json:"hooks,omitempty"`
+}
+
+type remoteField struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Tag  string `json:"tag,omitempty"`
+}
+
+// remoteFieldTypes are the types of the fields of the types built by the remote store,
+// by kind. Fields which are not booleans, numbers, strings or times are kept as JSON
+var /*const*/ remoteFieldTypes = map[string]reflect.Type{
+	"bool":    reflect.TypeFor[bool](),
+	"int":     reflect.TypeFor[int](),
+	"int8":    reflect.TypeFor[int8](),
+	"int16":   reflect.TypeFor[int16](),
+	"int32":   reflect.TypeFor[int32](),
+	"int64":   reflect.TypeFor[int64](),
+	"uint":    reflect.TypeFor[uint](),
+	"uint8":   reflect.TypeFor[uint8](),
+	"uint16":  reflect.TypeFor[uint16](),
+	"uint32":  reflect.TypeFor[uint32](),
+	"uint64":  reflect.TypeFor[uint64](),
+	"uintptr": reflect.TypeFor[uintptr](),
+	"float32": reflect.TypeFor[float32](),
+	"float64": reflect.TypeFor[float64](),
+	"string":  reflect.TypeFor[string](),
+	"time":    reflect.TypeFor[time.Time](),
+	"json":    reflect.TypeFor[json.RawMessage](),
+}
+
+// describeType returns the schema of inputType, a pointer to struct
+func describeType(inputType reflect.Type) (*remoteSchema, error) {
+	structType := inputType.Elem()
+	schema := &remoteSchema{Fields: []remoteField{}}
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.Name == util.TypeNameField && structType.Name() == "" {
+			continue
+		}
+		if field.Anonymous {
+			return nil, util.Errorf("%s has embedded fields, which remote stores do not support", inputType.String())
+		}
+		if field.IsExported() {
+			schema.Fields = append(schema.Fields, remoteField{Name: field.Name, Kind: remoteFieldKind(field.Type), Tag: string(field.Tag)})
+		}
+	}
+	if inputType.Implements(reflect.TypeFor[BeforeCreator]()) {
+		schema.Hooks = append(schema.Hooks, "BeforeCreate")
+	}
+	if _, found := inputType.MethodByName("BeforeUpdate"); found {
+		schema.Hooks = append(schema.Hooks, "BeforeUpdate")
+	}
+	if inputType.Implements(reflect.TypeFor[AfterLoader]()) {
+		schema.Hooks = append(schema.Hooks, "AfterLoad")
+	}
+	if inputType.Implements(reflect.TypeFor[Validator]()) {
+		schema.Hooks = append(schema.Hooks, "Validate")
+	}
+	return schema, nil
+}
+
+// remoteFieldKind returns the kind of the remote field storing fieldType
+func remoteFieldKind(fieldType reflect.Type) string {
+	if fieldType == reflect.TypeFor[time.Time]() {
+		return "time"
+	}
+	kind := fieldType.Kind().String()
+	if remoteFieldTypes[kind] == nil {
+		return "json"
+	}
+	for _, encoder := range []reflect.Type{fieldType, reflect.PointerTo(fieldType)} {
+		if encoder.Implements(reflect.TypeFor[json.Marshaler]()) || encoder.Implements(reflect.TypeFor[json.Unmarshaler]()) ||
+			encoder.Implements(reflect.TypeFor[encoding.TextMarshaler]()) || encoder.Implements(reflect.TypeFor[encoding.TextUnmarshaler]()) {
+			return "json"
+		}
+	}
+	return kind
+}
+
+// remoteReads are the actions repeated after transient errors, since they change nothing
+var /*const*/ remoteReads = map[int]bool{
+	actionRetrieve: true, actionRetrieveBy: true, actionRetrieveAll: true, actionExists: true, actionExistsBy: true,
+	actionBlobRetrieve: true, actionBlobExists: true, actionRegister: true, actionFilter: true, actionPage: true,
+	actionPageBy: true, actionCount: true, actionSum: true, actionMinMax: true, actionGroupBy: true, actionSearch: true,
+}
+
+// remoteClient performs the actions in the store served by NewRemoteHandler. It keeps no
+// cache: every call is answered by the remote store. Only the generators of SetIDFunc,
+// which are functions, are kept by the client
+type remoteClient struct {
+	client  *http.Client
+	baseURL string
+	token   string
+	schemas sync.Map // reflect.Type to *remoteSchema
+	mutex   sync.Mutex
+	derived map[reflect.Type]*idGenerator
+}
+
+// connectRemote connects to the store served in address, which is an http(s) URL or
+// unix:/path/to/socket, with the token in its query
+func connectRemote(address string) (*remoteClient, error) {
+	address, query, _ := strings.Cut(address, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, util.Errorf("invalid remote uri: %v", err)
+	}
+	c := &remoteClient{token: values.Get("token"), derived: make(map[reflect.Type]*idGenerator)}
+	if socket, isUnix := strings.CutPrefix(address, "unix:"); isUnix {
+		c.client = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}}
+		c.baseURL = "http://unix"
+	} else if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		c.client = &http.Client{}
+		c.baseURL = strings.TrimSuffix(address, "/")
+	} else {
+		return nil, util.Errorf("unsupported remote address: %s", address)
+	}
+	if _, err = c.call(context.Background(), remoteRequest{Action: remoteHello}, true); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *remoteClient) close() {
+	c.client.CloseIdleConnections()
+}
+
+// execute performs the action in the remote store. Like queue, it returns the error
+// of the context if it is done before starting
+func (c *remoteClient) execute(action *internalAction) (output actionOutput) {
+	queueDepth.Add(-1) // the action is not queued in this process
+	if output.err = action.ctx.Err(); output.err != nil {
+		return
+	}
+	switch action.code {
+	case actionModify:
+		output.data, output.err = c.modify(action)
+	case actionSetIDGenerator:
+		output.err = c.setIDGenerator(action)
+	case actionBackup:
+		output.err = c.backup(action.ctx, action.object[0].(io.Writer))
+	case actionRestore:
+		output.err = c.restore(action.ctx, action.object[0].(io.Reader), action.object[1].(RestoreMode))
+	default:
+		output.data, output.err = c.perform(action)
+	}
+	return
+}
+
+// perform sends the action and decodes its output. Objects without id are created
+// with the id derived by SetIDFunc, if configured, like execNewID
+func (c *remoteClient) perform(action *internalAction) (any, error) {
+	var derivedID string
+	if generator := c.derivedGenerator(action.inputType); generator != nil {
+		switch action.code {
+		case actionNextID:
+			return generator.generate(action.inputType, nil)
+		case actionCreate, actionUpsert:
+			if id, err := util.GetId(action.object[0]); err != nil {
+				return nil, err
+			} else if id == "" {
+				if derivedID, err = generator.generate(action.inputType, action.object[0]); err != nil {
+					return nil, err
+				}
+				util.SetId(action.inputType, action.object[0], derivedID)
+			}
+		}
+	}
+	request, err := c.newRequest(action)
+	if err != nil {
+		return nil, err
+	}
+	if derivedID != "" {
+		request.Action = actionNames[actionCreate]
+	}
+	response, err := c.call(action.ctx, request, remoteReads[action.code])
+	if err != nil {
+		if derivedID != "" {
+			util.SetId(action.inputType, action.object[0], "")
+			if errors.Is(err, ErrConflict) {
+				return nil, util.Errorf("generated id %s is already in use", derivedID)
+			}
+		}
+		return nil, err
+	}
+	if derivedID != "" && action.code == actionUpsert {
+		response.Created = true
+	}
+	return c.decodeOutput(action, response)
+}
+
+// newRequest encodes the arguments of the action, checking them against the type like
+// the local store does
+func (c *remoteClient) newRequest(action *internalAction) (request remoteRequest, err error) {
+	request.Action = actionNames[action.code]
+	var lt *util.LearntType
+	if action.inputType != blobReflectType {
+		if lt, err = util.ExamineType(action.inputType); err != nil {
+			return
+		}
+		request.Type = lt.Name
+		if request.Schema, err = c.schema(action.inputType); err != nil {
+			return
+		}
+	}
+	switch action.code {
+	case actionRetrieve, actionRemoveById, actionExists, actionBlobRetrieve, actionBlobRemove, actionBlobExists:
+		request.Id = action.object[0].(string)
+	case actionRetrieveBy, actionExistsBy:
+		request.Attribute = action.object[0].(string)
+		if err = checkRetrieveBy(lt, request.Attribute, action.object[1]); err == nil {
+			request.Value, err = json.Marshal(action.object[1])
+		}
+	case actionCreate, actionUpdate, actionUpsert, actionRemove:
+		if request.Object, err = json.Marshal(action.object[0]); err != nil {
+			err = util.Errorf("cannot encode the object: %v", err)
+		}
+	case actionBlobCreate, actionBlobUpdate, actionBlobUpsert:
+		request.Id = action.object[0].(string)
+		if contents := action.object[1].(*[]byte); contents != nil {
+			request.Blob = *contents
+		}
+	case actionFilter, actionCount:
+		conditions := action.object[0].([]Condition)
+		if err = checkConditions(lt, conditions); err != nil {
+			return
+		}
+		for _, condition := range conditions {
+			value, _ := json.Marshal(condition.Value) // strings, integers and bools
+			request.Conditions = append(request.Conditions, remoteCondition{Attribute: condition.Attribute,
+				Operator: condition.Operator, Value: value})
+		}
+	case actionPage:
+		request.After, request.Limit = action.object[0].(string), action.object[1].(int)
+	case actionPageBy:
+		request.Attribute, request.Cursor, request.Limit = action.object[0].(string), action.object[1].(string), action.object[2].(int)
+	case actionSum:
+		request.Attribute, request.Kind = action.object[0].(string), action.object[1].(reflect.Type).Kind().String()
+	case actionMinMax:
+		request.Attribute, request.Sign = action.object[0].(string), action.object[1].(int)
+	case actionGroupBy:
+		request.Attribute = action.object[0].(string)
+		keyType := action.object[1].(reflect.Type)
+		if fieldType := lt.Fields[request.Attribute]; fieldType == nil {
+			err = util.Errorf("unknown attribute %s", request.Attribute)
+		} else if fieldType != keyType {
+			err = util.Errorf("cannot group by attribute %s of type %v with keys of type %v", request.Attribute, fieldType, keyType)
+		}
+	case actionSearch:
+		request.Query, request.Limit = action.object[0].(string), action.object[1].(int)
+	}
+	return
+}
+
+// decodeOutput returns the output of the action with the shape returned by execAction
+func (c *remoteClient) decodeOutput(action *internalAction, response remoteResponse) (any, error) {
+	decode := func(object json.RawMessage) (any, error) {
+		return util.LoadObjectFromJson(action.inputType, object)
+	}
+	switch action.code {
+	case actionRetrieve, actionRetrieveBy, actionMinMax:
+		if len(response.Object) == 0 {
+			return nil, nil
+		}
+		return decode(response.Object)
+	case actionRetrieveAll, actionFilter:
+		objects := make(map[string]any, len(response.Ids))
+		for i, id := range response.Ids {
+			object, err := decode(response.Objects[i])
+			if err != nil {
+				return nil, err
+			}
+			objects[id] = object
+		}
+		return objects, nil
+	case actionCreate, actionUpdate, actionUpsert:
+		if err := copyStored(action.object[0], response.Object); err != nil {
+			return nil, err
+		}
+		if action.code == actionUpsert {
+			return upsertOutput{id: response.Id, created: response.Created}, nil
+		}
+		return response.Id, nil
+	case actionExists, actionExistsBy, actionBlobExists:
+		return response.Exists, nil
+	case actionNextID:
+		return response.Id, nil
+	case actionBlobRetrieve:
+		if !response.Exists {
+			return nil, nil
+		}
+		blob := response.Blob
+		if blob == nil {
+			blob = []byte{}
+		}
+		return &blob, nil
+	case actionBlobUpsert:
+		return response.Created, nil
+	case actionCount, actionSum:
+		resultType := reflect.TypeFor[int]()
+		if action.code == actionSum {
+			resultType = action.object[1].(reflect.Type)
+		}
+		result := reflect.New(resultType)
+		if err := json.Unmarshal(response.Value, result.Interface()); err != nil {
+			return nil, util.Errorf("cannot decode the result: %v", err)
+		}
+		return result.Elem().Interface(), nil
+	case actionPage, actionPageBy:
+		page := pageOutput{ids: response.Ids, next: response.Next}
+		for _, encoded := range response.Objects {
+			object, err := decode(encoded)
+			if err != nil {
+				return nil, err
+			}
+			page.objects = append(page.objects, object)
+		}
+		return page, nil
+	case actionGroupBy:
+		keyType := action.object[1].(reflect.Type)
+		groups := make(map[any][]any, len(response.Groups))
+		for _, group := range response.Groups {
+			key := reflect.New(keyType)
+			if err := json.Unmarshal(group.Key, key.Interface()); err != nil {
+				return nil, util.Errorf("cannot decode the key: %v", err)
+			}
+			for _, encoded := range group.Objects {
+				object, err := decode(encoded)
+				if err != nil {
+					return nil, err
+				}
+				groups[key.Elem().Interface()] = append(groups[key.Elem().Interface()], object)
+			}
+		}
+		return groups, nil
+	case actionSearch:
+		results := make([]any, 0, len(response.Objects))
+		for _, encoded := range response.Objects {
+			object, err := decode(encoded)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, object)
+		}
+		return results, nil
+	}
+	return nil, nil
+}
+
+// copyStored copies the stored object into object, which gets the id, timestamps and
+// changes made by the hooks of the remote store
+func copyStored(object any, stored json.RawMessage) error {
+	storedObject, err := util.LoadObjectFromJson(reflect.TypeOf(object), stored)
+	if err != nil {
+		return err
+	}
+	target, source := reflect.ValueOf(object).Elem(), reflect.ValueOf(storedObject).Elem()
+	for i := 0; i < target.NumField(); i++ {
+		if field := target.Type().Field(i); field.IsExported() && util.JSONName(field) != "-" {
+			target.Field(i).Set(source.Field(i))
+		}
+	}
+	return nil
+}
+
+// modify retrieves the object, applies the modification and writes it only if it was not
+// changed meanwhile, starting again otherwise. The modification can be applied several times
+func (c *remoteClient) modify(action *internalAction) (any, error) {
+	id := action.object[0].(string)
+	modify := action.object[1].(func(any) error)
+	request, err := c.newRequest(action)
+	if err != nil {
+		return nil, err
+	}
+	request.Id = id
+	for {
+		retrieve := request
+		retrieve.Action = actionNames[actionRetrieve]
+		response, err := c.call(action.ctx, retrieve, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(response.Object) == 0 {
+			return nil, util.Errorf("%w: trying to modify unexistent object", ErrNotFound)
+		}
+		object, err := util.LoadObjectFromJson(action.inputType, response.Object)
+		if err != nil {
+			return nil, err
+		}
+		if err = modify(object); err != nil {
+			return nil, err
+		}
+		if modifiedID, err := util.GetId(object); err != nil {
+			return nil, err
+		} else if modifiedID != id {
+			return nil, util.Errorf("%w: the id of the object cannot be modified", ErrInvalid)
+		}
+		write := request
+		write.Old = response.Object
+		if write.Object, err = json.Marshal(object); err != nil {
+			return nil, util.Errorf("cannot encode the object: %v", err)
+		}
+		response, err = c.call(action.ctx, write, false)
+		if errors.Is(err, errRemoteModified) && action.ctx.Err() == nil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return util.LoadObjectFromJson(action.inputType, response.Object)
+	}
+}
+
+// setIDGenerator sends the strategy to the remote store, or keeps the generator of
+// SetIDFunc in the client, which is the only one having the function
+func (c *remoteClient) setIDGenerator(action *internalAction) error {
+	generator := action.object[0].(*idGenerator)
+	if generator.strategy == idDerived {
+		if _, err := util.ExamineType(action.inputType); err != nil {
+			return err
+		}
+	} else {
+		request, err := c.newRequest(action)
+		if err != nil {
+			return err
+		}
+		request.Strategy = generator.strategy
+		if _, err = c.call(action.ctx, request, false); err != nil {
+			return err
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generator.strategy == idDerived {
+		c.derived[action.inputType] = generator
+	} else {
+		delete(c.derived, action.inputType)
+	}
+	return nil
+}
+
+// derivedGenerator returns the generator of SetIDFunc configured for inputType, if any
+func (c *remoteClient) derivedGenerator(inputType reflect.Type) *idGenerator {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.derived[inputType]
+}
+
+// schema returns the description of inputType sent with its calls
+func (c *remoteClient) schema(inputType reflect.Type) (*remoteSchema, error) {
+	if schema, known := c.schemas.Load(inputType); known {
+		return schema.(*remoteSchema), nil
+	}
+	schema, err := describeType(inputType)
+	if err != nil {
+		return nil, err
+	}
+	c.schemas.Store(inputType, schema)
+	return schema, nil
+}
+
+func (c *remoteClient) backup(ctx context.Context, w io.Writer) error {
+	response, err := c.post(ctx, remoteBackupPath, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if _, err = io.Copy(w, response.Body); err != nil {
+		return util.Errorf("cannot copy the backup: %v", err)
+	}
+	return nil
+}
+
+func (c *remoteClient) restore(ctx context.Context, r io.Reader, mode RestoreMode) error {
+	response, err := c.post(ctx, remoteRestorePath+"?mode="+strconv.Itoa(int(mode)), "application/x-ndjson", r)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// call sends the request to the remote store, repeating it after transient errors if retry is set
+func (c *remoteClient) call(ctx context.Context, request remoteRequest, retry bool) (response remoteResponse, err error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, util.Errorf("cannot encode the call: %v", err)
+	}
+	policy := db.RetryPolicy{Attempts: 1}
+	if retry {
+		policy = db.DefaultRetryPolicy
+	}
+	err = policy.Do(func() error {
+		httpResponse, err := c.post(ctx, remoteCallPath, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer httpResponse.Body.Close()
+		response = remoteResponse{}
+		if err = json.NewDecoder(httpResponse.Body).Decode(&response); err != nil {
+			return util.Errorf("%w: cannot read the response of the remote store: %v", db.ErrTransient, err)
+		}
+		return nil
+	})
+	return
+}
+
+// post sends body to path, returning the response if its status is OK and the error
+// of the remote store otherwise
+func (c *remoteClient) post(ctx context.Context, path string, contentType string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, body)
+	if err != nil {
+		return nil, util.Errorf("cannot build the request: %v", err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, util.Errorf("%w: cannot reach the remote store: %v", db.ErrTransient, err)
+	}
+	if response.StatusCode == http.StatusOK {
+		return response, nil
+	}
+	defer response.Body.Close()
+	var output remoteResponse
+	if err = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&output); err != nil || output.Error == "" {
+		if response.StatusCode >= http.StatusInternalServerError {
+			return nil, util.Errorf("%w: the remote store answered %s", db.ErrTransient, response.Status)
+		}
+		return nil, util.Errorf("the remote store answered %s", response.Status)
+	}
+	remoteErr := &remoteError{message: output.Error}
+	for _, kind := range remoteErrorKinds {
+		if kind.name == output.Kind {
+			remoteErr.sentinel = kind.sentinel
+		}
+	}
+	return nil, remoteErr
+}
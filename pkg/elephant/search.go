@@ -0,0 +1,142 @@
+package elephant
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/gonimals/elephant/internal/util"
+)
+
+// BM25 parameters used to rank search results
+const (
+	searchK1 = 1.2
+	searchB  = 0.75
+)
+
+// searchIndex is an inverted index over the fields tagged with `db:"search"`
+type searchIndex struct {
+	postings    map[string]map[string]int // term -> id -> frequency
+	terms       map[string][]string       // id -> indexed terms, to remove them
+	lengths     map[string]int            // id -> number of terms
+	totalLength int
+}
+
+// Search gets up to limit elements of a specific type matching any word of the query in
+// their fields tagged with `db:"search"`, best matches first. Words are compared in lower case
+func Search[inputType any](query string, limit int) ([]*inputType, error) {
+	return SearchContext[inputType](context.Background(), query, limit)
+}
+
+// SearchContext is like Search, tracing the call as part of ctx and abandoning it if ctx is done while it is queued
+func SearchContext[inputType any](ctx context.Context, query string, limit int) ([]*inputType, error) {
+	checkInitialization()
+	action := newInternalAction(actionSearch, reflect.TypeFor[*inputType](), query, limit)
+	action.ctx = ctx
+	output := send(action)
+	if output.err != nil {
+		return nil, output.err
+	}
+	objects := output.data.([]any)
+	result := make([]*inputType, 0, len(objects))
+	for _, object := range objects {
+		result = append(result, object.(*inputType))
+	}
+	return result, nil
+}
+
+// tokenize splits the text in lower case words made of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// execIndexObject updates the search index with the cached object, removing it if it is not cached
+func execIndexObject(inputType reflect.Type, id string) {
+	fields := learntTypes[inputType].Search
+	if len(fields) == 0 {
+		return
+	}
+	index := searchIndexes[inputType]
+	if index == nil {
+		index = &searchIndex{
+			postings: make(map[string]map[string]int),
+			terms:    make(map[string][]string),
+			lengths:  make(map[string]int),
+		}
+		searchIndexes[inputType] = index
+	}
+	for _, term := range index.terms[id] {
+		delete(index.postings[term], id)
+		if len(index.postings[term]) == 0 {
+			delete(index.postings, term)
+		}
+	}
+	index.totalLength -= index.lengths[id]
+	delete(index.terms, id)
+	delete(index.lengths, id)
+
+	object, exists := data[inputType][id]
+	if !exists {
+		return
+	}
+	for _, field := range fields {
+		for _, term := range tokenize(reflect.ValueOf(object).Elem().FieldByName(field).String()) {
+			if index.postings[term] == nil {
+				index.postings[term] = make(map[string]int)
+			}
+			if index.postings[term][id] == 0 {
+				index.terms[id] = append(index.terms[id], term)
+			}
+			index.postings[term][id]++
+			index.lengths[id]++
+			index.totalLength++
+		}
+	}
+}
+
+// execSearch ranks the indexed objects with BM25
+func execSearch(inputType reflect.Type, query string, limit int) (output any, err error) {
+	if len(learntTypes[inputType].Search) == 0 {
+		return nil, util.Errorf("%s has no parameters with the annotation `db:\"search\"`", learntTypes[inputType].Name)
+	}
+	if limit <= 0 {
+		return nil, util.Errorf("invalid search limit: %d", limit)
+	}
+	index := searchIndexes[inputType]
+	results := []any{}
+	if index == nil || len(index.lengths) == 0 {
+		return results, nil
+	}
+	documents := float64(len(index.lengths))
+	averageLength := float64(index.totalLength) / documents
+	scores := make(map[string]float64)
+	for _, term := range slices.Compact(slices.Sorted(slices.Values(tokenize(query)))) {
+		postings := index.postings[term]
+		idf := math.Log(1 + (documents-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for id, frequency := range postings {
+			tf := float64(frequency)
+			norm := 1 - searchB + searchB*float64(index.lengths[id])/averageLength
+			scores[id] += idf * tf * (searchK1 + 1) / (tf + searchK1*norm)
+		}
+	}
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(first, second string) int {
+		if comparison := cmp.Compare(scores[second], scores[first]); comparison != 0 {
+			return comparison
+		}
+		return strings.Compare(first, second)
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return execRetrieveIDs(inputType, ids)
+}
@@ -0,0 +1,420 @@
+package elephant
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"go/token"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+)
+
+// maxRemoteCallLength bounds the calls, which carry up to two objects or one blob in base64
+const maxRemoteCallLength = 2*db.MaxBlobLength + 2*db.MaxValueLength + 64*1024
+
+// remoteActionCodes are the actions accepted in remoteCallPath, by name
+var /*const*/ remoteActionCodes = func() map[string]int {
+	codes := make(map[string]int)
+	for code, name := range actionNames {
+		if code != actionBackup && code != actionRestore {
+			codes[name] = code
+		}
+	}
+	return codes
+}()
+
+// remoteType is a type used by the clients, with the description they must send
+type remoteType struct {
+	inputType reflect.Type
+	schema    string
+}
+
+type remoteHandler struct {
+	token string
+	mutex sync.Mutex
+	types map[string]remoteType
+}
+
+// NewRemoteHandler returns the handler serving the store initialized in this process to
+// the programs initialized with a remote: uri. Their calls run in the action loop of this
+// process, so they share its cache, hooks and reference checks. Types registered in this
+// program are used as they are. The rest are built from the fields described by the
+// clients, so their hooks cannot run: they are rejected if they have any. Requests must
+// carry token as a bearer token, unless it is empty
+func NewRemoteHandler(token string) http.Handler {
+	return &remoteHandler{token: token, types: make(map[string]remoteType)}
+}
+
+func (h *remoteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+h.token)) != 1 {
+		writeRemoteError(w, http.StatusUnauthorized, util.Errorf("invalid token"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeRemoteError(w, http.StatusMethodNotAllowed, util.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if dbDriver == nil {
+		writeRemoteError(w, 0, util.Errorf("%w: the store is not initialized", db.ErrTransient))
+		return
+	}
+	switch r.URL.Path {
+	case remoteCallPath:
+		h.call(w, r)
+	case remoteBackupPath:
+		h.backup(w, r)
+	case remoteRestorePath:
+		h.restore(w, r)
+	default:
+		writeRemoteError(w, http.StatusNotFound, util.Errorf("unknown path %s", r.URL.Path))
+	}
+}
+
+func (h *remoteHandler) call(w http.ResponseWriter, r *http.Request) {
+	var request remoteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRemoteCallLength)).Decode(&request); err != nil {
+		writeRemoteError(w, http.StatusBadRequest, util.Errorf("%w: cannot read the call: %v", ErrInvalid, err))
+		return
+	}
+	if request.Action == remoteHello {
+		writeRemoteResponse(w, http.StatusOK, remoteResponse{})
+		return
+	}
+	code, known := remoteActionCodes[request.Action]
+	if !known {
+		writeRemoteError(w, http.StatusBadRequest, util.Errorf("%w: unknown action %s", ErrInvalid, request.Action))
+		return
+	}
+	inputType := blobReflectType
+	if code < actionBlobRetrieve || code > actionBlobExists {
+		var err error
+		if inputType, err = h.resolveType(request.Type, request.Schema); err != nil {
+			writeRemoteError(w, 0, err)
+			return
+		}
+	}
+	action, err := newRemoteAction(code, inputType, request)
+	if err != nil {
+		writeRemoteError(w, http.StatusBadRequest, err)
+		return
+	}
+	action.ctx = r.Context()
+	output := dispatch(action)
+	if output.err != nil {
+		writeRemoteError(w, 0, output.err)
+		return
+	}
+	response, err := encodeRemoteOutput(action, output.data)
+	if err != nil {
+		writeRemoteError(w, 0, err)
+		return
+	}
+	writeRemoteResponse(w, http.StatusOK, response)
+}
+
+// resolveType returns the type named name, checking that the client describes it like
+// the first one using it. Unknown types are built from their description
+func (h *remoteHandler) resolveType(name string, schema *remoteSchema) (reflect.Type, error) {
+	if schema == nil {
+		return nil, util.Errorf("%w: the description of %s is missing", ErrInvalid, name)
+	}
+	description, err := json.Marshal(schema)
+	if err != nil {
+		return nil, util.Errorf("cannot encode the description of %s: %v", name, err)
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	known, exists := h.types[name]
+	if !exists {
+		if known.inputType = util.FindLearntType(name); known.inputType != nil {
+			served, err := describeType(known.inputType)
+			if err != nil {
+				return nil, err
+			}
+			servedDescription, _ := json.Marshal(served)
+			known.schema = string(servedDescription)
+		} else if known.inputType, err = buildRemoteType(name, schema); err != nil {
+			return nil, err
+		} else {
+			known.schema = string(description)
+		}
+		h.types[name] = known
+	}
+	if known.schema != string(description) {
+		return nil, util.Errorf("%w: %s is described differently by the client and the remote store", ErrInvalid, name)
+	}
+	return known.inputType, nil
+}
+
+// buildRemoteType builds the type described by schema, without hooks
+func buildRemoteType(name string, schema *remoteSchema) (reflect.Type, error) {
+	if !util.IsAlphanumeric(name) {
+		return nil, util.Errorf("%w: invalid type name %s", ErrInvalid, name)
+	}
+	if len(schema.Hooks) > 0 {
+		return nil, util.Errorf("%w: %s has hooks (%v), which only run if the type is registered in the program serving the store",
+			ErrInvalid, name, schema.Hooks)
+	}
+	fields := []reflect.StructField{{
+		Name: util.TypeNameField,
+		Type: reflect.TypeFor[struct{}](),
+		Tag:  reflect.StructTag(`json:"-" elephant:"` + name + `"`),
+	}}
+	seen := map[string]bool{util.TypeNameField: true}
+	for _, field := range schema.Fields {
+		fieldType := remoteFieldTypes[field.Kind]
+		if fieldType == nil || !token.IsIdentifier(field.Name) || !token.IsExported(field.Name) || seen[field.Name] {
+			return nil, util.Errorf("%w: invalid field %s in %s", ErrInvalid, field.Name, name)
+		}
+		seen[field.Name] = true
+		fields = append(fields, reflect.StructField{Name: field.Name, Type: fieldType, Tag: reflect.StructTag(field.Tag)})
+	}
+	inputType := reflect.PointerTo(reflect.StructOf(fields))
+	if _, err := util.ExamineType(inputType); err != nil {
+		return nil, util.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return inputType, nil
+}
+
+// newRemoteAction decodes the arguments of the action into the types of inputType
+func newRemoteAction(code int, inputType reflect.Type, request remoteRequest) (*internalAction, error) {
+	var lt *util.LearntType
+	if inputType != blobReflectType {
+		lt, _ = util.ExamineType(inputType) // examined by resolveType
+	}
+	decodeObject := func(encoded json.RawMessage) (any, error) {
+		if len(encoded) == 0 {
+			return nil, util.Errorf("%w: the object is missing", ErrInvalid)
+		}
+		object, err := util.LoadObjectFromJson(inputType, encoded)
+		if err != nil {
+			return nil, util.Errorf("%w: %w", ErrInvalid, err)
+		}
+		return object, nil
+	}
+	// decodeValue returns nil for unknown attributes, which are rejected by the action
+	decodeValue := func(attribute string, encoded json.RawMessage) (any, error) {
+		fieldType := lt.Fields[attribute]
+		if fieldType == nil {
+			return nil, nil
+		}
+		value := reflect.New(fieldType)
+		if err := json.Unmarshal(encoded, value.Interface()); err != nil {
+			return nil, util.Errorf("%w: invalid value for %s: %v", ErrInvalid, attribute, err)
+		}
+		return value.Elem().Interface(), nil
+	}
+	var args []any
+	switch code {
+	case actionRetrieve, actionRemoveById, actionExists, actionBlobRetrieve, actionBlobRemove, actionBlobExists:
+		args = []any{request.Id}
+	case actionRetrieveAll:
+		// execRetrieveAll returns the cache itself, which cannot be read outside mainRoutine
+		code, args = actionFilter, []any{[]Condition(nil)}
+	case actionRetrieveBy, actionExistsBy:
+		value, err := decodeValue(request.Attribute, request.Value)
+		if err != nil {
+			return nil, err
+		}
+		args = []any{request.Attribute, value}
+	case actionCreate, actionUpdate, actionUpsert, actionRemove:
+		object, err := decodeObject(request.Object)
+		if err != nil {
+			return nil, err
+		}
+		args = []any{object}
+	case actionModify:
+		object, err := decodeObject(request.Object)
+		if err != nil {
+			return nil, err
+		}
+		old, err := decodeObject(request.Old)
+		if err != nil {
+			return nil, err
+		}
+		oldEncoded, _ := json.Marshal(old)
+		args = []any{request.Id, func(stored any) error {
+			if storedEncoded, _ := json.Marshal(stored); !bytes.Equal(storedEncoded, oldEncoded) {
+				return util.Errorf("%w: %s changed while modifying it", errRemoteModified, request.Id)
+			}
+			reflect.ValueOf(stored).Elem().Set(reflect.ValueOf(object).Elem())
+			return nil
+		}}
+	case actionNextID, actionRegister:
+	case actionBlobCreate, actionBlobUpdate, actionBlobUpsert:
+		blob := request.Blob
+		if blob == nil {
+			blob = []byte{}
+		}
+		args = []any{request.Id, &blob}
+	case actionSetIDGenerator:
+		generator, err := newIDGenerator(request.Strategy)
+		if err != nil {
+			return nil, util.Errorf("%w: %w", ErrInvalid, err)
+		}
+		args = []any{generator}
+	case actionFilter, actionCount:
+		conditions := make([]Condition, 0, len(request.Conditions))
+		for _, condition := range request.Conditions {
+			value, err := decodeValue(condition.Attribute, condition.Value)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, Condition{Attribute: condition.Attribute, Operator: condition.Operator, Value: value})
+		}
+		args = []any{conditions}
+	case actionPage:
+		args = []any{request.After, request.Limit}
+	case actionPageBy:
+		args = []any{request.Attribute, request.Cursor, request.Limit}
+	case actionSum:
+		resultType := remoteFieldTypes[request.Kind]
+		if resultType == nil || !slices.Contains(numericKinds, resultType.Kind()) {
+			return nil, util.Errorf("%w: cannot sum into %s", ErrInvalid, request.Kind)
+		}
+		args = []any{request.Attribute, resultType}
+	case actionMinMax:
+		if request.Sign != 1 && request.Sign != -1 {
+			return nil, util.Errorf("%w: invalid sign %d", ErrInvalid, request.Sign)
+		}
+		args = []any{request.Attribute, request.Sign}
+	case actionGroupBy:
+		keyType := lt.Fields[request.Attribute]
+		if keyType == nil {
+			return nil, util.Errorf("%w: unknown attribute %s", ErrInvalid, request.Attribute)
+		}
+		args = []any{request.Attribute, keyType}
+	case actionSearch:
+		args = []any{request.Query, request.Limit}
+	}
+	return newInternalAction(code, inputType, args...), nil
+}
+
+// encodeRemoteOutput encodes the output of the action, given by execAction
+func encodeRemoteOutput(action *internalAction, data any) (response remoteResponse, err error) {
+	encodeAll := func(objects []any) ([]json.RawMessage, error) {
+		output := make([]json.RawMessage, 0, len(objects))
+		for _, object := range objects {
+			encoded, err := json.Marshal(object)
+			if err != nil {
+				return nil, util.Errorf("cannot encode the object: %v", err)
+			}
+			output = append(output, encoded)
+		}
+		return output, nil
+	}
+	switch action.code {
+	case actionRetrieve, actionRetrieveBy, actionMinMax, actionModify:
+		if data != nil {
+			response.Object, err = json.Marshal(data)
+		}
+	case actionFilter:
+		var objects []any
+		for id, object := range data.(map[string]any) {
+			response.Ids = append(response.Ids, id)
+			objects = append(objects, object)
+		}
+		response.Objects, err = encodeAll(objects)
+	case actionCreate, actionUpdate:
+		response.Id = data.(string)
+		response.Object, err = json.Marshal(action.object[0])
+	case actionUpsert:
+		response.Id, response.Created = data.(upsertOutput).id, data.(upsertOutput).created
+		response.Object, err = json.Marshal(action.object[0])
+	case actionExists, actionExistsBy, actionBlobExists:
+		response.Exists = data.(bool)
+	case actionNextID:
+		response.Id = data.(string)
+	case actionBlobRetrieve:
+		if blob, _ := data.(*[]byte); blob != nil {
+			response.Exists, response.Blob = true, *blob
+		}
+	case actionBlobUpsert:
+		response.Created = data.(bool)
+	case actionCount, actionSum:
+		response.Value, err = json.Marshal(data)
+	case actionPage, actionPageBy:
+		page := data.(pageOutput)
+		response.Ids, response.Next = page.ids, page.next
+		response.Objects, err = encodeAll(page.objects)
+	case actionGroupBy:
+		for key, objects := range data.(map[any][]any) {
+			group := remoteGroup{}
+			if group.Key, err = json.Marshal(key); err != nil {
+				return
+			}
+			if group.Objects, err = encodeAll(objects); err != nil {
+				return
+			}
+			response.Groups = append(response.Groups, group)
+		}
+	case actionSearch:
+		response.Objects, err = encodeAll(data.([]any))
+	}
+	return
+}
+
+func (h *remoteHandler) backup(w http.ResponseWriter, r *http.Request) {
+	var buffer bytes.Buffer
+	action := newInternalAction(actionBackup, blobReflectType, &buffer)
+	action.ctx = r.Context()
+	if output := dispatch(action); output.err != nil {
+		writeRemoteError(w, 0, output.err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+	w.Write(buffer.Bytes())
+}
+
+func (h *remoteHandler) restore(w http.ResponseWriter, r *http.Request) {
+	mode, err := strconv.Atoi(r.URL.Query().Get("mode"))
+	if err != nil || (RestoreMode(mode) != RestoreReplace && RestoreMode(mode) != RestoreMerge) {
+		writeRemoteError(w, http.StatusBadRequest, util.Errorf("%w: unknown restore mode: %s", ErrInvalid, r.URL.Query().Get("mode")))
+		return
+	}
+	records, err := readBackup(r.Body, dbDriver.GetContextSymbol())
+	if err != nil {
+		writeRemoteError(w, http.StatusBadRequest, err)
+		return
+	}
+	action := newInternalAction(actionRestore, blobReflectType, records, RestoreMode(mode))
+	action.ctx = r.Context()
+	if output := dispatch(action); output.err != nil {
+		writeRemoteError(w, 0, output.err)
+		return
+	}
+	writeRemoteResponse(w, http.StatusOK, remoteResponse{})
+}
+
+// writeRemoteError answers with the error and the kind of its sentinel. The status is
+// taken from the kind when zero
+func writeRemoteError(w http.ResponseWriter, status int, err error) {
+	response := remoteResponse{Error: err.Error()}
+	for _, kind := range remoteErrorKinds {
+		if errors.Is(err, kind.sentinel) {
+			response.Kind = kind.name
+			if status == 0 {
+				status = kind.status
+			}
+			break
+		}
+	}
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	writeRemoteResponse(w, status, response)
+}
+
+func writeRemoteResponse(w http.ResponseWriter, status int, response remoteResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
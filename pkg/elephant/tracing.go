@@ -0,0 +1,125 @@
+package elephant
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/tracing"
+)
+
+var (
+	tracer tracing.Tracer = tracing.Noop{}
+	// currentContext is the context of the action being executed by mainRoutine
+	currentContext = context.Background()
+)
+
+// SetTracer makes elephant open spans for every public call, covering the time waited
+// in the queue, the execution and the driver calls. Nothing is traced by default or
+// when t is nil. It must be called before Initialize
+func SetTracer(t tracing.Tracer) {
+	if t == nil {
+		t = tracing.Noop{}
+	}
+	tracer = t
+}
+
+// send performs the action in the remote store, if any, or in the local one
+func send(action *internalAction) actionOutput {
+	if remote != nil {
+		return trace(action, remote.execute)
+	}
+	return dispatch(action)
+}
+
+// dispatch queues the action and waits for its output, tracing both. If the context of the
+// action is done before mainRoutine starts executing it, the action is abandoned and the
+// error of the context is returned. Started actions are always waited for
+func dispatch(action *internalAction) actionOutput {
+	return trace(action, queue)
+}
+
+// trace opens the span of a call around perform
+func trace(action *internalAction, perform func(*internalAction) actionOutput) actionOutput {
+	attributes := []tracing.Attribute{{Key: tracing.AttributeAction, Value: actionNames[action.code]}}
+	if action.inputType != blobReflectType && action.inputType.Kind() == reflect.Pointer {
+		attributes = append(attributes, tracing.Attribute{Key: tracing.AttributeType, Value: action.inputType.Elem().Name()})
+	}
+	switch action.code {
+	case actionRetrieve, actionRemoveById, actionModify, actionExists, actionBlobRetrieve, actionBlobCreate,
+		actionBlobRemove, actionBlobUpdate, actionBlobUpsert, actionBlobExists:
+		attributes = append(attributes, tracing.Attribute{Key: tracing.AttributeId, Value: action.object[0]})
+	}
+	var span tracing.Span
+	action.ctx, span = tracer.Start(action.ctx, "elephant."+actionNames[action.code], attributes...)
+	action.span = span
+	output := perform(action)
+	if output.err != nil {
+		span.RecordError(output.err)
+	}
+	span.End()
+	return output
+}
+
+// queue hands the action to mainRoutine and waits for its output
+func queue(action *internalAction) (output actionOutput) {
+	_, action.queueSpan = tracer.Start(action.ctx, tracing.SpanQueue)
+	select {
+	case channel <- action:
+		select {
+		case output = <-action.output:
+		case <-action.ctx.Done():
+			if action.state.CompareAndSwap(actionQueued, actionCanceled) {
+				output = cancelQueued(action)
+			} else {
+				output = <-action.output
+			}
+		}
+	case <-action.ctx.Done():
+		queueDepth.Add(-1)
+		output = cancelQueued(action)
+	}
+	return
+}
+
+// cancelQueued ends the queue span of an action abandoned before its execution
+func cancelQueued(action *internalAction) actionOutput {
+	action.queueSpan.End()
+	return actionOutput{err: action.ctx.Err()}
+}
+
+// startQueued marks the action as started. It returns false if the action was abandoned
+func startQueued(action *internalAction) bool {
+	return action.state.CompareAndSwap(actionQueued, actionStarted)
+}
+
+// startExecution ends the queue span of the action and opens its execution span
+func startExecution(action *internalAction) tracing.Span {
+	action.queueSpan.End()
+	ctx, span := tracer.Start(action.ctx, tracing.SpanExecute)
+	currentContext = ctx
+	return span
+}
+
+// endExecution ends the execution span, adding the id of the objects written
+func endExecution(action *internalAction, span tracing.Span, output actionOutput) {
+	switch action.code {
+	case actionCreate, actionUpdate, actionUpsert, actionRemove:
+		if id, err := util.GetId(action.object[0]); err == nil && id != "" {
+			action.span.SetAttributes(tracing.Attribute{Key: tracing.AttributeId, Value: id})
+		}
+	}
+	if output.err != nil {
+		span.RecordError(output.err)
+	}
+	span.End()
+	currentContext = context.Background()
+}
+
+// traceDriver opens a span for a driver call inside the action being executed
+func traceDriver(operation string, table string) tracing.Span {
+	_, span := tracer.Start(currentContext, tracing.SpanDriver,
+		tracing.Attribute{Key: tracing.AttributeOperation, Value: operation},
+		tracing.Attribute{Key: tracing.AttributeTable, Value: table})
+	return span
+}
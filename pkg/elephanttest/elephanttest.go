@@ -0,0 +1,204 @@
+// Package elephanttest contains reusable test suites to check elephant stores
+// with any configuration. db.Driver implementations are checked with the dbtest package
+package elephanttest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/elephant"
+)
+
+// concurrentWorkers and concurrentOperations decide the load of the concurrency checks
+const (
+	concurrentWorkers    = 8
+	concurrentOperations = 25
+)
+
+// StoreCheck is the type stored by TestStore
+type StoreCheck struct {
+	Id    string `db:"id"`
+	Name  string
+	Count int
+}
+
+// TestStore checks elephant initialized with uri. reset must leave the
+// store empty and is called before every check. It can be nil if the uri
+// gives an empty store on the first initialization and is not reused
+func TestStore(t *testing.T, uri string, reset func()) {
+	withStore := func(check func(t *testing.T)) func(t *testing.T) {
+		return func(t *testing.T) {
+			if reset != nil {
+				reset()
+			}
+			if err := elephant.Initialize(uri); err != nil {
+				t.Fatal("initialization failed:", err)
+			}
+			defer elephant.Close()
+			check(t)
+		}
+	}
+	t.Run("CRUD", withStore(testStoreCRUD))
+	t.Run("Blobs", withStore(testStoreBlobs))
+	t.Run("Limits", withStore(testStoreLimits))
+	t.Run("Errors", withStore(testStoreErrors))
+	t.Run("Concurrency", withStore(testStoreConcurrency))
+	t.Run("Persistence", withStore(func(t *testing.T) {
+		if _, err := elephant.Create(&StoreCheck{Id: "1", Name: "persisted"}); err != nil {
+			t.Error("creation failed:", err)
+		}
+		if err := elephant.BlobCreate("persistence", &[]byte{0x01}); err != nil {
+			t.Error("blob creation failed:", err)
+		}
+		elephant.Close()
+		if err := elephant.Initialize(uri); err != nil {
+			t.Fatal("reinitialization failed:", err)
+		}
+		if retrieved, err := elephant.Retrieve[StoreCheck]("1"); err != nil || retrieved == nil || retrieved.Name != "persisted" {
+			t.Error("object not persisted after reinitialization:", retrieved, err)
+		}
+		if blob, err := elephant.BlobRetrieve("persistence"); err != nil || !util.BlobsEqual(blob, &[]byte{0x01}) {
+			t.Error("blob not persisted after reinitialization:", err)
+		}
+	}))
+}
+
+func testStoreCRUD(t *testing.T) {
+	instance := &StoreCheck{Name: "first", Count: 1}
+	id, err := elephant.Create(instance)
+	if err != nil || id == "" || instance.Id != id {
+		t.Error("creation without id failed:", id, err)
+	}
+	if _, err := elephant.Create(&StoreCheck{Id: "second", Name: "second", Count: 2}); err != nil {
+		t.Error("creation with id failed:", err)
+	}
+	retrieved, err := elephant.Retrieve[StoreCheck](id)
+	if err != nil || retrieved == nil || *retrieved != *instance {
+		t.Error("retrieved object is not the original:", retrieved, err)
+	}
+	if retrieved, err := elephant.RetrieveBy[StoreCheck]("Count", 2); err != nil || retrieved == nil || retrieved.Id != "second" {
+		t.Error("retrieve by attribute failed:", retrieved, err)
+	}
+	instance.Count = 3
+	if err := elephant.Update(instance); err != nil {
+		t.Error("update failed:", err)
+	}
+	if retrieved, err := elephant.Retrieve[StoreCheck](id); err != nil || retrieved == nil || retrieved.Count != 3 {
+		t.Error("retrieved object is not the updated one:", retrieved, err)
+	}
+	if _, err := elephant.Upsert(&StoreCheck{Id: "third"}); err != nil {
+		t.Error("upsert failed:", err)
+	}
+	if all, err := elephant.RetrieveAll[StoreCheck](); err != nil || len(all) != 3 {
+		t.Error("retrieve all failed:", len(all), err)
+	}
+	if err := elephant.RemoveById[StoreCheck]("third"); err != nil {
+		t.Error("removal failed:", err)
+	}
+	if exists, err := elephant.Exists[StoreCheck]("third"); err != nil || exists {
+		t.Error("removed object still exists:", err)
+	}
+}
+
+func testStoreBlobs(t *testing.T) {
+	if err := elephant.BlobCreate("1", &[]byte{0x00}); err != nil {
+		t.Error("blob creation failed:", err)
+	}
+	if retrieved, err := elephant.BlobRetrieve("1"); err != nil || !util.BlobsEqual(retrieved, &[]byte{0x00}) {
+		t.Error("retrieved blob is not the original:", err)
+	}
+	if err := elephant.BlobUpdate("1", &[]byte{0x01}); err != nil {
+		t.Error("blob update failed:", err)
+	}
+	if retrieved, err := elephant.BlobRetrieve("1"); err != nil || !util.BlobsEqual(retrieved, &[]byte{0x01}) {
+		t.Error("retrieved blob is not the updated one:", err)
+	}
+	if retrieved, err := elephant.BlobRetrieve("unexistent"); err != nil || retrieved != nil {
+		t.Error("retrieve of unexistent blob should give nil:", err)
+	}
+	if err := elephant.BlobRemove("1"); err != nil {
+		t.Error("blob removal failed:", err)
+	}
+	if exists, err := elephant.BlobExists("1"); err != nil || exists {
+		t.Error("removed blob still exists:", err)
+	}
+}
+
+func testStoreLimits(t *testing.T) {
+	if _, err := elephant.Create(&StoreCheck{Id: strings.Repeat("A", db.MaxIdLength+1)}); err == nil {
+		t.Error("too long id should fail")
+	}
+	if _, err := elephant.Create(&StoreCheck{Id: "long", Name: strings.Repeat("A", db.MaxValueLength)}); err == nil {
+		t.Error("too long object should fail")
+	}
+	if exists, _ := elephant.Exists[StoreCheck]("long"); exists {
+		t.Error("too long object should not be cached")
+	}
+	longBlob := []byte(strings.Repeat("A", db.MaxBlobLength+1))
+	if err := elephant.BlobCreate("long", &longBlob); err == nil {
+		t.Error("too long blob should fail")
+	}
+}
+
+func testStoreErrors(t *testing.T) {
+	if _, err := elephant.Create(StoreCheck{Id: "1"}); err == nil {
+		t.Error("creation of non pointer should fail")
+	}
+	if _, err := elephant.Create(&StoreCheck{Id: "1"}); err != nil {
+		t.Error("creation failed:", err)
+	}
+	if _, err := elephant.Create(&StoreCheck{Id: "1"}); err == nil {
+		t.Error("repeated creation should fail")
+	}
+	if err := elephant.Update(&StoreCheck{Id: "unexistent"}); err == nil {
+		t.Error("update of unexistent object should fail")
+	}
+	if err := elephant.RemoveById[StoreCheck]("unexistent"); err == nil {
+		t.Error("removal of unexistent object should fail")
+	}
+	if err := elephant.BlobCreate("1", &[]byte{0x00}); err != nil {
+		t.Error("blob creation failed:", err)
+	}
+	if err := elephant.BlobCreate("1", &[]byte{0x00}); err == nil {
+		t.Error("repeated blob creation should fail")
+	}
+	if err := elephant.BlobUpdate("unexistent", &[]byte{0x00}); err == nil {
+		t.Error("update of unexistent blob should fail")
+	}
+	if err := elephant.BlobRemove("unexistent"); err == nil {
+		t.Error("removal of unexistent blob should fail")
+	}
+}
+
+func testStoreConcurrency(t *testing.T) {
+	var waitgroup sync.WaitGroup
+	errs := make(chan error, concurrentWorkers*concurrentOperations)
+	for worker := 0; worker < concurrentWorkers; worker++ {
+		waitgroup.Add(1)
+		go func() {
+			defer waitgroup.Done()
+			for i := 0; i < concurrentOperations; i++ {
+				id, err := elephant.Create(&StoreCheck{Name: "concurrent", Count: worker})
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if retrieved, err := elephant.Retrieve[StoreCheck](id); err != nil || retrieved == nil || retrieved.Count != worker {
+					errs <- fmt.Errorf("unexpected object %v: %v", retrieved, err)
+				}
+			}
+		}()
+	}
+	waitgroup.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error("concurrent operation fails:", err)
+	}
+	if all, err := elephant.RetrieveAll[StoreCheck](); err != nil || len(all) != concurrentWorkers*concurrentOperations {
+		t.Error("concurrent creations are not all stored:", len(all), err)
+	}
+}
@@ -0,0 +1,48 @@
+package elephanttest
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gonimals/elephant/internal/db/memory"
+	"github.com/gonimals/elephant/pkg/elephant"
+)
+
+const sqlite3TestDB = "/tmp/elephanttest.db"
+const kvTestDB = "/tmp/elephanttest.kv"
+const memoryTestDB = "elephanttest"
+
+// remoteServer serves a memory store with elephant.NewRemoteHandler. reset serves an empty one
+func remoteServer(t *testing.T) (uri string, reset func()) {
+	backend, _ := memory.Connect("")
+	elephant.InitializeDriver(backend)
+	server := httptest.NewServer(elephant.NewRemoteHandler("token"))
+	t.Cleanup(func() {
+		server.Close()
+		elephant.Close()
+	})
+	reset = func() {
+		elephant.Close()
+		backend, _ := memory.Connect("")
+		elephant.InitializeDriver(backend)
+	}
+	return "remote:" + server.URL + "?token=token", reset
+}
+
+func TestStoreSqlite3(t *testing.T) {
+	TestStore(t, "sqlite3:"+sqlite3TestDB, func() { os.Remove(sqlite3TestDB) })
+}
+
+func TestStoreKV(t *testing.T) {
+	TestStore(t, "kv:"+kvTestDB, func() { os.Remove(kvTestDB) })
+}
+
+func TestStoreMemory(t *testing.T) {
+	TestStore(t, "memory:"+memoryTestDB, func() { memory.Drop(memoryTestDB) })
+}
+
+func TestStoreRemote(t *testing.T) {
+	uri, reset := remoteServer(t)
+	TestStore(t, uri, reset)
+}
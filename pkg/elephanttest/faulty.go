@@ -0,0 +1,381 @@
+package elephanttest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/elephant"
+	"github.com/gonimals/elephant/pkg/metrics"
+)
+
+// Op identifies a driver method in a Fault
+type Op string
+
+// These are the operations that can be affected by faults
+const (
+	OpRetrieve     Op = "Retrieve"
+	OpRetrieveAll  Op = "RetrieveAll"
+	OpCreate       Op = "Create"
+	OpUpdate       Op = "Update"
+	OpRemove       Op = "Remove"
+	OpBlobRetrieve Op = "BlobRetrieve"
+	OpBlobCreate   Op = "BlobCreate"
+	OpBlobUpdate   Op = "BlobUpdate"
+	OpBlobRemove   Op = "BlobRemove"
+	OpBlobExists   Op = "BlobExists"
+
+	OpScan             Op = "Scan"
+	OpRetrieveIDsAfter Op = "RetrieveIDsAfter"
+	OpTables           Op = "Tables"
+	OpBlobIDs          Op = "BlobIDs"
+	OpCreateIndex      Op = "CreateIndex"
+	OpRetrieveIDsBy    Op = "RetrieveIDsBy"
+	OpRetrieveIDsWhere Op = "RetrieveIDsWhere"
+)
+
+// BlobsTable is the table name used to match blob operations in faults
+const BlobsTable = "blobs"
+
+// ErrInjected is the error returned by faults without a specific error
+var ErrInjected = errors.New("elephanttest: injected fault")
+
+// Fault describes how a FaultyDriver alters the calls matching Op, Table and Id.
+// Empty matching fields match every call
+type Fault struct {
+	Op    Op
+	Table string
+	Id    string
+	// After is the number of matching calls which are not affected before the fault starts
+	After int
+	// Times is the number of calls affected. Zero means every call after the first After ones
+	Times int
+	// Delay is waited before the call is performed
+	Delay time.Duration
+	// Drop makes the call report success without reaching the wrapped driver
+	Drop bool
+	// Err is returned instead of performing the call. If Err, Delay and Drop are
+	// all empty, ErrInjected is returned
+	Err error
+}
+
+type faultState struct {
+	Fault
+	matched int
+}
+
+// FaultyDriver wraps a driver, altering the calls which match the injected faults.
+// It implements every optional interface of the db package, forwarding the calls
+// to the wrapped driver when it implements them too. Otherwise, it behaves as
+// elephant does with drivers without them: tables are scanned with RetrieveAll,
+// indexes are not created, nothing is changed by others and the rest fail
+type FaultyDriver struct {
+	inner  db.Driver
+	mutex  sync.Mutex
+	faults []*faultState
+	calls  map[Op]int
+}
+
+func init() {
+	elephant.RegisterDriver("faulty", func(dsn string) (elephant.Driver, error) {
+		inner, err := elephant.OpenDriver(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return NewFaultyDriver(inner), nil
+	})
+}
+
+// NewFaultyDriver wraps inner. Without faults, every call is forwarded
+func NewFaultyDriver(inner db.Driver) *FaultyDriver {
+	return &FaultyDriver{inner: inner, calls: make(map[Op]int)}
+}
+
+// InitializeFaulty initializes elephant with uri wrapped by a FaultyDriver, and
+// returns it, so faults can be injected. Elephant is closed when the test ends
+func InitializeFaulty(t testing.TB, uri string) *FaultyDriver {
+	t.Helper()
+	inner, err := elephant.OpenDriver(uri)
+	if err != nil {
+		t.Fatal("initialization failed:", err)
+	}
+	output := NewFaultyDriver(inner)
+	elephant.InitializeDriver(output)
+	t.Cleanup(elephant.Close)
+	return output
+}
+
+// Inject adds a fault. Calls matching several faults are altered by the first one injected
+func (f *FaultyDriver) Inject(fault Fault) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.faults = append(f.faults, &faultState{Fault: fault})
+}
+
+// Reset removes every fault and call count
+func (f *FaultyDriver) Reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.faults = nil
+	f.calls = make(map[Op]int)
+}
+
+// Calls returns how many times op has been called
+func (f *FaultyDriver) Calls(op Op) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[op]
+}
+
+// Inner returns the wrapped driver, to check its contents without faults
+func (f *FaultyDriver) Inner() db.Driver {
+	return f.inner
+}
+
+// intercept returns the fault that affects the call, if any, after waiting its delay
+func (f *FaultyDriver) intercept(op Op, table string, id string) *Fault {
+	f.mutex.Lock()
+	f.calls[op]++
+	var selected *Fault
+	for _, fault := range f.faults {
+		if (fault.Op != "" && fault.Op != op) || (fault.Table != "" && fault.Table != table) ||
+			(fault.Id != "" && fault.Id != id) {
+			continue
+		}
+		fault.matched++
+		if fault.matched <= fault.After || (fault.Times > 0 && fault.matched > fault.After+fault.Times) {
+			continue
+		}
+		selected = &fault.Fault
+		break
+	}
+	f.mutex.Unlock()
+	if selected == nil {
+		return nil
+	}
+	time.Sleep(selected.Delay)
+	if selected.Err == nil && !selected.Drop && selected.Delay == 0 {
+		return &Fault{Err: ErrInjected}
+	}
+	if selected.Err == nil && !selected.Drop {
+		return nil // only delayed
+	}
+	return selected
+}
+
+func (f *FaultyDriver) Close() {
+	f.inner.Close()
+}
+
+func (f *FaultyDriver) Retrieve(inputType string, id string) (output string, err error) {
+	if fault := f.intercept(OpRetrieve, inputType, id); fault != nil {
+		return "", fault.Err
+	}
+	return f.inner.Retrieve(inputType, id)
+}
+
+func (f *FaultyDriver) RetrieveAll(inputType string) (output map[string]string, err error) {
+	if fault := f.intercept(OpRetrieveAll, inputType, ""); fault != nil {
+		if fault.Err != nil {
+			return nil, fault.Err
+		}
+		return map[string]string{}, nil
+	}
+	return f.inner.RetrieveAll(inputType)
+}
+
+func (f *FaultyDriver) Create(inputType string, id string, input string) (err error) {
+	if fault := f.intercept(OpCreate, inputType, id); fault != nil {
+		return fault.Err
+	}
+	return f.inner.Create(inputType, id, input)
+}
+
+func (f *FaultyDriver) Update(inputType string, id string, input string) (err error) {
+	if fault := f.intercept(OpUpdate, inputType, id); fault != nil {
+		return fault.Err
+	}
+	return f.inner.Update(inputType, id, input)
+}
+
+func (f *FaultyDriver) Remove(inputType string, id string) (err error) {
+	if fault := f.intercept(OpRemove, inputType, id); fault != nil {
+		return fault.Err
+	}
+	return f.inner.Remove(inputType, id)
+}
+
+func (f *FaultyDriver) GetContextSymbol() string {
+	return f.inner.GetContextSymbol()
+}
+
+func (f *FaultyDriver) BlobRetrieve(id string) (output *[]byte, err error) {
+	if fault := f.intercept(OpBlobRetrieve, BlobsTable, id); fault != nil {
+		if fault.Err != nil {
+			return nil, fault.Err
+		}
+		return nil, fmt.Errorf("elephanttest: dropped blob %s: %w", id, db.ErrNotFound)
+	}
+	return f.inner.BlobRetrieve(id)
+}
+
+func (f *FaultyDriver) BlobCreate(id string, input *[]byte) (err error) {
+	if fault := f.intercept(OpBlobCreate, BlobsTable, id); fault != nil {
+		return fault.Err
+	}
+	return f.inner.BlobCreate(id, input)
+}
+
+func (f *FaultyDriver) BlobUpdate(id string, input *[]byte) (err error) {
+	if fault := f.intercept(OpBlobUpdate, BlobsTable, id); fault != nil {
+		return fault.Err
+	}
+	return f.inner.BlobUpdate(id, input)
+}
+
+func (f *FaultyDriver) BlobRemove(id string) (err error) {
+	if fault := f.intercept(OpBlobRemove, BlobsTable, id); fault != nil {
+		return fault.Err
+	}
+	return f.inner.BlobRemove(id)
+}
+
+func (f *FaultyDriver) BlobExists(id string) (output bool, err error) {
+	if fault := f.intercept(OpBlobExists, BlobsTable, id); fault != nil {
+		return false, fault.Err
+	}
+	return f.inner.BlobExists(id)
+}
+
+// unsupported returns the error of the optional methods the wrapped driver does not implement
+func unsupported(name string) error {
+	return fmt.Errorf("elephanttest: the wrapped driver does not implement db.%s: %w", name, errors.ErrUnsupported)
+}
+
+func (f *FaultyDriver) Scan(inputType string, fn func(id string, value string) error) (err error) {
+	if fault := f.intercept(OpScan, inputType, ""); fault != nil {
+		return fault.Err
+	}
+	if scanner, isScanner := f.inner.(db.Scanner); isScanner {
+		return scanner.Scan(inputType, fn)
+	}
+	retrieved, err := f.inner.RetrieveAll(inputType)
+	if err != nil {
+		return err
+	}
+	for _, id := range slices.Sorted(maps.Keys(retrieved)) {
+		if err = fn(id, retrieved[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FaultyDriver) RetrieveIDsAfter(inputType string, after string, limit int) (output []string, err error) {
+	if fault := f.intercept(OpRetrieveIDsAfter, inputType, after); fault != nil {
+		return nil, fault.Err
+	}
+	if pager, isPager := f.inner.(db.Pager); isPager {
+		return pager.RetrieveIDsAfter(inputType, after, limit)
+	}
+	return nil, unsupported("Pager")
+}
+
+func (f *FaultyDriver) Tables() (output []string, err error) {
+	if fault := f.intercept(OpTables, "", ""); fault != nil {
+		return nil, fault.Err
+	}
+	if lister, isLister := f.inner.(db.Lister); isLister {
+		return lister.Tables()
+	}
+	return nil, unsupported("Lister")
+}
+
+func (f *FaultyDriver) BlobIDs() (output []string, err error) {
+	if fault := f.intercept(OpBlobIDs, BlobsTable, ""); fault != nil {
+		return nil, fault.Err
+	}
+	if lister, isLister := f.inner.(db.Lister); isLister {
+		return lister.BlobIDs()
+	}
+	return nil, unsupported("Lister")
+}
+
+func (f *FaultyDriver) CreateIndex(inputType string, attribute string) (err error) {
+	if fault := f.intercept(OpCreateIndex, inputType, ""); fault != nil {
+		return fault.Err
+	}
+	if indexer, isIndexer := f.inner.(db.Indexer); isIndexer {
+		return indexer.CreateIndex(inputType, attribute)
+	}
+	return nil
+}
+
+func (f *FaultyDriver) RetrieveIDsBy(inputType string, attribute string, value any) (output []string, err error) {
+	if fault := f.intercept(OpRetrieveIDsBy, inputType, ""); fault != nil {
+		return nil, fault.Err
+	}
+	if indexer, isIndexer := f.inner.(db.Indexer); isIndexer {
+		return indexer.RetrieveIDsBy(inputType, attribute, value)
+	}
+	return nil, unsupported("Indexer")
+}
+
+func (f *FaultyDriver) RetrieveIDsWhere(inputType string, conditions []db.Condition) (output []string, err error) {
+	if fault := f.intercept(OpRetrieveIDsWhere, inputType, ""); fault != nil {
+		return nil, fault.Err
+	}
+	if querier, isQuerier := f.inner.(db.Querier); isQuerier {
+		return querier.RetrieveIDsWhere(inputType, conditions)
+	}
+	return nil, unsupported("Querier")
+}
+
+func (f *FaultyDriver) SetLogger(logger *slog.Logger) {
+	if logging, isLogging := f.inner.(db.Logging); isLogging {
+		logging.SetLogger(logger)
+	}
+}
+
+func (f *FaultyDriver) SetMetrics(m metrics.Metrics) {
+	if instrumented, isInstrumented := f.inner.(metrics.Instrumented); isInstrumented {
+		instrumented.SetMetrics(m)
+	}
+}
+
+// AssertConsistent fails the test if the objects of inputType cached by
+// elephant differ from the ones stored by driver
+func AssertConsistent[inputType any](t testing.TB, driver db.Driver) {
+	t.Helper()
+	cached, err := elephant.RetrieveAll[inputType]()
+	if err != nil {
+		t.Error("cannot retrieve cached objects:", err)
+		return
+	}
+	stored, err := driver.RetrieveAll(reflect.TypeFor[inputType]().Name())
+	if err != nil {
+		t.Error("cannot retrieve stored objects:", err)
+		return
+	}
+	if len(cached) != len(stored) {
+		t.Errorf("cache has %d objects but the driver stores %d", len(cached), len(stored))
+	}
+	for id, value := range stored {
+		storedObject := new(inputType)
+		if err := json.Unmarshal([]byte(value), storedObject); err != nil {
+			t.Error("cannot unmarshal stored object", id, err)
+			continue
+		}
+		if !reflect.DeepEqual(cached[id], storedObject) {
+			t.Errorf("object %s is cached as %v but stored as %v", id, cached[id], storedObject)
+		}
+	}
+}
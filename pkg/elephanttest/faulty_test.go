@@ -0,0 +1,134 @@
+package elephanttest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/elephant"
+)
+
+func TestFaultyDriver(t *testing.T) {
+	faulty := InitializeFaulty(t, "memory:")
+
+	if _, err := elephant.Create(&StoreCheck{Id: "1", Name: "original"}); err != nil {
+		t.Error("creation failed:", err)
+	}
+	faulty.Inject(Fault{Op: OpCreate, Table: "StoreCheck", Id: "2"})
+	if _, err := elephant.Create(&StoreCheck{Id: "2"}); !errors.Is(err, ErrInjected) {
+		t.Error("creation should fail with the injected error:", err)
+	}
+	if exists, _ := elephant.Exists[StoreCheck]("2"); exists {
+		t.Error("failed creation should not be cached")
+	}
+
+	expected := errors.New("update failure")
+	faulty.Inject(Fault{Op: OpUpdate, After: 1, Times: 1, Err: expected})
+	if err := elephant.Update(&StoreCheck{Id: "1", Name: "first update"}); err != nil {
+		t.Error("first update should not be affected:", err)
+	}
+	if err := elephant.Update(&StoreCheck{Id: "1", Name: "second update"}); !errors.Is(err, expected) {
+		t.Error("second update should fail with the injected error:", err)
+	}
+	if retrieved, _ := elephant.Retrieve[StoreCheck]("1"); retrieved == nil || retrieved.Name != "first update" {
+		t.Error("failed update should be rolled back in the cache:", retrieved)
+	}
+	if err := elephant.Update(&StoreCheck{Id: "1", Name: "third update"}); err != nil {
+		t.Error("third update should not be affected:", err)
+	}
+
+	faulty.Inject(Fault{Op: OpRemove})
+	if err := elephant.RemoveById[StoreCheck]("1"); err == nil {
+		t.Error("removal should fail")
+	}
+	AssertConsistent[StoreCheck](t, faulty.Inner())
+
+	faulty.Inject(Fault{Op: OpBlobCreate, Delay: 10 * time.Millisecond})
+	start := time.Now()
+	if err := elephant.BlobCreate("1", &[]byte{0x00}); err != nil {
+		t.Error("delayed blob creation failed:", err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Error("blob creation was not delayed")
+	}
+	if faulty.Calls(OpBlobCreate) != 1 {
+		t.Error("calls are not counted:", faulty.Calls(OpBlobCreate))
+	}
+
+	faulty.Reset()
+	faulty.Inject(Fault{Op: OpCreate, Drop: true})
+	if _, err := elephant.Create(&StoreCheck{Id: "3"}); err != nil {
+		t.Error("dropped creation should report success:", err)
+	}
+	if stored, _ := faulty.Inner().Retrieve("StoreCheck", "3"); stored != "" {
+		t.Error("dropped creation should not reach the driver")
+	}
+}
+
+func TestFaultyBlobRetrieve(t *testing.T) {
+	faulty := InitializeFaulty(t, "memory:")
+	if err := elephant.BlobCreate("1", &[]byte{0x01}); err != nil {
+		t.Error("blob creation failed:", err)
+	}
+
+	transient := fmt.Errorf("timeout: %w", db.ErrTransient)
+	faulty.Inject(Fault{Op: OpBlobRetrieve, Err: transient})
+	if blob, err := elephant.BlobRetrieve("1"); !errors.Is(err, db.ErrTransient) || blob != nil {
+		t.Error("transient errors should be returned:", err)
+	}
+	if faulty.Calls(OpBlobRetrieve) != 1 {
+		t.Error("retries are left to the driver:", faulty.Calls(OpBlobRetrieve))
+	}
+	faulty.Reset()
+
+	faulty.Inject(Fault{Op: OpBlobRetrieve})
+	if blob, err := elephant.BlobRetrieve("1"); !errors.Is(err, ErrInjected) || blob != nil {
+		t.Error("driver errors should not look like missing blobs:", err)
+	}
+	if faulty.Calls(OpBlobRetrieve) != 1 {
+		t.Error("non transient errors should not be retried:", faulty.Calls(OpBlobRetrieve))
+	}
+}
+
+func TestFaultyDriverInterfaces(t *testing.T) {
+	os.Remove(sqlite3TestDB)
+	faulty := InitializeFaulty(t, "sqlite3:"+sqlite3TestDB)
+	if _, err := elephant.Create(&StoreCheck{Id: "1"}); err != nil {
+		t.Error("creation failed:", err)
+	}
+	if _, err := faulty.RetrieveIDsAfter("StoreCheck", "", 10); err != nil {
+		t.Error("pager calls should reach the wrapped driver:", err)
+	}
+	if tables, err := faulty.Tables(); err != nil || !slices.Contains(tables, "StoreCheck") {
+		t.Error("lister calls should reach the wrapped driver:", tables, err)
+	}
+	faulty.Inject(Fault{Op: OpTables})
+	if err := elephant.Backup(io.Discard); !errors.Is(err, ErrInjected) {
+		t.Error("faults should affect the optional methods:", err)
+	}
+
+	if _, err := faulty.RetrieveIDsWhere("StoreCheck", nil); err != nil {
+		t.Error("querier calls should reach the wrapped driver:", err)
+	}
+
+	inner, _ := elephant.OpenDriver("memory:")
+	memory := NewFaultyDriver(inner)
+	defer memory.Close()
+	if _, err := memory.RetrieveIDsAfter("StoreCheck", "", 10); !errors.Is(err, errors.ErrUnsupported) {
+		t.Error("optional methods of drivers without them should be unsupported:", err)
+	}
+	scanned := 0
+	memory.Create("StoreCheck", "1", "{}")
+	if err := memory.Scan("StoreCheck", func(string, string) error { scanned++; return nil }); err != nil || scanned != 1 {
+		t.Error("drivers without Scan should be scanned with RetrieveAll:", scanned, err)
+	}
+	memory.Inject(Fault{Op: OpBlobRetrieve, Drop: true})
+	if blob, err := memory.BlobRetrieve("1"); !errors.Is(err, db.ErrNotFound) || blob != nil {
+		t.Error("dropped blob retrievals should not find the blob:", err)
+	}
+}
@@ -0,0 +1,104 @@
+package elephanttest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gonimals/elephant/pkg/tracing"
+)
+
+// RecordedSpan is a span stored by a Recorder
+type RecordedSpan struct {
+	ID         int
+	ParentID   int // 0 for spans without parent
+	Name       string
+	Attributes map[string]any
+	Err        error
+	Start      time.Time
+	End        time.Time // zero while the span is open
+}
+
+// Recorder is a tracing.Tracer which keeps every span in memory, to check them in tests
+type Recorder struct {
+	mutex sync.Mutex
+	spans []*RecordedSpan
+}
+
+type recorderKey struct{}
+
+type recordingSpan struct {
+	recorder *Recorder
+	span     *RecordedSpan
+}
+
+// NewRecorder creates an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Start(ctx context.Context, name string, attributes ...tracing.Attribute) (context.Context, tracing.Span) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	span := &RecordedSpan{ID: len(r.spans) + 1, Name: name, Attributes: map[string]any{}, Start: time.Now()}
+	if parent, isSpan := ctx.Value(recorderKey{}).(*RecordedSpan); isSpan {
+		span.ParentID = parent.ID
+	}
+	for _, attribute := range attributes {
+		span.Attributes[attribute.Key] = attribute.Value
+	}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, recorderKey{}, span), &recordingSpan{r, span}
+}
+
+func (s *recordingSpan) SetAttributes(attributes ...tracing.Attribute) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+	for _, attribute := range attributes {
+		s.span.Attributes[attribute.Key] = attribute.Value
+	}
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+	s.span.Err = err
+}
+
+func (s *recordingSpan) End() {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+	s.span.End = time.Now()
+}
+
+// Spans returns copies of the recorded spans, in the order they were started
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	output := make([]RecordedSpan, len(r.spans))
+	for i, span := range r.spans {
+		output[i] = *span
+		output[i].Attributes = make(map[string]any, len(span.Attributes))
+		for key, value := range span.Attributes {
+			output[i].Attributes[key] = value
+		}
+	}
+	return output
+}
+
+// Children returns the spans whose parent is the span with the given id
+func (r *Recorder) Children(id int) (output []RecordedSpan) {
+	for _, span := range r.Spans() {
+		if span.ParentID == id {
+			output = append(output, span)
+		}
+	}
+	return
+}
+
+// Reset removes every recorded span
+func (r *Recorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.spans = nil
+}
@@ -0,0 +1,129 @@
+package elephanttest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gonimals/elephant/pkg/elephant"
+	"github.com/gonimals/elephant/pkg/tracing"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder()
+	elephant.SetTracer(recorder)
+	defer elephant.SetTracer(nil)
+	if err := elephant.Initialize("memory:"); err != nil {
+		t.Fatal("initialization failed:", err)
+	}
+	defer elephant.Close()
+	elephant.Register[StoreCheck]()
+	recorder.Reset()
+
+	ctx, request := recorder.Start(context.Background(), "request")
+	if _, err := elephant.CreateContext(ctx, &StoreCheck{Id: "1"}); err != nil {
+		t.Error("creation failed:", err)
+	}
+	if _, err := elephant.CreateContext(ctx, &StoreCheck{Id: "1"}); err == nil {
+		t.Error("duplicated creation should fail")
+	}
+	request.End()
+
+	spans := recorder.Spans()
+	if len(spans) == 0 || spans[0].Name != "request" {
+		t.Fatal("request span not recorded:", spans)
+	}
+	calls := recorder.Children(spans[0].ID)
+	if len(calls) != 2 || calls[0].Name != "elephant.create" || calls[1].Name != "elephant.create" {
+		t.Fatal("create spans should be children of the request:", calls)
+	}
+	if calls[0].Attributes[tracing.AttributeType] != "StoreCheck" || calls[0].Attributes[tracing.AttributeId] != "1" {
+		t.Error("create span lacks attributes:", calls[0].Attributes)
+	}
+	if calls[0].Err != nil || calls[1].Err == nil {
+		t.Error("only the second creation should record an error:", calls[0].Err, calls[1].Err)
+	}
+	children := recorder.Children(calls[0].ID)
+	if len(children) != 2 || children[0].Name != tracing.SpanQueue || children[1].Name != tracing.SpanExecute {
+		t.Fatal("create span should have queue and execute children:", children)
+	}
+	drivers := recorder.Children(children[1].ID)
+	if len(drivers) != 1 || drivers[0].Name != tracing.SpanDriver || drivers[0].Attributes[tracing.AttributeOperation] != "Create" {
+		t.Error("execute span should have a driver child:", drivers)
+	}
+	for _, span := range recorder.Spans() {
+		if span.End.IsZero() {
+			t.Error("span not ended:", span.Name)
+		}
+	}
+}
+
+// blockingCheck keeps the main routine busy in Validate until release is closed
+type blockingCheck struct {
+	Id string `db:"id"`
+}
+
+var blockingStarted, blockingRelease chan struct{}
+
+func (b *blockingCheck) Validate() error {
+	blockingStarted <- struct{}{}
+	<-blockingRelease
+	return nil
+}
+
+func TestCanceledContext(t *testing.T) {
+	recorder := NewRecorder()
+	elephant.SetTracer(recorder)
+	defer elephant.SetTracer(nil)
+	if err := elephant.Initialize("memory:"); err != nil {
+		t.Fatal("initialization failed:", err)
+	}
+	defer elephant.Close()
+	elephant.Register[StoreCheck]()
+	elephant.Register[blockingCheck]()
+
+	blockingStarted, blockingRelease = make(chan struct{}), make(chan struct{})
+	blocked := make(chan error)
+	go func() {
+		_, err := elephant.Create(&blockingCheck{Id: "1"})
+		blocked <- err
+	}()
+	<-blockingStarted
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan error)
+	go func() {
+		_, err := elephant.CreateContext(ctx, &StoreCheck{Id: "1"})
+		queued <- err
+	}()
+	cancel()
+	if err := <-queued; !errors.Is(err, context.Canceled) {
+		t.Error("queued calls should be abandoned when the context is canceled:", err)
+	}
+	close(blockingRelease)
+	if err := <-blocked; err != nil {
+		t.Error("the running call should finish:", err)
+	}
+	if exists, err := elephant.Exists[StoreCheck]("1"); err != nil || exists {
+		t.Error("abandoned calls should not be executed:", exists, err)
+	}
+	if _, err := elephant.CreateContext(ctx, &StoreCheck{Id: "1"}); !errors.Is(err, context.Canceled) {
+		t.Error("calls with a canceled context should fail:", err)
+	}
+	for name, call := range map[string]func() error{
+		"ExistsContext":     func() error { _, err := elephant.ExistsContext[StoreCheck](ctx, "1"); return err },
+		"FilterContext":     func() error { _, err := elephant.FilterContext[StoreCheck](ctx); return err },
+		"CountContext":      func() error { _, err := elephant.CountContext[StoreCheck](ctx); return err },
+		"PageContext":       func() error { _, _, err := elephant.PageContext[StoreCheck](ctx, "", 10); return err },
+		"BlobExistsContext": func() error { _, err := elephant.BlobExistsContext(ctx, "1"); return err },
+	} {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Error(name, "with a canceled context should fail:", err)
+		}
+	}
+	for _, span := range recorder.Spans() {
+		if span.End.IsZero() {
+			t.Error("span not ended:", span.Name)
+		}
+	}
+}
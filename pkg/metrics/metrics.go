@@ -0,0 +1,38 @@
+// Package metrics defines the interface used by elephant to report how the
+// store behaves, and an adapter exposing the measurements in Prometheus format
+package metrics
+
+// Label qualifies a measurement
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Metrics receives the measurements. Implementations must be safe for concurrent use
+type Metrics interface {
+	// Add increments a counter
+	Add(name string, value float64, labels ...Label)
+	// Observe records a value in a histogram
+	Observe(name string, value float64, labels ...Label)
+	// Set changes the value of a gauge
+	Set(name string, value float64, labels ...Label)
+}
+
+// Instrumented is implemented by drivers which report their own measurements
+type Instrumented interface {
+	SetMetrics(m Metrics)
+}
+
+// Names of the measurements reported by elephant
+const (
+	ActionsTotal      = "elephant_actions_total"         // counter of actions by action
+	ActionErrorsTotal = "elephant_action_errors_total"   // counter of failed actions by action
+	ActionSeconds     = "elephant_action_seconds"        // histogram of the execution time by action
+	QueueSeconds      = "elephant_queue_seconds"         // histogram of the time waited before execution by action
+	QueueDepth        = "elephant_queue_depth"           // gauge of the actions waiting to be executed
+	CachedObjects     = "elephant_cached_objects"        // gauge of the objects cached by type
+	DriverSeconds     = "elephant_driver_seconds"        // histogram of the driver calls by operation
+	DriverErrorsTotal = "elephant_driver_errors_total"   // counter of failed driver calls by operation
+	StatementSeconds  = "elephant_sql_statement_seconds" // histogram of every SQL attempt by operation
+	StatementRetries  = "elephant_sql_retries_total"     // counter of repeated SQL attempts by operation
+)
@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of the histograms, in seconds
+var DefaultBuckets = []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricKind int
+
+const (
+	kindCounter metricKind = iota
+	kindGauge
+	kindHistogram
+)
+
+type histogram struct {
+	counts []uint64 // one per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+type series struct {
+	raw       []Label
+	labels    string // formatted raw labels
+	value     float64
+	histogram *histogram
+}
+
+type family struct {
+	kind   metricKind
+	series map[string]*series
+}
+
+// Prometheus keeps the measurements in memory and serves them in the
+// Prometheus text format. It implements Metrics and http.Handler
+type Prometheus struct {
+	mutex    sync.Mutex
+	buckets  []float64
+	families map[string]*family
+}
+
+// NewPrometheus creates an empty Prometheus adapter using DefaultBuckets
+func NewPrometheus() *Prometheus {
+	return &Prometheus{buckets: DefaultBuckets, families: make(map[string]*family)}
+}
+
+// formatLabels returns the labels sorted and escaped, between braces
+func formatLabels(labels []Label, extra ...Label) string {
+	labels = append(slices.Clone(labels), extra...)
+	if len(labels) == 0 {
+		return ""
+	}
+	slices.SortStableFunc(labels, func(first, second Label) int {
+		return strings.Compare(first.Name, second.Name)
+	})
+	parts := make([]string, len(labels))
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i, label := range labels {
+		parts[i] = label.Name + `="` + replacer.Replace(label.Value) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// get returns the series, creating it if needed. The mutex must be held
+func (p *Prometheus) get(name string, kind metricKind, labels []Label) *series {
+	f := p.families[name]
+	if f == nil {
+		f = &family{kind: kind, series: make(map[string]*series)}
+		p.families[name] = f
+	}
+	formatted := formatLabels(labels)
+	s := f.series[formatted]
+	if s == nil {
+		s = &series{raw: slices.Clone(labels), labels: formatted}
+		if kind == kindHistogram {
+			s.histogram = &histogram{counts: make([]uint64, len(p.buckets))}
+		}
+		f.series[formatted] = s
+	}
+	return s
+}
+
+func (p *Prometheus) Add(name string, value float64, labels ...Label) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.get(name, kindCounter, labels).value += value
+}
+
+func (p *Prometheus) Set(name string, value float64, labels ...Label) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.get(name, kindGauge, labels).value = value
+}
+
+func (p *Prometheus) Observe(name string, value float64, labels ...Label) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	h := p.get(name, kindHistogram, labels).histogram
+	if h == nil {
+		return // name already used by other kind of metric
+	}
+	h.count++
+	h.sum += value
+	if i, _ := slices.BinarySearch(p.buckets, value); i < len(p.buckets) {
+		h.counts[i]++
+	}
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// WriteTo writes every measurement in the Prometheus text format
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	buffer := bufio.NewWriter(w)
+	counter := &countingWriter{w: buffer}
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		f := p.families[name]
+		kind := [...]string{kindCounter: "counter", kindGauge: "gauge", kindHistogram: "histogram"}[f.kind]
+		fmt.Fprintf(counter, "# TYPE %s %s\n", name, kind)
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if s.histogram == nil {
+				fmt.Fprintf(counter, "%s%s %s\n", name, s.labels, formatValue(s.value))
+				continue
+			}
+			cumulative := uint64(0)
+			for i, bound := range p.buckets {
+				cumulative += s.histogram.counts[i]
+				fmt.Fprintf(counter, "%s_bucket%s %d\n", name, formatLabels(s.raw, Label{"le", formatValue(bound)}), cumulative)
+			}
+			fmt.Fprintf(counter, "%s_bucket%s %d\n", name, formatLabels(s.raw, Label{"le", "+Inf"}), s.histogram.count)
+			fmt.Fprintf(counter, "%s_sum%s %s\n", name, s.labels, formatValue(s.histogram.sum))
+			fmt.Fprintf(counter, "%s_count%s %d\n", name, s.labels, s.histogram.count)
+		}
+	}
+	if counter.err == nil {
+		counter.err = buffer.Flush()
+	}
+	return counter.written, counter.err
+}
+
+// ServeHTTP serves the measurements to Prometheus scrapers
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+type countingWriter struct {
+	w       io.Writer
+	written int64
+	err     error
+}
+
+func (c *countingWriter) Write(data []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(data)
+	c.written += int64(n)
+	c.err = err
+	return n, err
+}
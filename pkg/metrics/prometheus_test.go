@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus()
+	p.Add("requests_total", 1, Label{"method", "get"})
+	p.Add("requests_total", 2, Label{"method", "get"})
+	p.Add("requests_total", 1, Label{"method", "post"}, Label{"code", `5"0\0`})
+	p.Set("depth", 3)
+	p.Set("depth", 1)
+	p.Observe("latency_seconds", 0.003, Label{"op", "read"})
+	p.Observe("latency_seconds", 0.2, Label{"op", "read"})
+	p.Observe("latency_seconds", 100, Label{"op", "read"})
+
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	output := recorder.Body.String()
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Error("wrong content type:", recorder.Header().Get("Content-Type"))
+	}
+	for _, expected := range []string{
+		"# TYPE depth gauge\ndepth 1\n",
+		"# TYPE requests_total counter\n",
+		`requests_total{method="get"} 3` + "\n",
+		`requests_total{code="5\"0\\0",method="post"} 1` + "\n",
+		"# TYPE latency_seconds histogram\n",
+		`latency_seconds_bucket{le="0.001",op="read"} 0` + "\n",
+		`latency_seconds_bucket{le="0.005",op="read"} 1` + "\n",
+		`latency_seconds_bucket{le="0.25",op="read"} 2` + "\n",
+		`latency_seconds_bucket{le="10",op="read"} 2` + "\n",
+		`latency_seconds_bucket{le="+Inf",op="read"} 3` + "\n",
+		`latency_seconds_sum{op="read"} 100.203` + "\n",
+		`latency_seconds_count{op="read"} 3` + "\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("output does not contain %q:\n%s", expected, output)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Package server exposes the types managed by elephant and its blobs over HTTP.
+// Objects are JSON resources under /types/{Type} and blobs are raw bytes under
+// /blobs/{id}. Writes go through elephant, so they run the same hooks and checks
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/gonimals/elephant/internal/util"
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/elephant"
+)
+
+// DefaultPageSize is the number of objects listed when the request has no limit
+const DefaultPageSize = 100
+
+// MaxPageSize is the maximum number of objects listed by one request
+const MaxPageSize = 1000
+
+// Server is an http.Handler serving the registered types and the blobs.
+// elephant must be initialized before registering types and serving requests
+type Server struct {
+	mux   *http.ServeMux
+	mutex sync.Mutex
+	types map[string]bool
+}
+
+// Page is the response when listing a type
+type Page[T any] struct {
+	Items []*T `json:"items"`
+	// Next is the after parameter of the following page, empty on the last one
+	Next string `json:"next,omitempty"`
+}
+
+// errorResponse is the body of failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// New creates a server with the blob routes:
+//
+//	GET /blobs/{id}     returns the blob
+//	PUT /blobs/{id}     creates or replaces the blob with the request body
+//	DELETE /blobs/{id}  removes the blob
+func New() *Server {
+	s := &Server{mux: http.NewServeMux(), types: make(map[string]bool)}
+	s.mux.HandleFunc("GET /blobs/{id}", getBlob)
+	s.mux.HandleFunc("PUT /blobs/{id}", putBlob)
+	s.mux.HandleFunc("DELETE /blobs/{id}", deleteBlob)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Register makes elephant manage T and serves it under /types/{T}, where T is the name of the struct:
+//
+//	GET /types/T?after=&limit=  lists the objects in id order (see elephant.Page)
+//	POST /types/T               creates an object, generating its id if empty
+//	GET /types/T/{id}           returns the object
+//	PUT /types/T/{id}           creates or replaces the object
+//	PATCH /types/T/{id}         replaces the attributes present in the request body
+//	DELETE /types/T/{id}        removes the object
+func Register[T any](s *Server) error {
+	if err := elephant.Register[T](); err != nil {
+		return err
+	}
+	learntType, err := util.ExamineType(reflect.TypeFor[*T]())
+	if err != nil {
+		return err
+	}
+	name := learntType.Name
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.types[name] {
+		return errors.New("server: type already registered: " + name)
+	}
+	s.types[name] = true
+	h := &typeHandler[T]{idField: learntType.Id, path: "/types/" + name}
+	s.mux.HandleFunc("GET "+h.path, h.list)
+	s.mux.HandleFunc("POST "+h.path, h.create)
+	s.mux.HandleFunc("GET "+h.path+"/{id}", h.get)
+	s.mux.HandleFunc("PUT "+h.path+"/{id}", h.put)
+	s.mux.HandleFunc("PATCH "+h.path+"/{id}", h.patch)
+	s.mux.HandleFunc("DELETE "+h.path+"/{id}", h.delete)
+	return nil
+}
+
+// typeHandler serves the objects of T
+type typeHandler[T any] struct {
+	idField string
+	path    string
+}
+
+func (h *typeHandler[T]) id(object *T) string {
+	return reflect.ValueOf(object).Elem().FieldByName(h.idField).String()
+}
+
+func (h *typeHandler[T]) setID(object *T, id string) {
+	reflect.ValueOf(object).Elem().FieldByName(h.idField).SetString(id)
+}
+
+func (h *typeHandler[T]) list(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxPageSize {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(MaxPageSize))
+			return
+		}
+	}
+	objects, next, err := elephant.PageContext[T](r.Context(), r.URL.Query().Get("after"), limit)
+	if err != nil {
+		writeElephantError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Page[T]{Items: objects, Next: next})
+}
+
+func (h *typeHandler[T]) get(w http.ResponseWriter, r *http.Request) {
+	object, err := elephant.RetrieveContext[T](r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeElephantError(w, err)
+	} else if object == nil {
+		writeError(w, http.StatusNotFound, "not found")
+	} else {
+		writeJSON(w, http.StatusOK, object)
+	}
+}
+
+func (h *typeHandler[T]) create(w http.ResponseWriter, r *http.Request) {
+	object := new(T)
+	if !readJSON(w, r, object) {
+		return
+	}
+	id, err := elephant.CreateContext(r.Context(), object)
+	if err != nil {
+		writeElephantError(w, err)
+		return
+	}
+	w.Header().Set("Location", h.path+"/"+url.PathEscape(id))
+	writeJSON(w, http.StatusCreated, object)
+}
+
+func (h *typeHandler[T]) put(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	object := new(T)
+	if !readJSON(w, r, object) {
+		return
+	}
+	if h.id(object) != "" && h.id(object) != id {
+		writeError(w, http.StatusBadRequest, "the id of the body does not match the path")
+		return
+	}
+	h.setID(object, id)
+	_, created, err := elephant.UpsertCreatedContext(r.Context(), object)
+	if err != nil {
+		writeElephantError(w, err)
+	} else if created {
+		writeJSON(w, http.StatusCreated, object)
+	} else {
+		writeJSON(w, http.StatusOK, object)
+	}
+}
+
+// patch merges the body into the stored object with elephant.Modify, so the merge
+// and the write happen in one action and concurrent patches keep each other's changes
+func (h *typeHandler[T]) patch(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, db.MaxValueLength))
+	if err != nil {
+		writeReadError(w, err)
+		return
+	}
+	object, err := elephant.ModifyContext(r.Context(), id, func(object *T) error {
+		if err := decodeJSON(bytes.NewReader(body), object); err != nil {
+			return badRequestError{err}
+		}
+		if h.id(object) == "" {
+			h.setID(object, id) // an empty id leaves it unchanged
+		} else if h.id(object) != id {
+			return badRequestError{errors.New("the id cannot be changed")}
+		}
+		return nil
+	})
+	var badRequest badRequestError
+	if errors.As(err, &badRequest) {
+		writeError(w, http.StatusBadRequest, badRequest.Error())
+	} else if err != nil {
+		writeElephantError(w, err)
+	} else {
+		writeJSON(w, http.StatusOK, object)
+	}
+}
+
+func (h *typeHandler[T]) delete(w http.ResponseWriter, r *http.Request) {
+	if err := elephant.RemoveByIdContext[T](r.Context(), r.PathValue("id")); err != nil {
+		writeElephantError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getBlob(w http.ResponseWriter, r *http.Request) {
+	blob, err := elephant.BlobRetrieveContext(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeElephantError(w, err)
+		return
+	} else if blob == nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(*blob)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(*blob)
+}
+
+func putBlob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	contents, err := io.ReadAll(http.MaxBytesReader(w, r.Body, db.MaxBlobLength))
+	if err != nil {
+		writeReadError(w, err)
+		return
+	}
+	created, err := elephant.BlobUpsertCreatedContext(r.Context(), id, &contents)
+	if err != nil {
+		writeElephantError(w, err)
+	} else if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func deleteBlob(w http.ResponseWriter, r *http.Request) {
+	if err := elephant.BlobRemoveContext(r.Context(), r.PathValue("id")); err != nil {
+		writeElephantError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readJSON decodes the request body into object, rejecting unknown attributes.
+// It writes the error response and returns false if the body is not valid
+func readJSON(w http.ResponseWriter, r *http.Request, object any) bool {
+	if err := decodeJSON(http.MaxBytesReader(w, r.Body, db.MaxValueLength), object); err != nil {
+		writeReadError(w, err)
+		return false
+	}
+	return true
+}
+
+// decodeJSON decodes body into object, rejecting unknown attributes
+func decodeJSON(body io.Reader, object any) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(object)
+}
+
+// badRequestError is returned from the modify function of patch when the body is not valid
+type badRequestError struct {
+	err error
+}
+
+func (e badRequestError) Error() string {
+	return e.err.Error()
+}
+
+func writeReadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+	} else {
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// writeElephantError writes the response for an error returned by elephant
+func writeElephantError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, elephant.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, elephant.ErrConflict):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, elephant.ErrInvalid):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case db.IsTransient(err):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
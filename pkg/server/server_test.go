@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gonimals/elephant/pkg/db"
+	"github.com/gonimals/elephant/pkg/elephant"
+)
+
+type Author struct {
+	Id   string `db:"id"`
+	Name string
+}
+
+type Book struct {
+	Id       string `db:"id"`
+	Title    string
+	Pages    int
+	AuthorID string `db:"ref=Author"`
+}
+
+func (b *Book) Validate() error {
+	if b.Title == "" {
+		return errors.New("books need a title")
+	}
+	return nil
+}
+
+// request performs a request against the server, returning the status and the body
+func request(t *testing.T, handler http.Handler, method string, path string, body string) (int, string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	output, _ := io.ReadAll(recorder.Result().Body)
+	return recorder.Code, string(output)
+}
+
+func TestServer(t *testing.T) {
+	if err := elephant.Initialize("memory:"); err != nil {
+		t.Fatal("initialization failed:", err)
+	}
+	defer elephant.Close()
+	s := New()
+	if err := Register[Author](s); err != nil {
+		t.Fatal("registration failed:", err)
+	}
+	if err := Register[Book](s); err != nil {
+		t.Fatal("registration failed:", err)
+	}
+	if err := Register[Book](s); err == nil {
+		t.Error("types cannot be registered twice")
+	}
+
+	for _, check := range []struct {
+		method, path, body string
+		status             int
+		contains           string
+	}{
+		{"PUT", "/types/Author/a1", `{"Name":"Ursula"}`, http.StatusCreated, `"Id":"a1"`},
+		{"PUT", "/types/Author/a1", `{"Name":"Ursula K."}`, http.StatusOK, `"Ursula K."`},
+		{"PUT", "/types/Author/a2", `{"Id":"a3"}`, http.StatusBadRequest, "does not match"},
+		{"PUT", "/types/Author/a2", `{"Unknown":1}`, http.StatusBadRequest, "unknown field"},
+		{"PUT", "/types/Author/a2", `{`, http.StatusBadRequest, ""},
+		{"POST", "/types/Book", `{"Id":"b1","Title":"Earthsea","AuthorID":"a1"}`, http.StatusCreated, `"Title":"Earthsea"`},
+		{"POST", "/types/Book", `{"Id":"b1","Title":"Again"}`, http.StatusConflict, "conflict"},
+		{"POST", "/types/Book", `{"Title":""}`, http.StatusUnprocessableEntity, "books need a title"},
+		{"POST", "/types/Book", `{"Title":"Orphan","AuthorID":"unknown"}`, http.StatusUnprocessableEntity, "unexistent"},
+		{"POST", "/types/Book", `{"Id":"b2","Title":"Second"}`, http.StatusCreated, `"Title":"Second"`},
+		{"PATCH", "/types/Book/b1", `{"Pages":183}`, http.StatusOK, `"Title":"Earthsea","Pages":183`},
+		{"PATCH", "/types/Book/b1", `{"Id":"b2"}`, http.StatusBadRequest, "cannot be changed"},
+		{"PATCH", "/types/Book/b1", `{"Id":"","Pages":184}`, http.StatusOK, `"Id":"b1"`},
+		{"PATCH", "/types/Book/b9", `{"Pages":1}`, http.StatusNotFound, ""},
+		{"GET", "/types/Book/b1", "", http.StatusOK, `"Pages":184`},
+		{"GET", "/types/Book/b9", "", http.StatusNotFound, "not found"},
+		{"GET", "/types/Book?limit=1", "", http.StatusOK, `"next":"b1"`},
+		{"GET", "/types/Book?limit=0", "", http.StatusBadRequest, "limit"},
+		{"GET", "/types/Unknown/1", "", http.StatusNotFound, ""},
+		{"DELETE", "/types/Author/a1", "", http.StatusConflict, "referenced"},
+		{"DELETE", "/types/Book/b1", "", http.StatusNoContent, ""},
+		{"DELETE", "/types/Book/b1", "", http.StatusNotFound, ""},
+		{"PUT", "/blobs/logo", "\x89PNG", http.StatusCreated, ""},
+		{"PUT", "/blobs/logo", "\x89PNG\r\n", http.StatusNoContent, ""},
+		{"GET", "/blobs/logo", "", http.StatusOK, "\x89PNG\r\n"},
+		{"GET", "/blobs/missing", "", http.StatusNotFound, ""},
+		{"PUT", "/blobs/big", strings.Repeat("A", db.MaxBlobLength+1), http.StatusRequestEntityTooLarge, ""},
+		{"DELETE", "/blobs/logo", "", http.StatusNoContent, ""},
+		{"DELETE", "/blobs/logo", "", http.StatusNotFound, ""},
+	} {
+		status, body := request(t, s, check.method, check.path, check.body)
+		if status != check.status || !strings.Contains(body, check.contains) {
+			t.Errorf("%s %s gives %d %q, expected %d containing %q", check.method, check.path, status, body, check.status, check.contains)
+		}
+	}
+
+	var page Page[Book]
+	_, body := request(t, s, "GET", "/types/Book", "")
+	if err := json.Unmarshal([]byte(body), &page); err != nil || len(page.Items) != 1 || page.Next != "" {
+		t.Error("list gives wrong page:", body)
+	}
+	elephant.BlobCreate("sized", &[]byte{1, 2, 3})
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest("GET", "/blobs/sized", nil))
+	if recorder.Header().Get("Content-Length") != "3" || !bytes.Equal(recorder.Body.Bytes(), []byte{1, 2, 3}) {
+		t.Error("blobs are not served with their length:", recorder.Header())
+	}
+}
+
+func TestConcurrentPut(t *testing.T) {
+	if err := elephant.Initialize("memory:"); err != nil {
+		t.Fatal("initialization failed:", err)
+	}
+	defer elephant.Close()
+	s := New()
+	if err := Register[Author](s); err != nil {
+		t.Fatal("registration failed:", err)
+	}
+	const requests = 20
+	statuses := make(chan int, requests)
+	var wg sync.WaitGroup
+	for range requests {
+		wg.Go(func() {
+			status, _ := request(t, s, "PUT", "/types/Author/a1", `{"Name":"Ursula"}`)
+			statuses <- status
+		})
+	}
+	wg.Wait()
+	close(statuses)
+	created := 0
+	for status := range statuses {
+		if status == http.StatusCreated {
+			created++
+		} else if status != http.StatusOK {
+			t.Error("unexpected status:", status)
+		}
+	}
+	if created != 1 {
+		t.Error("exactly one PUT should create the object, got", created)
+	}
+}
+
+// Shelf is patched concurrently, each request adding one book to the map
+type Shelf struct {
+	Id    string `db:"id"`
+	Books map[string]bool
+}
+
+func TestConcurrentPatch(t *testing.T) {
+	if err := elephant.Initialize("memory:"); err != nil {
+		t.Fatal("initialization failed:", err)
+	}
+	defer elephant.Close()
+	s := New()
+	if err := Register[Shelf](s); err != nil {
+		t.Fatal("registration failed:", err)
+	}
+	if status, body := request(t, s, "PUT", "/types/Shelf/s1", `{"Books":{}}`); status != http.StatusCreated {
+		t.Fatal("creation failed:", status, body)
+	}
+	const requests = 50
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Go(func() {
+			body := fmt.Sprintf(`{"Books":{"b%d":true}}`, i)
+			if status, body := request(t, s, "PATCH", "/types/Shelf/s1", body); status != http.StatusOK {
+				t.Error("patch failed:", status, body)
+			}
+		})
+	}
+	wg.Wait()
+	shelf, err := elephant.Retrieve[Shelf]("s1")
+	if err != nil || shelf == nil || len(shelf.Books) != requests {
+		t.Error("concurrent patches lost changes:", shelf, err)
+	}
+}
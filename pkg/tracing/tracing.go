@@ -0,0 +1,56 @@
+// Package tracing defines a small tracer interface, similar to the OpenTelemetry one,
+// used by elephant to open spans for its operations. Adapters to other tracing
+// libraries only need to implement Tracer and Span
+package tracing
+
+import "context"
+
+// Attribute describes a span
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Tracer opens spans
+type Tracer interface {
+	// Start opens a span, child of the span in ctx if any, and returns a context containing it
+	Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span)
+}
+
+// Span is an operation being traced. Spans can be ended from other goroutines
+type Span interface {
+	SetAttributes(attributes ...Attribute)
+	// RecordError marks the span as failed
+	RecordError(err error)
+	End()
+}
+
+// Noop is a Tracer which records nothing
+type Noop struct{}
+
+func (Noop) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attributes ...Attribute) {}
+func (noopSpan) RecordError(err error)                 {}
+func (noopSpan) End()                                  {}
+
+// Names of the spans opened by elephant. Public calls open a span named
+// after the action, like "elephant.create", with the following children
+const (
+	SpanQueue   = "elephant.queue"   // time waited in the queue of actions
+	SpanExecute = "elephant.execute" // execution of the action
+	SpanDriver  = "elephant.driver"  // each driver call, with the operation as attribute
+)
+
+// Keys of the attributes set by elephant
+const (
+	AttributeType      = "elephant.type"
+	AttributeAction    = "elephant.action"
+	AttributeId        = "elephant.id"
+	AttributeOperation = "elephant.operation"
+	AttributeTable     = "elephant.table"
+)